@@ -0,0 +1,231 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/serializer"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	configv1alpha1 "k8s.io/component-base/config/v1alpha1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+)
+
+// Defaults of the configuration, used for the settings left out of both the
+// file and the flags.
+const (
+	DefaultMetricsBindAddress      = ":8080"
+	DefaultHealthProbeBindAddress  = ":8081"
+	DefaultWebhookPort             = 9443
+	DefaultMaxConcurrentReconciles = 1
+	DefaultSyncPeriod              = 10 * time.Hour
+)
+
+// Default returns the configuration used when there is no file, electing the
+// leader through the lease named leaderElectionID.
+func Default(leaderElectionID string) *ControllerManagerConfig {
+	leaderElect := false
+	port := DefaultWebhookPort
+	c := &ControllerManagerConfig{
+		TypeMeta:                metav1.TypeMeta{APIVersion: GroupVersion.String(), Kind: "ControllerManagerConfig"},
+		MaxConcurrentReconciles: DefaultMaxConcurrentReconciles,
+	}
+	c.SyncPeriod = &metav1.Duration{Duration: DefaultSyncPeriod}
+	c.LeaderElection = &configv1alpha1.LeaderElectionConfiguration{
+		LeaderElect:  &leaderElect,
+		ResourceName: leaderElectionID,
+	}
+	c.Metrics.BindAddress = DefaultMetricsBindAddress
+	c.Health.HealthProbeBindAddress = DefaultHealthProbeBindAddress
+	c.Webhook.Port = &port
+	c.Tracing.SampleRatio = 1
+	return c
+}
+
+// BindFlags binds the flags overriding the configuration to fs. Their
+// values are read back by Load.
+func BindFlags(fs *flag.FlagSet) {
+	Default("").bindFlags(fs)
+}
+
+func (c *ControllerManagerConfig) bindFlags(fs *flag.FlagSet) {
+	fs.StringVar(&c.Metrics.BindAddress, "metrics-bind-address", c.Metrics.BindAddress, "The address the metric endpoint binds to.")
+	fs.StringVar(&c.Health.HealthProbeBindAddress, "health-probe-bind-address", c.Health.HealthProbeBindAddress, "The address the probe endpoint binds to.")
+	fs.BoolVar(c.LeaderElection.LeaderElect, "leader-elect", *c.LeaderElection.LeaderElect,
+		"Enable leader election for controller manager. "+
+			"Enabling this will ensure there is only one active controller manager.")
+	fs.StringVar(&c.PrometheusAddress, "prometheus-address", c.PrometheusAddress,
+		"The address of the Prometheus server the autoscaler reads the metrics from, e.g. http://prometheus.monitoring:9090. "+
+			"Nothing is autoscaled without it.")
+	fs.StringVar(&c.DefaultImage, "default-image", c.DefaultImage, "The image of the containers that do not name one.")
+	fs.IntVar(&c.MaxConcurrentReconciles, "max-concurrent-reconciles", c.MaxConcurrentReconciles,
+		"The number of objects reconciled at the same time.")
+	fs.DurationVar(&c.SyncPeriod.Duration, "sync-period", c.SyncPeriod.Duration, "How often every object is resynced.")
+	fs.Var(stringList{&c.WatchNamespaces}, "watch-namespaces",
+		"A comma separated list of the namespaces the operator watches. All namespaces are watched when empty.")
+	// Binding resets the tracing options to the defaults of the flags
+	tracingOpts := c.Tracing
+	c.Tracing.BindFlags(fs)
+	c.Tracing = tracingOpts
+}
+
+// Load returns the configuration read from the file at path, if any, with
+// the flags set in fs taking precedence over it. The flags must have been
+// bound by BindFlags and parsed. The configuration is validated. The leader
+// is elected through the lease named leaderElectionID unless the file names
+// another one.
+func Load(path, leaderElectionID string, fs *flag.FlagSet) (*ControllerManagerConfig, error) {
+	c := Default(leaderElectionID)
+	if path != "" {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("reading the configuration: %w", err)
+		}
+		decoder := serializer.NewCodecFactory(scheme, serializer.EnableStrict).UniversalDeserializer()
+		if _, _, err := decoder.Decode(data, nil, c); err != nil {
+			return nil, fmt.Errorf("decoding the configuration %s: %w", path, err)
+		}
+		// Omitted by the file, but bound to the flags
+		if c.SyncPeriod == nil {
+			c.SyncPeriod = &metav1.Duration{Duration: DefaultSyncPeriod}
+		}
+		if c.LeaderElection == nil {
+			c.LeaderElection = &configv1alpha1.LeaderElectionConfiguration{ResourceName: leaderElectionID}
+		}
+		if c.LeaderElection.LeaderElect == nil {
+			leaderElect := false
+			c.LeaderElection.LeaderElect = &leaderElect
+		}
+	}
+
+	// Set the flags given on the command line again, on top of the file
+	overrides := flag.NewFlagSet("overrides", flag.ContinueOnError)
+	c.bindFlags(overrides)
+	var err error
+	fs.Visit(func(f *flag.Flag) {
+		if err == nil && overrides.Lookup(f.Name) != nil {
+			err = overrides.Set(f.Name, f.Value.String())
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := c.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid configuration: %w", err)
+	}
+	return c, nil
+}
+
+// Validate returns the errors of the configuration, if any.
+func (c *ControllerManagerConfig) Validate() error {
+	var allErrs field.ErrorList
+	if c.MaxConcurrentReconciles < 1 {
+		allErrs = append(allErrs, field.Invalid(field.NewPath("maxConcurrentReconciles"), c.MaxConcurrentReconciles, "must be at least 1"))
+	}
+	if c.SyncPeriod != nil && c.SyncPeriod.Duration <= 0 {
+		allErrs = append(allErrs, field.Invalid(field.NewPath("syncPeriod"), c.SyncPeriod.Duration.String(), "must be positive"))
+	}
+	if port := c.Webhook.Port; port != nil && (*port < 1 || *port > 65535) {
+		allErrs = append(allErrs, field.Invalid(field.NewPath("webhook", "port"), *port, "must be between 1 and 65535"))
+	}
+	if le := c.LeaderElection; le != nil && le.LeaderElect != nil && *le.LeaderElect && le.ResourceName == "" {
+		allErrs = append(allErrs, field.Required(field.NewPath("leaderElection", "resourceName"), "is required for leader election"))
+	}
+
+	namespacesPath := field.NewPath("watchNamespaces")
+	if c.CacheNamespace != "" && len(c.WatchNamespaces) > 0 {
+		allErrs = append(allErrs, field.Forbidden(namespacesPath, "may not be set along with cacheNamespace"))
+	}
+	seen := sets.NewString()
+	for i, namespace := range c.WatchNamespaces {
+		for _, msg := range validation.IsDNS1123Label(namespace) {
+			allErrs = append(allErrs, field.Invalid(namespacesPath.Index(i), namespace, msg))
+		}
+		if seen.Has(namespace) {
+			allErrs = append(allErrs, field.Duplicate(namespacesPath.Index(i), namespace))
+		}
+		seen.Insert(namespace)
+	}
+
+	if c.DefaultImage != "" {
+		// The default image must be allowed by the policy it falls back to
+		if err := c.ImagePolicy.Check(c.DefaultImage); err != nil {
+			allErrs = append(allErrs, field.Invalid(field.NewPath("defaultImage"), c.DefaultImage, err.Error()))
+		}
+	}
+	if ratio := c.Tracing.SampleRatio; ratio < 0 || ratio > 1 {
+		allErrs = append(allErrs, field.Invalid(field.NewPath("tracing", "sampleRatio"), ratio, "must be between 0 and 1"))
+	}
+	return allErrs.ToAggregate()
+}
+
+// Namespaces returns the namespaces watched by the operator, none for all
+// namespaces.
+func (c *ControllerManagerConfig) Namespaces() []string {
+	if c.CacheNamespace != "" {
+		return []string{c.CacheNamespace}
+	}
+	return c.WatchNamespaces
+}
+
+// ManagerOptions returns the options of the manager.
+func (c *ControllerManagerConfig) ManagerOptions(scheme *runtime.Scheme) (ctrl.Options, error) {
+	opts, err := ctrl.Options{Scheme: scheme}.AndFrom(c)
+	if err != nil {
+		return opts, err
+	}
+	switch namespaces := c.Namespaces(); len(namespaces) {
+	case 0:
+	case 1:
+		opts.Namespace = namespaces[0]
+	default:
+		opts.NewCache = cache.MultiNamespacedCacheBuilder(namespaces)
+	}
+	return opts, nil
+}
+
+// stringList is a flag.Value holding a comma separated list of strings.
+type stringList struct {
+	values *[]string
+}
+
+func (l stringList) String() string {
+	if l.values == nil {
+		return ""
+	}
+	return strings.Join(*l.values, ",")
+}
+
+func (l stringList) Set(s string) error {
+	*l.values = nil
+	for _, value := range strings.Split(s, ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			*l.values = append(*l.values, value)
+		}
+	}
+	return nil
+}
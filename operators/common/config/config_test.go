@@ -0,0 +1,245 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// testLeaderElectionID is the name of the lease of the operator under test.
+const testLeaderElectionID = "061dfbf1.mriyam.dev"
+
+const testConfig = `apiVersion: controller-runtime.sigs.k8s.io/v1alpha1
+kind: ControllerManagerConfig
+syncPeriod: 1h
+metrics:
+  bindAddress: 127.0.0.1:8080
+leaderElection:
+  leaderElect: true
+  resourceName: 061dfbf1.mriyam.dev
+defaultImage: nginx:1.21
+maxConcurrentReconciles: 4
+watchNamespaces: [team-a, team-b]
+imagePolicy:
+  forbidLatest: true
+tracing:
+  endpoint: otel-collector:4317
+  sampleRatio: 0.5
+`
+
+// load writes data to a config file, unless empty, and loads it along with
+// the args.
+func load(t *testing.T, data string, args ...string) (*ControllerManagerConfig, error) {
+	t.Helper()
+	var path string
+	if data != "" {
+		path = filepath.Join(t.TempDir(), "controller_manager_config.yaml")
+		if err := ioutil.WriteFile(path, []byte(data), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	BindFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+	return Load(path, testLeaderElectionID, fs)
+}
+
+func TestLoadDefaults(t *testing.T) {
+	c, err := load(t, "")
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if want := Default(testLeaderElectionID); !reflect.DeepEqual(c, want) {
+		t.Errorf("Load() = %+v, want the defaults %+v", c, want)
+	}
+}
+
+func TestLoadFile(t *testing.T) {
+	c, err := load(t, testConfig)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if c.SyncPeriod.Duration != time.Hour {
+		t.Errorf("SyncPeriod = %v, want 1h", c.SyncPeriod.Duration)
+	}
+	if c.Metrics.BindAddress != "127.0.0.1:8080" {
+		t.Errorf("Metrics.BindAddress = %q, want 127.0.0.1:8080", c.Metrics.BindAddress)
+	}
+	if !*c.LeaderElection.LeaderElect {
+		t.Error("LeaderElection.LeaderElect = false, want true")
+	}
+	if c.DefaultImage != "nginx:1.21" {
+		t.Errorf("DefaultImage = %q, want nginx:1.21", c.DefaultImage)
+	}
+	if c.MaxConcurrentReconciles != 4 {
+		t.Errorf("MaxConcurrentReconciles = %d, want 4", c.MaxConcurrentReconciles)
+	}
+	if want := []string{"team-a", "team-b"}; !reflect.DeepEqual(c.WatchNamespaces, want) {
+		t.Errorf("WatchNamespaces = %v, want %v", c.WatchNamespaces, want)
+	}
+	if c.ImagePolicy == nil || !c.ImagePolicy.ForbidLatest {
+		t.Errorf("ImagePolicy = %+v, want latest images forbidden", c.ImagePolicy)
+	}
+	if c.Tracing.Endpoint != "otel-collector:4317" || c.Tracing.SampleRatio != 0.5 {
+		t.Errorf("Tracing = %+v, want the endpoint and sample ratio of the file", c.Tracing)
+	}
+	// Left out of the file
+	if c.Health.HealthProbeBindAddress != DefaultHealthProbeBindAddress {
+		t.Errorf("Health.HealthProbeBindAddress = %q, want the default %q", c.Health.HealthProbeBindAddress, DefaultHealthProbeBindAddress)
+	}
+	if *c.Webhook.Port != DefaultWebhookPort {
+		t.Errorf("Webhook.Port = %d, want the default %d", *c.Webhook.Port, DefaultWebhookPort)
+	}
+}
+
+func TestLoadFlagsOverrideFile(t *testing.T) {
+	c, err := load(t, testConfig,
+		"--max-concurrent-reconciles=2",
+		"--watch-namespaces=team-c",
+		"--leader-elect=false",
+		"--tracing-sample-ratio=0.1",
+	)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if c.MaxConcurrentReconciles != 2 {
+		t.Errorf("MaxConcurrentReconciles = %d, want 2 from the flags", c.MaxConcurrentReconciles)
+	}
+	if want := []string{"team-c"}; !reflect.DeepEqual(c.WatchNamespaces, want) {
+		t.Errorf("WatchNamespaces = %v, want %v from the flags", c.WatchNamespaces, want)
+	}
+	if *c.LeaderElection.LeaderElect {
+		t.Error("LeaderElection.LeaderElect = true, want false from the flags")
+	}
+	if c.Tracing.SampleRatio != 0.1 {
+		t.Errorf("Tracing.SampleRatio = %v, want 0.1 from the flags", c.Tracing.SampleRatio)
+	}
+	// Not given as flags
+	if c.DefaultImage != "nginx:1.21" || c.SyncPeriod.Duration != time.Hour {
+		t.Errorf("DefaultImage, SyncPeriod = %q, %v, want the ones of the file", c.DefaultImage, c.SyncPeriod.Duration)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		args    []string
+		wantErr string
+	}{
+		{
+			name:    "unknown field",
+			data:    testConfig + "maxConcurrentReconcile: 2\n",
+			wantErr: "unknown field",
+		},
+		{
+			name:    "wrong kind",
+			data:    strings.Replace(testConfig, "kind: ControllerManagerConfig", "kind: Deployment", 1),
+			wantErr: "Deployment",
+		},
+		{
+			name:    "max concurrent reconciles",
+			args:    []string{"--max-concurrent-reconciles=0"},
+			wantErr: "maxConcurrentReconciles",
+		},
+		{
+			name:    "sync period",
+			args:    []string{"--sync-period=0s"},
+			wantErr: "syncPeriod",
+		},
+		{
+			name:    "namespace",
+			args:    []string{"--watch-namespaces=team-a,Team_B"},
+			wantErr: "watchNamespaces[1]",
+		},
+		{
+			name:    "duplicate namespace",
+			args:    []string{"--watch-namespaces=team-a,team-a"},
+			wantErr: "watchNamespaces[1]",
+		},
+		{
+			name:    "namespaces and cache namespace",
+			data:    testConfig + "cacheNamespace: team-a\n",
+			wantErr: "cacheNamespace",
+		},
+		{
+			name:    "default image against the policy",
+			data:    testConfig,
+			args:    []string{"--default-image=nginx"},
+			wantErr: "defaultImage",
+		},
+		{
+			name:    "sample ratio",
+			args:    []string{"--tracing-sample-ratio=2"},
+			wantErr: "tracing.sampleRatio",
+		},
+		{
+			name:    "leader election ID",
+			data:    strings.Replace(testConfig, "resourceName: 061dfbf1.mriyam.dev", `resourceName: ""`, 1),
+			wantErr: "leaderElection.resourceName",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := load(t, tt.data, tt.args...)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Load() error = %v, want it to mention %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestManagerOptions(t *testing.T) {
+	scheme := runtime.NewScheme()
+	tests := []struct {
+		namespaces    []string
+		wantNamespace string
+		wantNewCache  bool
+	}{
+		{namespaces: nil},
+		{namespaces: []string{"team-a"}, wantNamespace: "team-a"},
+		{namespaces: []string{"team-a", "team-b"}, wantNewCache: true},
+	}
+	for _, tt := range tests {
+		t.Run(strings.Join(tt.namespaces, ","), func(t *testing.T) {
+			c := Default(testLeaderElectionID)
+			c.WatchNamespaces = tt.namespaces
+			opts, err := c.ManagerOptions(scheme)
+			if err != nil {
+				t.Fatalf("ManagerOptions() error = %v", err)
+			}
+			if opts.Namespace != tt.wantNamespace || (opts.NewCache != nil) != tt.wantNewCache {
+				t.Errorf("ManagerOptions() namespace = %q, custom cache %v, want %q, %v", opts.Namespace, opts.NewCache != nil, tt.wantNamespace, tt.wantNewCache)
+			}
+			if opts.Scheme != scheme || opts.MetricsBindAddress != DefaultMetricsBindAddress ||
+				opts.HealthProbeBindAddress != DefaultHealthProbeBindAddress || opts.Port != DefaultWebhookPort ||
+				opts.LeaderElectionID != testLeaderElectionID || *opts.SyncPeriod != DefaultSyncPeriod {
+				t.Errorf("ManagerOptions() = %+v, want the settings of the configuration", opts)
+			}
+		})
+	}
+}
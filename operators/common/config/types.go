@@ -0,0 +1,83 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package config loads the configuration of an operator from the
+// ControllerManagerConfig file given by --config, with the command line
+// flags taking precedence over it.
+// +kubebuilder:object:generate=true
+package config
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	cfg "sigs.k8s.io/controller-runtime/pkg/config/v1alpha1"
+
+	"github.com/mbtamuli/k8s/common/imagepolicy"
+	"github.com/mbtamuli/k8s/common/tracing"
+)
+
+// GroupVersion is the version of the ControllerManagerConfig files, the one
+// of the controller-runtime configuration they extend.
+var GroupVersion = schema.GroupVersion{Group: "controller-runtime.sigs.k8s.io", Version: "v1alpha1"}
+
+// +kubebuilder:object:root=true
+
+// ControllerManagerConfig is the configuration of an operator: the
+// settings of the controller-runtime manager, along with the settings of
+// its controller and webhooks.
+type ControllerManagerConfig struct {
+	metav1.TypeMeta `json:",inline"`
+
+	// ControllerManagerConfigurationSpec holds the settings of the manager.
+	// Its SyncPeriod is how often every object is resynced.
+	cfg.ControllerManagerConfigurationSpec `json:",inline"`
+
+	// DefaultImage is the image of the containers that do not name one
+	//+optional
+	DefaultImage string `json:"defaultImage,omitempty"`
+
+	// MaxConcurrentReconciles is the number of objects reconciled at the
+	// same time
+	//+optional
+	MaxConcurrentReconciles int `json:"maxConcurrentReconciles,omitempty"`
+
+	// WatchNamespaces restricts the operator to the objects of these
+	// namespaces, all namespaces are watched when empty
+	//+optional
+	WatchNamespaces []string `json:"watchNamespaces,omitempty"`
+
+	// ImagePolicy is the image policy in force when there is no cluster
+	// ImagePolicy
+	//+optional
+	ImagePolicy *imagepolicy.Policy `json:"imagePolicy,omitempty"`
+
+	// PrometheusAddress is the address of the Prometheus server the
+	// autoscaler reads the metrics from, for the operators that autoscale
+	// +optional
+	PrometheusAddress string `json:"prometheusAddress,omitempty"`
+
+	// Tracing configures the export of the traces
+	//+optional
+	Tracing tracing.Options `json:"tracing,omitempty"`
+}
+
+// scheme knows the ControllerManagerConfig, to decode the files.
+var scheme = runtime.NewScheme()
+
+func init() {
+	scheme.AddKnownTypes(GroupVersion, &ControllerManagerConfig{})
+}
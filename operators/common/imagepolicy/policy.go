@@ -0,0 +1,140 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package imagepolicy restricts the images the operator runs: which
+// registries they come from, whether the latest tag is allowed, and whether
+// tags are pinned to digests at admission.
+package imagepolicy
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// Policy restricts the images workloads may run. The zero value allows any
+// image.
+// +kubebuilder:object:generate=true
+type Policy struct {
+	// AllowedRegistries lists the registries images may be pulled from,
+	// optionally followed by a repository prefix, e.g. "docker.io" or
+	// "registry.example.com/team". Images without a registry come from
+	// docker.io. Any registry is allowed when empty.
+	// +optional
+	AllowedRegistries []string `json:"allowedRegistries,omitempty"`
+
+	// ForbidLatest rejects images using the latest tag, explicitly or by
+	// omitting the tag, unless they are pinned to a digest.
+	// +optional
+	ForbidLatest bool `json:"forbidLatest,omitempty"`
+
+	// ResolveDigests pins image tags to the digest they point to at
+	// admission, so that every pod runs the same image. Objects running
+	// images that cannot be resolved are rejected.
+	// +optional
+	ResolveDigests bool `json:"resolveDigests,omitempty"`
+}
+
+// Source returns the policy currently in force, nil when there is none.
+type Source interface {
+	ImagePolicy(ctx context.Context) (*Policy, error)
+}
+
+// StaticSource is a Source always returning the same policy.
+type StaticSource struct {
+	Policy *Policy
+}
+
+// ImagePolicy implements Source.
+func (s StaticSource) ImagePolicy(context.Context) (*Policy, error) {
+	return s.Policy, nil
+}
+
+// Check returns an error describing why the image violates the policy, or nil.
+// A nil policy allows any well-formed image.
+func (p *Policy) Check(image string) error {
+	ref, err := ParseReference(image)
+	if err != nil {
+		return err
+	}
+	if p == nil {
+		return nil
+	}
+	if p.ForbidLatest && ref.IsLatest() {
+		return fmt.Errorf("image %q uses the latest tag, which is forbidden by the image policy", image)
+	}
+	if len(p.AllowedRegistries) > 0 && !p.allowsRegistry(ref) {
+		return fmt.Errorf("image %q does not come from an allowed registry (%s)", image, strings.Join(p.AllowedRegistries, ", "))
+	}
+	return nil
+}
+
+func (p *Policy) allowsRegistry(ref Reference) bool {
+	repository := ref.Domain + "/" + ref.Path
+	for _, allowed := range p.AllowedRegistries {
+		allowed = strings.TrimSuffix(allowed, "/")
+		if ref.Domain == allowed || strings.HasPrefix(repository, allowed+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// Pin returns the image pinned to the digest its tag currently points to,
+// when the policy asks for it. Images already pinned are returned as is.
+func (p *Policy) Pin(ctx context.Context, resolver Resolver, image string) (string, error) {
+	if p == nil || !p.ResolveDigests {
+		return image, nil
+	}
+	ref, err := ParseReference(image)
+	if err != nil {
+		return "", err
+	}
+	if ref.Digest != "" {
+		return image, nil
+	}
+	digest, err := resolver.Resolve(ctx, ref)
+	if err != nil {
+		return "", fmt.Errorf("resolving image %q: %w", image, err)
+	}
+	return image + "@" + digest, nil
+}
+
+// PinChanged pins the image like Pin, for an object whose stored version
+// runs the stored images. Images the stored version runs are returned as
+// stored, pinned or not, so that updating an object does not resolve its
+// unchanged tags to newer digests. Images violating the policy are returned
+// as is, for the validation to report them.
+func (p *Policy) PinChanged(ctx context.Context, resolver Resolver, image string, stored map[string]bool) (string, error) {
+	ref, err := ParseReference(image)
+	if err != nil {
+		return image, nil
+	}
+	if stored[image] {
+		return image, nil
+	}
+	if ref.Digest == "" {
+		for storedImage := range stored {
+			if storedRef, err := ParseReference(storedImage); err == nil && storedRef.Unpinned() == image {
+				return storedImage, nil
+			}
+		}
+	}
+	if p.Check(image) != nil {
+		return image, nil
+	}
+	return p.Pin(ctx, resolver, image)
+}
@@ -0,0 +1,125 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package imagepolicy
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestCheck(t *testing.T) {
+	policy := &Policy{
+		AllowedRegistries: []string{"docker.io/library", "registry.example.com:5000"},
+		ForbidLatest:      true,
+	}
+	tests := []struct {
+		policy  *Policy
+		image   string
+		wantErr bool
+	}{
+		{policy: nil, image: "nginx"},
+		{policy: nil, image: "Nginx", wantErr: true},
+		{policy: &Policy{}, image: "anything.example.com/app"},
+		{policy: policy, image: "nginx:1.21"},
+		{policy: policy, image: "docker.io/library/nginx:1.21"},
+		{policy: policy, image: "registry.example.com:5000/team/app:1.0"},
+		{policy: policy, image: "nginx@" + testDigest},
+		{policy: policy, image: "nginx", wantErr: true},
+		{policy: policy, image: "nginx:latest", wantErr: true},
+		{policy: policy, image: "team/app:1.0", wantErr: true},
+		{policy: policy, image: "registry.example.com/team/app:1.0", wantErr: true},
+		{policy: policy, image: "registry.example.com:5000.evil.com/app:1.0", wantErr: true},
+	}
+	for _, tt := range tests {
+		if err := tt.policy.Check(tt.image); (err != nil) != tt.wantErr {
+			t.Errorf("Check(%q) error = %v, wantErr %v", tt.image, err, tt.wantErr)
+		}
+	}
+}
+
+func TestPin(t *testing.T) {
+	ctx := context.Background()
+	resolver := ResolverFunc(func(_ context.Context, ref Reference) (string, error) {
+		if ref.Path != "library/nginx" {
+			return "", errors.New("not found")
+		}
+		return testDigest, nil
+	})
+
+	tests := []struct {
+		name    string
+		policy  *Policy
+		image   string
+		want    string
+		wantErr bool
+	}{
+		{name: "no policy", image: "nginx:1.21", want: "nginx:1.21"},
+		{name: "not resolving", policy: &Policy{}, image: "nginx:1.21", want: "nginx:1.21"},
+		{name: "tag", policy: &Policy{ResolveDigests: true}, image: "nginx:1.21", want: "nginx:1.21@" + testDigest},
+		{name: "pinned", policy: &Policy{ResolveDigests: true}, image: "app@" + testDigest, want: "app@" + testDigest},
+		{name: "unknown", policy: &Policy{ResolveDigests: true}, image: "app:1.0", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.policy.Pin(ctx, resolver, tt.image)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Pin() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("Pin() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPinChanged(t *testing.T) {
+	ctx := context.Background()
+	const newDigest = "sha256:1111111111111111111111111111111111111111111111111111111111111111"
+	resolver := ResolverFunc(func(_ context.Context, ref Reference) (string, error) {
+		if ref.Path != "library/nginx" {
+			return "", errors.New("not found")
+		}
+		return newDigest, nil
+	})
+	policy := &Policy{AllowedRegistries: []string{"docker.io/library", "ghcr.io"}, ResolveDigests: true}
+	stored := map[string]bool{"nginx:1.20@" + testDigest: true, "ghcr.io/team/app:1.0": true}
+
+	tests := []struct {
+		name    string
+		image   string
+		want    string
+		wantErr bool
+	}{
+		{name: "stored digest", image: "nginx:1.20", want: "nginx:1.20@" + testDigest},
+		{name: "stored tag", image: "ghcr.io/team/app:1.0", want: "ghcr.io/team/app:1.0"},
+		{name: "changed", image: "nginx:1.21", want: "nginx:1.21@" + newDigest},
+		{name: "forbidden", image: "quay.io/team/app:1.0", want: "quay.io/team/app:1.0"},
+		{name: "unknown", image: "ghcr.io/team/app:2.0", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := policy.PinChanged(ctx, resolver, tt.image, stored)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("PinChanged() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("PinChanged() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
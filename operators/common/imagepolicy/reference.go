@@ -0,0 +1,115 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package imagepolicy
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const (
+	// maxNameLength is the longest repository name a registry accepts.
+	maxNameLength = 255
+
+	// DefaultDomain is the registry of images that do not name one.
+	DefaultDomain = "docker.io"
+	// officialRepoPrefix is prepended to single component Docker Hub names.
+	officialRepoPrefix = "library/"
+)
+
+// referenceRegexp matches image references the way
+// github.com/docker/distribution/reference does, capturing the name, the tag
+// and the digest.
+var referenceRegexp = func() *regexp.Regexp {
+	const (
+		nameComponent   = `[a-z0-9]+(?:(?:[._]|__|[-]*)[a-z0-9]+)*`
+		domainComponent = `(?:[a-zA-Z0-9]|[a-zA-Z0-9][a-zA-Z0-9-]*[a-zA-Z0-9])`
+		domain          = domainComponent + `(?:\.` + domainComponent + `)*(?::[0-9]+)?`
+		name            = `(?:` + domain + `/)?` + nameComponent + `(?:/` + nameComponent + `)*`
+		tag             = `[\w][\w.-]{0,127}`
+		digest          = `[A-Za-z][A-Za-z0-9]*(?:[-_+.][A-Za-z][A-Za-z0-9]*)*:[[:xdigit:]]{32,}`
+	)
+	return regexp.MustCompile(`^(` + name + `)(?::(` + tag + `))?(?:@(` + digest + `))?$`)
+}()
+
+// Reference is a parsed image reference.
+type Reference struct {
+	// Name is the repository as written, e.g. "nginx" or
+	// "registry.example.com/team/app".
+	Name string
+	// Domain is the registry hosting the repository, DefaultDomain when
+	// the name does not start with one.
+	Domain string
+	// Path is the repository within the registry, e.g. "library/nginx".
+	Path   string
+	Tag    string
+	Digest string
+}
+
+// ParseReference splits the image reference into its name, tag and digest,
+// failing when it is malformed.
+func ParseReference(image string) (Reference, error) {
+	if image == "" {
+		return Reference{}, fmt.Errorf("image reference is empty")
+	}
+	match := referenceRegexp.FindStringSubmatch(image)
+	if match == nil {
+		return Reference{}, fmt.Errorf("invalid image reference %q", image)
+	}
+	if len(match[1]) > maxNameLength {
+		return Reference{}, fmt.Errorf("image name must not be longer than %d characters", maxNameLength)
+	}
+
+	ref := Reference{Name: match[1], Tag: match[2], Digest: match[3]}
+	ref.Domain, ref.Path = splitDomain(ref.Name)
+	return ref, nil
+}
+
+// IsLatest reports whether the reference uses the latest tag, explicitly or
+// by omitting the tag, without pinning a digest.
+func (r Reference) IsLatest() bool {
+	return r.Digest == "" && (r.Tag == "" || r.Tag == "latest")
+}
+
+// Unpinned returns the reference without its digest, as written, e.g.
+// "nginx:1.21" for "nginx:1.21@sha256:...".
+func (r Reference) Unpinned() string {
+	if r.Tag == "" {
+		return r.Name
+	}
+	return r.Name + ":" + r.Tag
+}
+
+// splitDomain splits the name into registry and repository path, following
+// the rules the container runtimes use: the first component is a registry
+// when it looks like a host name.
+func splitDomain(name string) (string, string) {
+	i := strings.IndexRune(name, '/')
+	if i == -1 || (!strings.ContainsAny(name[:i], ".:") && name[:i] != "localhost") {
+		domain, path := DefaultDomain, name
+		if !strings.ContainsRune(path, '/') {
+			path = officialRepoPrefix + path
+		}
+		return domain, path
+	}
+	domain, path := name[:i], name[i+1:]
+	if domain == "index.docker.io" {
+		domain = DefaultDomain
+	}
+	return domain, path
+}
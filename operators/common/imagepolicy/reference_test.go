@@ -0,0 +1,76 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package imagepolicy
+
+import "testing"
+
+const testDigest = "sha256:0d17b565c37bcbd895e9d92315a05c1c3c9a29f762b011a10c54a66cd53c9b31"
+
+func TestParseReference(t *testing.T) {
+	tests := []struct {
+		image   string
+		want    Reference
+		wantErr bool
+	}{
+		{image: "nginx", want: Reference{Name: "nginx", Domain: "docker.io", Path: "library/nginx"}},
+		{image: "nginx:1.21", want: Reference{Name: "nginx", Domain: "docker.io", Path: "library/nginx", Tag: "1.21"}},
+		{image: "team/app", want: Reference{Name: "team/app", Domain: "docker.io", Path: "team/app"}},
+		{image: "localhost/app", want: Reference{Name: "localhost/app", Domain: "localhost", Path: "app"}},
+		{image: "localhost:5000/nginx", want: Reference{Name: "localhost:5000/nginx", Domain: "localhost:5000", Path: "nginx"}},
+		{
+			image: "registry.example.com:5000/team/nginx:1.21",
+			want:  Reference{Name: "registry.example.com:5000/team/nginx", Domain: "registry.example.com:5000", Path: "team/nginx", Tag: "1.21"},
+		},
+		{
+			image: "nginx:1.21@" + testDigest,
+			want:  Reference{Name: "nginx", Domain: "docker.io", Path: "library/nginx", Tag: "1.21", Digest: testDigest},
+		},
+		{image: "", wantErr: true},
+		{image: "Nginx", wantErr: true},
+		{image: "nginx:", wantErr: true},
+		{image: "nginx@sha256:abc", wantErr: true},
+		{image: "nginx 1.21", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseReference(tt.image)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseReference(%q) error = %v, wantErr %v", tt.image, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseReference(%q) = %+v, want %+v", tt.image, got, tt.want)
+		}
+	}
+}
+
+func TestUnpinned(t *testing.T) {
+	tests := map[string]string{
+		"nginx":               "nginx",
+		"nginx:1.21":          "nginx:1.21",
+		"nginx@" + testDigest: "nginx",
+		"localhost:5000/nginx:1.21@" + testDigest: "localhost:5000/nginx:1.21",
+	}
+	for image, want := range tests {
+		ref, err := ParseReference(image)
+		if err != nil {
+			t.Fatalf("ParseReference(%q) error = %v", image, err)
+		}
+		if got := ref.Unpinned(); got != want {
+			t.Errorf("Unpinned() = %q for %q, want %q", got, image, want)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package imagepolicy
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// fakeRegistry serves the manifest digests of the Docker Registry HTTP API
+// V2, requiring an anonymous bearer token like Docker Hub.
+type fakeRegistry struct {
+	*httptest.Server
+	// manifests maps "path:tag" to digests
+	manifests map[string]string
+}
+
+func newFakeRegistry(manifests map[string]string) *fakeRegistry {
+	r := &fakeRegistry{manifests: manifests}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/token", func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Query().Get("service") != "fake-registry" {
+			http.Error(w, "unknown service", http.StatusBadRequest)
+			return
+		}
+		fmt.Fprint(w, `{"token":"anonymous"}`)
+	})
+	mux.HandleFunc("/v2/", func(w http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("Authorization") != "Bearer anonymous" {
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="fake-registry",scope="repository:app:pull"`, r.URL))
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if req.Method != http.MethodHead || !strings.Contains(req.Header.Get("Accept"), "manifest.list.v2+json") {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		parts := strings.SplitN(strings.TrimPrefix(req.URL.Path, "/v2/"), "/manifests/", 2)
+		digest, ok := r.manifests[strings.Join(parts, ":")]
+		if len(parts) != 2 || !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Docker-Content-Digest", digest)
+	})
+	r.Server = httptest.NewTLSServer(mux)
+	return r
+}
+
+func (r *fakeRegistry) host() string {
+	return strings.TrimPrefix(r.URL, "https://")
+}
+
+func TestRegistryResolver(t *testing.T) {
+	registry := newFakeRegistry(map[string]string{
+		"team/app:1.0":    testDigest,
+		"team/app:latest": testDigest,
+	})
+	defer registry.Close()
+	resolver := &RegistryResolver{Client: registry.Client()}
+
+	tests := []struct {
+		image   string
+		wantErr bool
+	}{
+		{image: registry.host() + "/team/app:1.0"},
+		{image: registry.host() + "/team/app"},
+		{image: registry.host() + "/team/app:2.0", wantErr: true},
+	}
+	for _, tt := range tests {
+		ref, err := ParseReference(tt.image)
+		if err != nil {
+			t.Fatalf("ParseReference(%q) error = %v", tt.image, err)
+		}
+		digest, err := resolver.Resolve(context.Background(), ref)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("Resolve(%q) error = %v, wantErr %v", tt.image, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && digest != testDigest {
+			t.Errorf("Resolve(%q) = %q, want %q", tt.image, digest, testDigest)
+		}
+	}
+
+	policy := &Policy{AllowedRegistries: []string{registry.host()}, ForbidLatest: true, ResolveDigests: true}
+	pinned, err := policy.Pin(context.Background(), resolver, registry.host()+"/team/app:1.0")
+	if err != nil {
+		t.Fatalf("Pin() error = %v", err)
+	}
+	if err := policy.Check(pinned); err != nil {
+		t.Errorf("Check(%q) error = %v", pinned, err)
+	}
+}
@@ -0,0 +1,115 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package logging sets up the logger of the operator and the conventions of
+// its logs.
+//
+// The reconcilers log through the logger of their context, which carries
+// the namespace and name of the object reconciled and a reconcileID shared
+// by every line of a reconcile. The lines about a pod add its name under
+// the pod key, and the lines about a change to the cluster say what it is
+// under the action key, e.g. create or delete.
+//
+// Changes to the cluster and errors are logged at the default level. The
+// decisions that change nothing, such as waiting for pods, are logged at
+// the Debug level and the details of every object considered at the Trace
+// level.
+package logging
+
+import (
+	"context"
+	"flag"
+	"fmt"
+
+	"github.com/go-logr/logr"
+	"go.opentelemetry.io/otel/trace"
+	"k8s.io/apimachinery/pkg/util/uuid"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// Verbosity levels, for logr.Logger.V.
+const (
+	Debug = 1
+	Trace = 2
+)
+
+// ReconcileIDKey is the key of the correlation ID of a reconcile.
+const ReconcileIDKey = "reconcileID"
+
+// Log formats.
+const (
+	// FormatConsole logs human readable lines down to the Debug level,
+	// for development
+	FormatConsole = "console"
+	// FormatJSON logs JSON objects at the default level, for production
+	FormatJSON = "json"
+)
+
+// Options configures the logger of the operator.
+type Options struct {
+	// Format is FormatConsole or FormatJSON
+	Format string
+	// Zap holds the finer settings of the logger, the development mode is
+	// set from the Format
+	Zap zap.Options
+}
+
+// BindFlags binds the options to the flags of fs, along with the zap
+// flags.
+func (o *Options) BindFlags(fs *flag.FlagSet) {
+	fs.StringVar(&o.Format, "log-format", FormatConsole,
+		"The format of the logs, console for development or json for production. "+
+			"The json format logs at the info level unless --zap-log-level says otherwise.")
+	o.Zap.BindFlags(fs)
+}
+
+// Validate returns an error if the options are invalid.
+func (o *Options) Validate() error {
+	if o.Format != FormatConsole && o.Format != FormatJSON {
+		return fmt.Errorf("unknown log format %q, must be %s or %s", o.Format, FormatConsole, FormatJSON)
+	}
+	return nil
+}
+
+// Logger returns the logger configured by the options.
+func (o *Options) Logger() logr.Logger {
+	return zap.New(zap.UseFlagOptions(&o.Zap), func(z *zap.Options) {
+		z.Development = o.Format != FormatJSON
+	})
+}
+
+// Reconciler adds a reconcileID to the logger of the context of every call
+// to the wrapped reconciler. The ID is the ID of the trace of the reconcile
+// when it is traced, so the logs lead to the trace.
+type Reconciler struct {
+	reconcile.Reconciler
+}
+
+// Reconcile implements reconcile.Reconciler.
+func (r Reconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	logger := log.FromContext(ctx).WithValues(ReconcileIDKey, reconcileID(ctx))
+	return r.Reconciler.Reconcile(log.IntoContext(ctx, logger), req)
+}
+
+func reconcileID(ctx context.Context) string {
+	if sc := trace.SpanContextFromContext(ctx); sc.HasTraceID() {
+		return sc.TraceID().String()
+	}
+	return string(uuid.NewUUID())
+}
@@ -0,0 +1,142 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"strings"
+	"testing"
+
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// decodeLines decodes the JSON log lines written to buf.
+func decodeLines(t *testing.T, buf *bytes.Buffer) []map[string]interface{} {
+	t.Helper()
+	var lines []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		entry := map[string]interface{}{}
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("log line %q is not JSON: %v", line, err)
+		}
+		lines = append(lines, entry)
+	}
+	return lines
+}
+
+func TestOptions(t *testing.T) {
+	tests := []struct {
+		args      []string
+		wantDebug bool
+		wantJSON  bool
+	}{
+		{args: nil, wantDebug: true},
+		{args: []string{"--log-format=json"}, wantJSON: true},
+		{args: []string{"--log-format=json", "--zap-log-level=1"}, wantDebug: true, wantJSON: true},
+	}
+	for _, tt := range tests {
+		t.Run(strings.Join(tt.args, " "), func(t *testing.T) {
+			fs := flag.NewFlagSet("test", flag.ContinueOnError)
+			var opts Options
+			opts.BindFlags(fs)
+			if err := fs.Parse(tt.args); err != nil {
+				t.Fatal(err)
+			}
+			if err := opts.Validate(); err != nil {
+				t.Fatalf("Validate() error = %v", err)
+			}
+			var buf bytes.Buffer
+			opts.Zap.DestWriter = &buf
+
+			logger := opts.Logger()
+			logger.Info("info")
+			logger.V(Debug).Info("debug")
+
+			out := buf.String()
+			if got := strings.Contains(out, `"debug"`) || strings.Contains(out, "\tdebug"); got != tt.wantDebug {
+				t.Errorf("debug line logged = %v, want %v:\n%s", got, tt.wantDebug, out)
+			}
+			if got := strings.HasPrefix(out, "{"); got != tt.wantJSON {
+				t.Errorf("JSON output = %v, want %v:\n%s", got, tt.wantJSON, out)
+			}
+		})
+	}
+
+	opts := Options{Format: "text"}
+	if err := opts.Validate(); err == nil {
+		t.Error("expected an unknown format to be rejected")
+	}
+}
+
+func TestReconcilerAddsReconcileID(t *testing.T) {
+	var buf bytes.Buffer
+	base := zap.New(zap.WriteTo(&buf))
+	r := Reconciler{Reconciler: reconcile.Func(func(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+		log.FromContext(ctx).Info("reconciling")
+		log.FromContext(ctx).Info("reconciled")
+		return ctrl.Result{}, nil
+	})}
+	req := ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "default", Name: "web"}}
+
+	ctx := log.IntoContext(context.Background(), base)
+	for i := 0; i < 2; i++ {
+		if _, err := r.Reconcile(ctx, req); err != nil {
+			t.Fatal(err)
+		}
+	}
+	lines := decodeLines(t, &buf)
+	if len(lines) != 4 {
+		t.Fatalf("got %d log lines, want 4", len(lines))
+	}
+	ids := make([]interface{}, len(lines))
+	for i, line := range lines {
+		ids[i] = line[ReconcileIDKey]
+		if ids[i] == nil || ids[i] == "" {
+			t.Fatalf("log line %v has no reconcile ID", line)
+		}
+	}
+	if ids[0] != ids[1] || ids[2] != ids[3] {
+		t.Errorf("expected the lines of a reconcile to share their ID, got %v", ids)
+	}
+	if ids[0] == ids[2] {
+		t.Errorf("expected every reconcile to get its own ID, got %v", ids)
+	}
+
+	// The reconcile ID of a traced reconcile is its trace ID
+	buf.Reset()
+	tracer := sdktrace.NewTracerProvider().Tracer("test")
+	ctx, span := tracer.Start(ctx, "reconcile")
+	defer span.End()
+	if _, err := r.Reconcile(ctx, req); err != nil {
+		t.Fatal(err)
+	}
+	lines = decodeLines(t, &buf)
+	if want := span.SpanContext().TraceID().String(); lines[0][ReconcileIDKey] != want {
+		t.Errorf("reconcile ID = %v, want the trace ID %s", lines[0][ReconcileIDKey], want)
+	}
+}
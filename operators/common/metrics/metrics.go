@@ -0,0 +1,150 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package metrics defines the metrics of the objects an operator manages
+// pods for. The operators register them with the controller-runtime
+// registry, so the manager serves them on its metrics endpoint next to its
+// own.
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Reasons pods are created or deleted for, used as the reason label.
+const (
+	ReasonScaleUp   = "scale_up"
+	ReasonScaleDown = "scale_down"
+	ReasonRollout   = "rollout"
+	ReasonPodFailed = "pod_failed"
+	ReasonCleanup   = "cleanup"
+)
+
+// Results of an admission request, used as the result label.
+const (
+	ResultAllowed = "allowed"
+	ResultDenied  = "denied"
+)
+
+var reasons = []string{ReasonScaleUp, ReasonScaleDown, ReasonRollout, ReasonPodFailed, ReasonCleanup}
+
+// Workload holds the metrics of the objects of a kind, labelled with the
+// namespace and name of the object.
+type Workload struct {
+	desiredReplicas *prometheus.GaugeVec
+	readyReplicas   *prometheus.GaugeVec
+	podsCreated     *prometheus.CounterVec
+	podsDeleted     *prometheus.CounterVec
+}
+
+// NewWorkload returns the metrics of the objects of kind, with names
+// starting with prefix, e.g. podset_replicas_desired. They still have to be
+// registered.
+func NewWorkload(prefix, kind string) *Workload {
+	return &Workload{
+		desiredReplicas: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: prefix + "_replicas_desired",
+			Help: "Number of replicas desired by the " + kind + ".",
+		}, []string{"namespace", "name"}),
+
+		readyReplicas: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: prefix + "_replicas_ready",
+			Help: "Number of ready replicas of the " + kind + ".",
+		}, []string{"namespace", "name"}),
+
+		podsCreated: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: prefix + "_pods_created_total",
+			Help: "Number of pods created for the " + kind + ", by reason.",
+		}, []string{"namespace", "name", "reason"}),
+
+		podsDeleted: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: prefix + "_pods_deleted_total",
+			Help: "Number of pods deleted for the " + kind + ", by reason.",
+		}, []string{"namespace", "name", "reason"}),
+	}
+}
+
+// Collectors returns the collectors of the metrics, to register them.
+func (w *Workload) Collectors() []prometheus.Collector {
+	return []prometheus.Collector{
+		w.desiredReplicas,
+		w.readyReplicas,
+		w.podsCreated,
+		w.podsDeleted,
+	}
+}
+
+// SetReplicas reports the desired and ready replicas of an object.
+func (w *Workload) SetReplicas(namespace, name string, desired, ready int32) {
+	w.desiredReplicas.WithLabelValues(namespace, name).Set(float64(desired))
+	w.readyReplicas.WithLabelValues(namespace, name).Set(float64(ready))
+}
+
+// PodsCreated counts n pods created for an object.
+func (w *Workload) PodsCreated(namespace, name, reason string, n int) {
+	if n > 0 {
+		w.podsCreated.WithLabelValues(namespace, name, reason).Add(float64(n))
+	}
+}
+
+// PodsDeleted counts n pods deleted for an object.
+func (w *Workload) PodsDeleted(namespace, name, reason string, n int) {
+	if n > 0 {
+		w.podsDeleted.WithLabelValues(namespace, name, reason).Add(float64(n))
+	}
+}
+
+// Forget drops the series of a deleted object.
+func (w *Workload) Forget(namespace, name string) {
+	w.desiredReplicas.DeleteLabelValues(namespace, name)
+	w.readyReplicas.DeleteLabelValues(namespace, name)
+	for _, reason := range reasons {
+		w.podsCreated.DeleteLabelValues(namespace, name, reason)
+		w.podsDeleted.DeleteLabelValues(namespace, name, reason)
+	}
+}
+
+// Admissions counts the admission requests for the objects of a kind.
+type Admissions struct {
+	reviewed *prometheus.CounterVec
+}
+
+// NewAdmissions returns the admission metrics of the objects of kind, with
+// names starting with prefix, e.g. podset_webhook_admissions_total. They
+// still have to be registered.
+func NewAdmissions(prefix, kind string) *Admissions {
+	return &Admissions{
+		reviewed: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: prefix + "_webhook_admissions_total",
+			Help: "Number of " + kind + " admission requests handled by the validating webhook, by operation and result.",
+		}, []string{"operation", "result"}),
+	}
+}
+
+// Collectors returns the collectors of the metrics, to register them.
+func (a *Admissions) Collectors() []prometheus.Collector {
+	return []prometheus.Collector{a.reviewed}
+}
+
+// Reviewed counts an admission request handled by the validating webhook,
+// denied when err is not nil.
+func (a *Admissions) Reviewed(operation string, err error) {
+	result := ResultAllowed
+	if err != nil {
+		result = ResultDenied
+	}
+	a.reviewed.WithLabelValues(operation, result).Inc()
+}
@@ -0,0 +1,80 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metrics
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestWorkload(t *testing.T) {
+	w := NewWorkload("podset", "PodSet")
+	registry := prometheus.NewPedanticRegistry()
+	registry.MustRegister(w.Collectors()...)
+
+	w.SetReplicas("default", "web", 3, 1)
+	w.SetReplicas("default", "web", 3, 2)
+	w.PodsCreated("default", "web", ReasonScaleUp, 3)
+	w.PodsCreated("default", "web", ReasonRollout, 0)
+	w.PodsDeleted("default", "web", ReasonScaleDown, 1)
+
+	want := `
+# HELP podset_replicas_desired Number of replicas desired by the PodSet.
+# TYPE podset_replicas_desired gauge
+podset_replicas_desired{name="web",namespace="default"} 3
+# HELP podset_replicas_ready Number of ready replicas of the PodSet.
+# TYPE podset_replicas_ready gauge
+podset_replicas_ready{name="web",namespace="default"} 2
+# HELP podset_pods_created_total Number of pods created for the PodSet, by reason.
+# TYPE podset_pods_created_total counter
+podset_pods_created_total{name="web",namespace="default",reason="scale_up"} 3
+# HELP podset_pods_deleted_total Number of pods deleted for the PodSet, by reason.
+# TYPE podset_pods_deleted_total counter
+podset_pods_deleted_total{name="web",namespace="default",reason="scale_down"} 1
+`
+	if err := testutil.GatherAndCompare(registry, strings.NewReader(want)); err != nil {
+		t.Error(err)
+	}
+
+	// The series of a deleted object go away with it
+	w.Forget("default", "web")
+	if got, err := testutil.GatherAndCount(registry); err != nil || got != 0 {
+		t.Errorf("got %d series after Forget, want none (error %v)", got, err)
+	}
+}
+
+func TestAdmissions(t *testing.T) {
+	a := NewAdmissions("customdeployment", "CustomDeployment")
+	a.Reviewed("create", nil)
+	a.Reviewed("update", nil)
+	a.Reviewed("update", errors.New("spec.replicas: Invalid value"))
+
+	want := `
+# HELP customdeployment_webhook_admissions_total Number of CustomDeployment admission requests handled by the validating webhook, by operation and result.
+# TYPE customdeployment_webhook_admissions_total counter
+customdeployment_webhook_admissions_total{operation="create",result="allowed"} 1
+customdeployment_webhook_admissions_total{operation="update",result="allowed"} 1
+customdeployment_webhook_admissions_total{operation="update",result="denied"} 1
+`
+	if err := testutil.CollectAndCompare(a.reviewed, strings.NewReader(want)); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,148 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tracing
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+)
+
+// Client records a span for every call made through the wrapped client, as
+// a child of the span in the context of the call.
+type Client struct {
+	client.Client
+}
+
+// NewClient returns c with its calls traced.
+func NewClient(c client.Client) *Client {
+	return &Client{Client: c}
+}
+
+// Get implements client.Client.
+func (c *Client) Get(ctx context.Context, key client.ObjectKey, obj client.Object) (err error) {
+	ctx, span := c.start(ctx, "Get", obj, NamespaceKey.String(key.Namespace), NameKey.String(key.Name))
+	defer func() { end(span, err) }()
+	return c.Client.Get(ctx, key, obj)
+}
+
+// List implements client.Client.
+func (c *Client) List(ctx context.Context, list client.ObjectList, opts ...client.ListOption) (err error) {
+	listOpts := (&client.ListOptions{}).ApplyOptions(opts)
+	attrs := []attribute.KeyValue{NamespaceKey.String(listOpts.Namespace)}
+	if listOpts.LabelSelector != nil {
+		attrs = append(attrs, attribute.String("k8s.label_selector", listOpts.LabelSelector.String()))
+	}
+	ctx, span := c.start(ctx, "List", list, attrs...)
+	defer func() {
+		if err == nil {
+			span.SetAttributes(attribute.Int("k8s.list.items", itemCount(list)))
+		}
+		end(span, err)
+	}()
+	return c.Client.List(ctx, list, opts...)
+}
+
+// Create implements client.Client.
+func (c *Client) Create(ctx context.Context, obj client.Object, opts ...client.CreateOption) (err error) {
+	ctx, span := c.start(ctx, "Create", obj, objectAttributes(obj)...)
+	defer func() {
+		// The name is only known once created when generated
+		span.SetAttributes(NameKey.String(obj.GetName()))
+		end(span, err)
+	}()
+	return c.Client.Create(ctx, obj, opts...)
+}
+
+// Update implements client.Client.
+func (c *Client) Update(ctx context.Context, obj client.Object, opts ...client.UpdateOption) (err error) {
+	ctx, span := c.start(ctx, "Update", obj, objectAttributes(obj)...)
+	defer func() { end(span, err) }()
+	return c.Client.Update(ctx, obj, opts...)
+}
+
+// Patch implements client.Client.
+func (c *Client) Patch(ctx context.Context, obj client.Object, patch client.Patch, opts ...client.PatchOption) (err error) {
+	ctx, span := c.start(ctx, "Patch", obj, objectAttributes(obj)...)
+	defer func() { end(span, err) }()
+	return c.Client.Patch(ctx, obj, patch, opts...)
+}
+
+// Delete implements client.Client.
+func (c *Client) Delete(ctx context.Context, obj client.Object, opts ...client.DeleteOption) (err error) {
+	ctx, span := c.start(ctx, "Delete", obj, objectAttributes(obj)...)
+	defer func() { end(span, err) }()
+	return c.Client.Delete(ctx, obj, opts...)
+}
+
+// Status implements client.Client, the status updates are traced too.
+func (c *Client) Status() client.StatusWriter {
+	return &statusWriter{StatusWriter: c.Client.Status(), client: c}
+}
+
+type statusWriter struct {
+	client.StatusWriter
+	client *Client
+}
+
+// Update implements client.StatusWriter.
+func (w *statusWriter) Update(ctx context.Context, obj client.Object, opts ...client.UpdateOption) (err error) {
+	ctx, span := w.client.start(ctx, "Status.Update", obj, objectAttributes(obj)...)
+	defer func() { end(span, err) }()
+	return w.StatusWriter.Update(ctx, obj, opts...)
+}
+
+// Patch implements client.StatusWriter.
+func (w *statusWriter) Patch(ctx context.Context, obj client.Object, patch client.Patch, opts ...client.PatchOption) (err error) {
+	ctx, span := w.client.start(ctx, "Status.Patch", obj, objectAttributes(obj)...)
+	defer func() { end(span, err) }()
+	return w.StatusWriter.Patch(ctx, obj, patch, opts...)
+}
+
+// start starts the span of a call on obj, named after the verb and the kind
+// of obj, e.g. "Get Pod".
+func (c *Client) start(ctx context.Context, verb string, obj runtime.Object, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	name := verb
+	if gvk, err := apiutil.GVKForObject(obj, c.Scheme()); err == nil {
+		name += " " + gvk.Kind
+		attrs = append(attrs, KindKey.String(gvk.Kind))
+	}
+	return Tracer().Start(ctx, name, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(attrs...))
+}
+
+func end(span trace.Span, err error) {
+	RecordError(span, err)
+	span.End()
+}
+
+func objectAttributes(obj client.Object) []attribute.KeyValue {
+	return []attribute.KeyValue{NamespaceKey.String(obj.GetNamespace()), NameKey.String(obj.GetName())}
+}
+
+// itemCount returns the number of items of a list, or 0 if it is not one.
+func itemCount(list client.ObjectList) int {
+	items, err := apimeta.ExtractList(list)
+	if err != nil {
+		return 0
+	}
+	return len(items)
+}
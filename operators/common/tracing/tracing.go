@@ -0,0 +1,140 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package tracing exports OpenTelemetry traces of the operator over OTLP.
+// Each reconcile is a root span, with a child span for every API call it
+// makes through the Client. Tracing is off unless an endpoint is set, the
+// spans then go to a no-op tracer.
+package tracing
+
+import (
+	"context"
+	"flag"
+	"fmt"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.7.0"
+	"go.opentelemetry.io/otel/trace"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// instrumentationName names the tracer of the operators.
+const instrumentationName = "github.com/mbtamuli/k8s/common/tracing"
+
+// Attributes set on the spans.
+const (
+	NamespaceKey = attribute.Key("k8s.namespace.name")
+	NameKey      = attribute.Key("k8s.object.name")
+	KindKey      = attribute.Key("k8s.object.kind")
+)
+
+// Options configures the export of the traces.
+type Options struct {
+	// Endpoint is the host:port of the OTLP gRPC collector, tracing is
+	// disabled when empty
+	Endpoint string `json:"endpoint,omitempty"`
+	// Insecure disables TLS to the collector
+	Insecure bool `json:"insecure,omitempty"`
+	// SampleRatio is the fraction of the traces exported, from 0 to 1
+	SampleRatio float64 `json:"sampleRatio,omitempty"`
+}
+
+// BindFlags binds the options to the flags of fs.
+func (o *Options) BindFlags(fs *flag.FlagSet) {
+	fs.StringVar(&o.Endpoint, "tracing-endpoint", "",
+		"The host:port of the OTLP gRPC collector traces are exported to. Tracing is disabled when empty.")
+	fs.BoolVar(&o.Insecure, "tracing-insecure", false, "Connect to the OTLP collector without TLS.")
+	fs.Float64Var(&o.SampleRatio, "tracing-sample-ratio", 1, "The fraction of the traces exported, from 0 to 1.")
+}
+
+// Validate returns an error if the options are invalid.
+func (o *Options) Validate() error {
+	if o.SampleRatio < 0 || o.SampleRatio > 1 {
+		return fmt.Errorf("tracing sample ratio %v is not between 0 and 1", o.SampleRatio)
+	}
+	return nil
+}
+
+// Setup installs the global tracer provider exporting the traces of
+// serviceName as configured. The returned func flushes the pending spans
+// and stops the export. Nothing is installed when tracing is disabled.
+func Setup(ctx context.Context, serviceName string, opts Options) (func(context.Context) error, error) {
+	if opts.Endpoint == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+
+	clientOpts := []otlptracegrpc.Option{otlptracegrpc.WithEndpoint(opts.Endpoint)}
+	if opts.Insecure {
+		clientOpts = append(clientOpts, otlptracegrpc.WithInsecure())
+	}
+	exporter, err := otlptracegrpc.New(ctx, clientOpts...)
+	if err != nil {
+		return nil, fmt.Errorf("creating the OTLP exporter: %w", err)
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(opts.SampleRatio))),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceNameKey.String(serviceName))),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	return provider.Shutdown, nil
+}
+
+// Tracer returns the tracer of the operator, from the global provider.
+func Tracer() trace.Tracer {
+	return otel.Tracer(instrumentationName)
+}
+
+// RecordError marks the span as failed with err, if not nil.
+func RecordError(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+}
+
+// Reconciler starts a new root span for every call to the wrapped
+// reconciler, named after Kind and carrying the namespaced name requested.
+type Reconciler struct {
+	reconcile.Reconciler
+	// Kind is the kind of the objects reconciled
+	Kind string
+}
+
+// Reconcile implements reconcile.Reconciler.
+func (r Reconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	ctx, span := Tracer().Start(ctx, r.Kind+".Reconcile", trace.WithNewRoot(), trace.WithAttributes(
+		KindKey.String(r.Kind),
+		NamespaceKey.String(req.Namespace),
+		NameKey.String(req.Name),
+	))
+	defer span.End()
+
+	result, err := r.Reconciler.Reconcile(ctx, req)
+	RecordError(span, err)
+	return result, err
+}
@@ -0,0 +1,171 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tracing
+
+import (
+	"context"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// newTestExporter installs a tracer provider exporting the spans to memory
+// as soon as they end, for the duration of the test.
+func newTestExporter(t *testing.T) *tracetest.InMemoryExporter {
+	t.Helper()
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(provider)
+	t.Cleanup(func() {
+		otel.SetTracerProvider(previous)
+		_ = provider.Shutdown(context.Background())
+	})
+	return exporter
+}
+
+func findSpan(t *testing.T, spans tracetest.SpanStubs, name string) tracetest.SpanStub {
+	t.Helper()
+	for _, span := range spans {
+		if span.Name == name {
+			return span
+		}
+	}
+	var names []string
+	for _, span := range spans {
+		names = append(names, span.Name)
+	}
+	t.Fatalf("no span %q, got %v", name, names)
+	return tracetest.SpanStub{}
+}
+
+func hasAttribute(span tracetest.SpanStub, kv attribute.KeyValue) bool {
+	for _, attr := range span.Attributes {
+		if attr == kv {
+			return true
+		}
+	}
+	return false
+}
+
+func TestReconcilerAndClient(t *testing.T) {
+	exporter := newTestExporter(t)
+	c := NewClient(fake.NewClientBuilder().WithScheme(clientgoscheme.Scheme).WithObjects(&corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "web-1", Namespace: "default"},
+	}).Build())
+
+	r := Reconciler{Kind: "CustomDeployment", Reconciler: reconcile.Func(func(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+		pod := &corev1.Pod{}
+		if err := c.Get(ctx, types.NamespacedName{Namespace: req.Namespace, Name: "web-1"}, pod); err != nil {
+			return ctrl.Result{}, err
+		}
+		pods := &corev1.PodList{}
+		if err := c.List(ctx, pods, client.InNamespace(req.Namespace)); err != nil {
+			return ctrl.Result{}, err
+		}
+		if err := c.Create(ctx, &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "web-2", Namespace: req.Namespace}}); err != nil {
+			return ctrl.Result{}, err
+		}
+		return ctrl.Result{}, c.Delete(ctx, pod)
+	})}
+
+	// The context of the caller does not parent the reconcile
+	ctx, parent := Tracer().Start(context.Background(), "parent")
+	parent.End()
+	req := ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "default", Name: "web"}}
+	if _, err := r.Reconcile(ctx, req); err != nil {
+		t.Fatalf("Reconcile() error = %v", err)
+	}
+
+	spans := exporter.GetSpans()
+	root := findSpan(t, spans, "CustomDeployment.Reconcile")
+	if root.Parent.IsValid() {
+		t.Errorf("expected the reconcile span to be a root span, got parent %v", root.Parent.SpanID())
+	}
+	for _, kv := range []attribute.KeyValue{NamespaceKey.String("default"), NameKey.String("web")} {
+		if !hasAttribute(root, kv) {
+			t.Errorf("expected the reconcile span to have %v, got %v", kv, root.Attributes)
+		}
+	}
+
+	for _, name := range []string{"Get Pod", "List PodList", "Create Pod", "Delete Pod"} {
+		span := findSpan(t, spans, name)
+		if span.Parent.SpanID() != root.SpanContext.SpanID() {
+			t.Errorf("expected %q to be a child of the reconcile span", name)
+		}
+		if !hasAttribute(span, NamespaceKey.String("default")) {
+			t.Errorf("expected %q to have the namespace, got %v", name, span.Attributes)
+		}
+	}
+	if create := findSpan(t, spans, "Create Pod"); !hasAttribute(create, NameKey.String("web-2")) {
+		t.Errorf("expected the create span to have the name, got %v", create.Attributes)
+	}
+	if list := findSpan(t, spans, "List PodList"); !hasAttribute(list, attribute.Int("k8s.list.items", 1)) {
+		t.Errorf("expected the list span to have the number of items, got %v", list.Attributes)
+	}
+}
+
+func TestReconcilerRecordsErrors(t *testing.T) {
+	exporter := newTestExporter(t)
+	c := NewClient(fake.NewClientBuilder().WithScheme(clientgoscheme.Scheme).Build())
+	r := Reconciler{Kind: "CustomDeployment", Reconciler: reconcile.Func(func(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+		return ctrl.Result{}, c.Get(ctx, req.NamespacedName, &corev1.Pod{})
+	})}
+
+	req := ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "default", Name: "missing"}}
+	if _, err := r.Reconcile(context.Background(), req); err == nil {
+		t.Fatal("expected Reconcile() to fail")
+	}
+
+	spans := exporter.GetSpans()
+	for _, name := range []string{"CustomDeployment.Reconcile", "Get Pod"} {
+		span := findSpan(t, spans, name)
+		if span.Status.Code != codes.Error {
+			t.Errorf("expected %q to have an error status, got %+v", name, span.Status)
+		}
+		if len(span.Events) == 0 {
+			t.Errorf("expected %q to record the error", name)
+		}
+	}
+}
+
+func TestSetup(t *testing.T) {
+	shutdown, err := Setup(context.Background(), "test", Options{})
+	if err != nil {
+		t.Fatalf("Setup() error = %v", err)
+	}
+	if err := shutdown(context.Background()); err != nil {
+		t.Errorf("shutdown() error = %v", err)
+	}
+
+	_, err = Setup(context.Background(), "test", Options{Endpoint: "localhost:4317", SampleRatio: 2})
+	if err == nil {
+		t.Errorf("expected an invalid sample ratio to be rejected")
+	}
+}
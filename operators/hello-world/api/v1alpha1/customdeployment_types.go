@@ -17,25 +17,218 @@ limitations under the License.
 package v1alpha1
 
 import (
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+
+	"github.com/mbtamuli/hello-world/pkg/autoscaling"
 )
 
+// DefaultContainerName is the name of the container running Spec.Image when
+// there is no template.
+const DefaultContainerName = "app"
+
 // CustomDeploymentSpec defines the desired state of CustomDeployment
 type CustomDeploymentSpec struct {
-	// Replicas is the size of the CustomDeployment
+	// Replicas is the size of the CustomDeployment. Defaults to 1 when
+	// omitted, negative values are rejected by the validating webhook.
+	// +optional
+	// +kubebuilder:default=1
 	Replicas int `json:"replicas"`
-	// Image is the size of the CustomDeployment
-	Image string `json:"image"`
+	// Image is a shorthand for a template with a single container running
+	// this image. It is ignored when the template is set.
+	// +optional
+	Image string `json:"image,omitempty"`
+	// Selector is a label query over the pods managed by this CustomDeployment.
+	// It must match the template labels. Defaults to the labels the controller
+	// adds to every pod it creates.
+	// +optional
+	Selector *metav1.LabelSelector `json:"selector,omitempty"`
+	// Template describes the pods that will be created. It is required
+	// unless Image is set.
+	// +optional
+	Template *corev1.PodTemplateSpec `json:"template,omitempty"`
+	// Strategy is used to replace existing pods with new ones when the
+	// template changes
+	// +optional
+	Strategy CustomDeploymentStrategy `json:"strategy,omitempty"`
+	// PodManagementPolicy controls how pods are created, replaced and
+	// deleted. Parallel, the default, acts on many pods at once following
+	// the Strategy. OrderedReady gives pods stable names ending in their
+	// ordinal, and acts on one pod at a time in ordinal order, waiting for
+	// each to be Ready before moving on. The Strategy is ignored then.
+	// +optional
+	PodManagementPolicy PodManagementPolicyType `json:"podManagementPolicy,omitempty"`
+	// DeletionPolicy controls what happens to the pods when the
+	// CustomDeployment is deleted. Delete, the default, drains them before
+	// the CustomDeployment goes away. Orphan releases them so they keep
+	// running, e.g. while migrating them to another controller.
+	// +optional
+	DeletionPolicy DeletionPolicyType `json:"deletionPolicy,omitempty"`
+	// RevisionHistoryLimit is the number of old ControllerRevisions to retain
+	// to allow rollback. Defaults to 10.
+	// +optional
+	// +kubebuilder:validation:Minimum=0
+	RevisionHistoryLimit *int32 `json:"revisionHistoryLimit,omitempty"`
+	// RollbackTo is the revision to roll back to. The controller restores the
+	// template of that revision and clears this field.
+	// +optional
+	RollbackTo *RollbackConfig `json:"rollbackTo,omitempty"`
+	// Autoscaling lets the controller manage Replicas, following a metric
+	// between a minimum and a maximum number of replicas.
+	// +optional
+	Autoscaling *autoscaling.Spec `json:"autoscaling,omitempty"`
+}
+
+// PodTemplate returns a copy of the template, the empty template when only
+// Image is set.
+func (s *CustomDeploymentSpec) PodTemplate() *corev1.PodTemplateSpec {
+	if s.Template == nil {
+		return &corev1.PodTemplateSpec{}
+	}
+	return s.Template.DeepCopy()
+}
+
+// PodManagementPolicyType defines the policy for creating pods under a
+// CustomDeployment.
+// +kubebuilder:validation:Enum=OrderedReady;Parallel
+type PodManagementPolicyType string
+
+const (
+	// OrderedReadyPodManagement creates pods in increasing ordinal order and
+	// deletes them in decreasing order, one at a time, waiting for each pod
+	// to be Ready before continuing.
+	OrderedReadyPodManagement PodManagementPolicyType = "OrderedReady"
+	// ParallelPodManagement creates and deletes pods without waiting for
+	// other pods.
+	ParallelPodManagement PodManagementPolicyType = "Parallel"
+)
+
+// DeletionPolicyType defines what happens to the pods of a deleted
+// CustomDeployment.
+// +kubebuilder:validation:Enum=Delete;Orphan
+type DeletionPolicyType string
+
+const (
+	// DeleteDeletionPolicy deletes the pods along with the CustomDeployment.
+	DeleteDeletionPolicy DeletionPolicyType = "Delete"
+	// OrphanDeletionPolicy removes the owner references from the pods so
+	// they survive the CustomDeployment.
+	OrphanDeletionPolicy DeletionPolicyType = "Orphan"
+)
+
+// RollbackConfig describes a rollback request.
+type RollbackConfig struct {
+	// Revision to roll back to. If set to 0, rolls back to the previous revision.
+	// +optional
+	// +kubebuilder:validation:Minimum=0
+	Revision int64 `json:"revision,omitempty"`
+}
+
+// CustomDeploymentStrategyType is the type of a CustomDeploymentStrategy.
+// +kubebuilder:validation:Enum=RollingUpdate;Recreate
+type CustomDeploymentStrategyType string
+
+const (
+	// RollingUpdateCustomDeploymentStrategyType replaces old pods with new
+	// ones gradually, honouring MaxSurge and MaxUnavailable.
+	RollingUpdateCustomDeploymentStrategyType CustomDeploymentStrategyType = "RollingUpdate"
+	// RecreateCustomDeploymentStrategyType deletes all old pods before
+	// creating new ones.
+	RecreateCustomDeploymentStrategyType CustomDeploymentStrategyType = "Recreate"
+)
+
+// CustomDeploymentStrategy describes how to replace existing pods with new ones.
+type CustomDeploymentStrategy struct {
+	// Type of the rollout. Can be "RollingUpdate" or "Recreate". Default is RollingUpdate.
+	// +optional
+	Type CustomDeploymentStrategyType `json:"type,omitempty"`
+	// RollingUpdate configures the rollout when Type is RollingUpdate
+	// +optional
+	RollingUpdate *RollingUpdateCustomDeployment `json:"rollingUpdate,omitempty"`
+}
+
+// RollingUpdateCustomDeployment controls the pace of a rolling update.
+type RollingUpdateCustomDeployment struct {
+	// MaxUnavailable is the maximum number of pods that can be unavailable
+	// during the update, as an absolute number or a percentage of the desired
+	// replicas. Percentages are rounded down. Defaults to 25%.
+	// +optional
+	MaxUnavailable *intstr.IntOrString `json:"maxUnavailable,omitempty"`
+	// MaxSurge is the maximum number of pods that can be created over the
+	// desired replicas during the update, as an absolute number or a
+	// percentage of the desired replicas. Percentages are rounded up.
+	// Defaults to 25%.
+	// +optional
+	MaxSurge *intstr.IntOrString `json:"maxSurge,omitempty"`
 }
 
 // CustomDeploymentStatus defines the observed state of CustomDeployment
 type CustomDeploymentStatus struct {
-	// INSERT ADDITIONAL STATUS FIELD - define observed state of cluster
-	// Important: Run "make" to regenerate code after modifying this file
+	// Replicas is the number of active pods targeted by this CustomDeployment
+	// +optional
+	Replicas int32 `json:"replicas,omitempty"`
+	// ReadyReplicas is the number of targeted pods with a Ready condition
+	// +optional
+	ReadyReplicas int32 `json:"readyReplicas,omitempty"`
+	// AvailableReplicas is the number of targeted pods available to serve
+	// +optional
+	AvailableReplicas int32 `json:"availableReplicas,omitempty"`
+	// ObservedGeneration is the most recent generation observed by the controller
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	// UpdatedReplicas is the number of active pods running the current template
+	// +optional
+	UpdatedReplicas int32 `json:"updatedReplicas,omitempty"`
+	// CurrentRevision is the revision of the ControllerRevision holding the
+	// current template
+	// +optional
+	CurrentRevision int64 `json:"currentRevision,omitempty"`
+	// TemplateHash is the hash of the current template, pods running it carry
+	// it in their pod-template-hash label
+	// +optional
+	TemplateHash string `json:"templateHash,omitempty"`
+	// Selector is the label selector of the pods, in string form
+	// +optional
+	Selector string `json:"selector,omitempty"`
+	// Autoscaling reports the last decision of the autoscaler
+	// +optional
+	Autoscaling *autoscaling.Status `json:"autoscaling,omitempty"`
+	// Conditions represent the latest available observations of the CustomDeployment's state
+	// +optional
+	// +patchMergeKey=type
+	// +patchStrategy=merge
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty" patchStrategy:"merge" patchMergeKey:"type"`
 }
 
+// These are valid condition types of a CustomDeployment.
+const (
+	// ConditionAvailable means the CustomDeployment has at least the desired
+	// number of available pods.
+	ConditionAvailable = "Available"
+	// ConditionProgressing means the CustomDeployment is creating or deleting
+	// pods, or waiting for them to become ready.
+	ConditionProgressing = "Progressing"
+	// ConditionReplicaFailure is added when one of its pods fails to be
+	// created or deleted.
+	ConditionReplicaFailure = "ReplicaFailure"
+	// ConditionImagePolicyViolation is added when one of its images is not
+	// allowed by the image policy.
+	ConditionImagePolicyViolation = "ImagePolicyViolation"
+	// ConditionScalingActive means the autoscaler is able to read the
+	// metric and compute the replicas.
+	ConditionScalingActive = "ScalingActive"
+	// ConditionScalingLimited means the metric asks for more or fewer
+	// replicas than the autoscaler allows.
+	ConditionScalingLimited = "ScalingLimited"
+)
+
 // +kubebuilder:object:root=true
 // +kubebuilder:subresource:status
+// +kubebuilder:subresource:scale:specpath=.spec.replicas,statuspath=.status.replicas,selectorpath=.status.selector
+// +kubebuilder:storageversion
 
 // CustomDeployment is the Schema for the customdeployments API
 type CustomDeployment struct {
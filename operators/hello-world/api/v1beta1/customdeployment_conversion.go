@@ -0,0 +1,168 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	apiequality "k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/conversion"
+
+	"github.com/mbtamuli/hello-world/api/v1alpha1"
+)
+
+// imageAnnotation keeps the v1alpha1 Spec.Image of a CustomDeployment read
+// through v1beta1, which has no such field, so that writing it back restores
+// the field.
+const imageAnnotation = "v1alpha1.demo.mriyam.dev/image"
+
+// replicasAnnotation marks a CustomDeployment written through v1beta1
+// without Spec.Replicas. v1alpha1 cannot leave them out and stores the
+// default of 1, the annotation has reading it back through v1beta1 leave
+// them out again.
+const replicasAnnotation = "v1beta1.demo.mriyam.dev/replicas-omitted"
+
+var _ conversion.Convertible = &CustomDeployment{}
+
+// ConvertTo converts this CustomDeployment to the Hub version (v1alpha1).
+// Omitted Replicas convert to the default of 1, marked by replicasAnnotation.
+func (src *CustomDeployment) ConvertTo(dstRaw conversion.Hub) error {
+	dst, ok := dstRaw.(*v1alpha1.CustomDeployment)
+	if !ok {
+		return fmt.Errorf("unsupported hub %T", dstRaw)
+	}
+
+	dst.ObjectMeta = *src.ObjectMeta.DeepCopy()
+	removeAnnotation(&dst.ObjectMeta, replicasAnnotation)
+	dst.Spec.Replicas = 1
+	if src.Spec.Replicas != nil {
+		dst.Spec.Replicas = int(*src.Spec.Replicas)
+	} else {
+		if dst.Annotations == nil {
+			dst.Annotations = map[string]string{}
+		}
+		dst.Annotations[replicasAnnotation] = "true"
+	}
+	dst.Spec.Selector = src.Spec.Selector.DeepCopy()
+	dst.Spec.Template = src.Spec.Template.DeepCopy()
+	dst.Spec.Strategy.Type = v1alpha1.CustomDeploymentStrategyType(src.Spec.Strategy.Type)
+	dst.Spec.Strategy.RollingUpdate = nil
+	if rollingUpdate := src.Spec.Strategy.RollingUpdate; rollingUpdate != nil {
+		dst.Spec.Strategy.RollingUpdate = (*v1alpha1.RollingUpdateCustomDeployment)(rollingUpdate.DeepCopy())
+	}
+	dst.Spec.PodManagementPolicy = v1alpha1.PodManagementPolicyType(src.Spec.PodManagementPolicy)
+	dst.Spec.DeletionPolicy = v1alpha1.DeletionPolicyType(src.Spec.DeletionPolicy)
+	dst.Spec.RevisionHistoryLimit = nil
+	if src.Spec.RevisionHistoryLimit != nil {
+		limit := *src.Spec.RevisionHistoryLimit
+		dst.Spec.RevisionHistoryLimit = &limit
+	}
+	dst.Spec.RollbackTo = nil
+	if src.Spec.RollbackTo != nil {
+		dst.Spec.RollbackTo = &v1alpha1.RollbackConfig{Revision: src.Spec.RollbackTo.Revision}
+	}
+	dst.Spec.Autoscaling = src.Spec.Autoscaling.DeepCopy()
+
+	// Restore the image shorthand, and drop the container standing for it
+	// unless it was changed in the meantime
+	dst.Spec.Image = ""
+	if image, ok := dst.Annotations[imageAnnotation]; ok {
+		removeAnnotation(&dst.ObjectMeta, imageAnnotation)
+		dst.Spec.Image = image
+		containers := dst.Spec.Template.Spec.Containers
+		if len(containers) == 1 && apiequality.Semantic.DeepEqual(containers[0], imageContainer(image)) {
+			dst.Spec.Template.Spec.Containers = nil
+		}
+	}
+	// v1alpha1 leaves out the template of a CustomDeployment with only an
+	// Image
+	if apiequality.Semantic.DeepEqual(*dst.Spec.Template, corev1.PodTemplateSpec{}) {
+		dst.Spec.Template = nil
+	}
+
+	dst.Status = v1alpha1.CustomDeploymentStatus(*src.Status.DeepCopy())
+	return nil
+}
+
+// ConvertFrom converts from the Hub version (v1alpha1) to this version.
+// Spec.Image is turned into the container it stands for. Replicas omitted
+// through v1beta1 are left out again, unless they were changed since.
+func (dst *CustomDeployment) ConvertFrom(srcRaw conversion.Hub) error {
+	src, ok := srcRaw.(*v1alpha1.CustomDeployment)
+	if !ok {
+		return fmt.Errorf("unsupported hub %T", srcRaw)
+	}
+
+	dst.ObjectMeta = *src.ObjectMeta.DeepCopy()
+	replicas := int32(src.Spec.Replicas)
+	dst.Spec.Replicas = &replicas
+	if _, ok := dst.Annotations[replicasAnnotation]; ok {
+		removeAnnotation(&dst.ObjectMeta, replicasAnnotation)
+		if replicas == 1 {
+			dst.Spec.Replicas = nil
+		}
+	}
+	dst.Spec.Selector = src.Spec.Selector.DeepCopy()
+	dst.Spec.Template = *src.Spec.PodTemplate()
+	dst.Spec.Strategy.Type = CustomDeploymentStrategyType(src.Spec.Strategy.Type)
+	dst.Spec.Strategy.RollingUpdate = nil
+	if rollingUpdate := src.Spec.Strategy.RollingUpdate; rollingUpdate != nil {
+		dst.Spec.Strategy.RollingUpdate = (*RollingUpdateCustomDeployment)(rollingUpdate.DeepCopy())
+	}
+	dst.Spec.PodManagementPolicy = PodManagementPolicyType(src.Spec.PodManagementPolicy)
+	dst.Spec.DeletionPolicy = DeletionPolicyType(src.Spec.DeletionPolicy)
+	dst.Spec.RevisionHistoryLimit = nil
+	if src.Spec.RevisionHistoryLimit != nil {
+		limit := *src.Spec.RevisionHistoryLimit
+		dst.Spec.RevisionHistoryLimit = &limit
+	}
+	dst.Spec.RollbackTo = nil
+	if src.Spec.RollbackTo != nil {
+		dst.Spec.RollbackTo = &RollbackConfig{Revision: src.Spec.RollbackTo.Revision}
+	}
+	dst.Spec.Autoscaling = src.Spec.Autoscaling.DeepCopy()
+
+	if image := src.Spec.Image; image != "" {
+		if dst.Annotations == nil {
+			dst.Annotations = map[string]string{}
+		}
+		dst.Annotations[imageAnnotation] = image
+		if len(dst.Spec.Template.Spec.Containers) == 0 {
+			dst.Spec.Template.Spec.Containers = []corev1.Container{imageContainer(image)}
+		}
+	}
+
+	dst.Status = CustomDeploymentStatus(*src.Status.DeepCopy())
+	return nil
+}
+
+// removeAnnotation removes the annotation from meta, leaving no empty
+// annotations behind.
+func removeAnnotation(meta *metav1.ObjectMeta, key string) {
+	delete(meta.Annotations, key)
+	if len(meta.Annotations) == 0 {
+		meta.Annotations = nil
+	}
+}
+
+// imageContainer returns the container the controller runs for a v1alpha1
+// Spec.Image.
+func imageContainer(image string) corev1.Container {
+	return corev1.Container{Name: v1alpha1.DefaultContainerName, Image: image}
+}
@@ -0,0 +1,172 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	"math/rand"
+	"testing"
+
+	fuzz "github.com/google/gofuzz"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/apitesting/fuzzer"
+	apiequality "k8s.io/apimachinery/pkg/api/equality"
+	metafuzzer "k8s.io/apimachinery/pkg/apis/meta/fuzzer"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/serializer"
+	"k8s.io/apimachinery/pkg/util/diff"
+
+	"github.com/mbtamuli/hello-world/api/v1alpha1"
+)
+
+const fuzzIterations = 1000
+
+// conversionFuzzerFuncs keeps the fuzzed objects within what the API server
+// admits: replicas fit in an int32.
+func conversionFuzzerFuncs(serializer.CodecFactory) []interface{} {
+	return []interface{}{
+		func(spec *v1alpha1.CustomDeploymentSpec, c fuzz.Continue) {
+			c.FuzzNoCustom(spec)
+			spec.Replicas = int(c.Int31())
+		},
+	}
+}
+
+func newConversionFuzzer(t *testing.T) *fuzz.Fuzzer {
+	t.Helper()
+	scheme := runtime.NewScheme()
+	if err := v1alpha1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	funcs := fuzzer.MergeFuzzerFuncs(metafuzzer.Funcs, conversionFuzzerFuncs)
+	return fuzzer.FuzzerFor(funcs, rand.NewSource(rand.Int63()), serializer.NewCodecFactory(scheme))
+}
+
+func TestCustomDeploymentHubSpokeHub(t *testing.T) {
+	f := newConversionFuzzer(t)
+	for i := 0; i < fuzzIterations; i++ {
+		hub := &v1alpha1.CustomDeployment{}
+		f.Fuzz(hub)
+
+		spoke := &CustomDeployment{}
+		if err := spoke.ConvertFrom(hub.DeepCopy()); err != nil {
+			t.Fatalf("ConvertFrom() error = %v", err)
+		}
+		got := &v1alpha1.CustomDeployment{}
+		if err := spoke.ConvertTo(got); err != nil {
+			t.Fatalf("ConvertTo() error = %v", err)
+		}
+		if !apiequality.Semantic.DeepEqual(hub, got) {
+			t.Fatalf("v1alpha1 changed by the round trip:\n%s", diff.ObjectReflectDiff(hub, got))
+		}
+	}
+}
+
+func TestCustomDeploymentSpokeHubSpoke(t *testing.T) {
+	f := newConversionFuzzer(t)
+	for i := 0; i < fuzzIterations; i++ {
+		spoke := &CustomDeployment{}
+		f.Fuzz(spoke)
+
+		hub := &v1alpha1.CustomDeployment{}
+		if err := spoke.DeepCopy().ConvertTo(hub); err != nil {
+			t.Fatalf("ConvertTo() error = %v", err)
+		}
+		got := &CustomDeployment{}
+		if err := got.ConvertFrom(hub); err != nil {
+			t.Fatalf("ConvertFrom() error = %v", err)
+		}
+		if !apiequality.Semantic.DeepEqual(spoke, got) {
+			t.Fatalf("v1beta1 changed by the round trip:\n%s", diff.ObjectReflectDiff(spoke, got))
+		}
+	}
+}
+
+func TestCustomDeploymentConvertImage(t *testing.T) {
+	hub := &v1alpha1.CustomDeployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "web"},
+		Spec:       v1alpha1.CustomDeploymentSpec{Replicas: 0, Image: "nginx:1.21"},
+	}
+	spoke := &CustomDeployment{}
+	if err := spoke.ConvertFrom(hub); err != nil {
+		t.Fatalf("ConvertFrom() error = %v", err)
+	}
+	if spoke.Spec.Replicas == nil || *spoke.Spec.Replicas != 0 {
+		t.Errorf("Replicas = %v, want 0", spoke.Spec.Replicas)
+	}
+	want := []corev1.Container{{Name: v1alpha1.DefaultContainerName, Image: "nginx:1.21"}}
+	if got := spoke.Spec.Template.Spec.Containers; !apiequality.Semantic.DeepEqual(got, want) {
+		t.Errorf("Containers = %v, want %v", got, want)
+	}
+
+	// Converting back leaves out the template again
+	got := &v1alpha1.CustomDeployment{}
+	if err := spoke.DeepCopy().ConvertTo(got); err != nil {
+		t.Fatalf("ConvertTo() error = %v", err)
+	}
+	if got.Spec.Image != "nginx:1.21" || got.Spec.Template != nil {
+		t.Errorf("Image, Template = %q, %v, want nginx:1.21 and no template", got.Spec.Image, got.Spec.Template)
+	}
+
+	// Changing the image through v1beta1 moves it to the template
+	spoke.Spec.Template.Spec.Containers[0].Image = "nginx:1.22"
+	got = &v1alpha1.CustomDeployment{}
+	if err := spoke.ConvertTo(got); err != nil {
+		t.Fatalf("ConvertTo() error = %v", err)
+	}
+	if got.Spec.Template == nil || len(got.Spec.Template.Spec.Containers) != 1 || got.Spec.Template.Spec.Containers[0].Image != "nginx:1.22" {
+		t.Errorf("expected the changed container to be kept, got %v", got.Spec.Template.Spec.Containers)
+	}
+	if _, ok := got.Annotations[imageAnnotation]; ok {
+		t.Errorf("expected the %s annotation to be dropped", imageAnnotation)
+	}
+}
+
+func TestCustomDeploymentConvertOmittedReplicas(t *testing.T) {
+	spoke := &CustomDeployment{ObjectMeta: metav1.ObjectMeta{Name: "web"}}
+	hub := &v1alpha1.CustomDeployment{}
+	if err := spoke.DeepCopy().ConvertTo(hub); err != nil {
+		t.Fatalf("ConvertTo() error = %v", err)
+	}
+	if hub.Spec.Replicas != 1 {
+		t.Errorf("Replicas = %d, want the default of 1", hub.Spec.Replicas)
+	}
+
+	got := &CustomDeployment{}
+	if err := got.ConvertFrom(hub.DeepCopy()); err != nil {
+		t.Fatalf("ConvertFrom() error = %v", err)
+	}
+	if got.Spec.Replicas != nil || got.Annotations != nil {
+		t.Errorf("Replicas, Annotations = %v, %v, want them left out", got.Spec.Replicas, got.Annotations)
+	}
+
+	// Scaling through v1alpha1 sets the replicas
+	hub.Spec.Replicas = 3
+	got = &CustomDeployment{}
+	if err := got.ConvertFrom(hub); err != nil {
+		t.Fatalf("ConvertFrom() error = %v", err)
+	}
+	if got.Spec.Replicas == nil || *got.Spec.Replicas != 3 {
+		t.Errorf("Replicas = %v, want 3", got.Spec.Replicas)
+	}
+	if _, ok := got.Annotations[replicasAnnotation]; ok {
+		t.Errorf("expected the %s annotation to be dropped", replicasAnnotation)
+	}
+}
@@ -0,0 +1,158 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	demov1alpha1 "github.com/mbtamuli/hello-world/api/v1alpha1"
+	"github.com/mbtamuli/hello-world/pkg/autoscaling"
+)
+
+// autoscaleSyncPeriod is how often the metric of an autoscaled
+// CustomDeployment is read.
+const autoscaleSyncPeriod = 15 * time.Second
+
+// Reasons used for the autoscaling conditions and events.
+const (
+	reasonValidMetricFound   = "ValidMetricFound"
+	reasonFailedGetMetric    = "FailedGetMetric"
+	reasonTooFewReplicas     = "TooFewReplicas"
+	reasonTooManyReplicas    = "TooManyReplicas"
+	reasonDesiredWithinRange = "DesiredWithinRange"
+	reasonAutoscaled         = "Autoscaled"
+)
+
+// errNoMetricsSource is reported when a CustomDeployment asks for autoscaling
+// but the operator has no metrics source configured.
+var errNoMetricsSource = errors.New("no metrics source is configured for the autoscaler")
+
+// autoscaleResult is the outcome of autoscale, reported in the status.
+type autoscaleResult struct {
+	decision autoscaling.Decision
+	// err is the failure to read the metric, the replicas are left alone
+	err error
+	// scaled is true when the replicas were changed
+	scaled bool
+}
+
+// autoscale sets the replicas of the CustomDeployment to those the
+// autoscaler asks for, and returns its decision. It returns nil when the
+// CustomDeployment is not autoscaled.
+func (r *CustomDeploymentReconciler) autoscale(ctx context.Context, cd *demov1alpha1.CustomDeployment) (*autoscaleResult, error) {
+	key := client.ObjectKeyFromObject(cd).String()
+	if cd.Spec.Autoscaling == nil {
+		r.forgetAutoscaling(key)
+		return nil, nil
+	}
+	if r.Autoscaler == nil {
+		return &autoscaleResult{err: errNoMetricsSource}, nil
+	}
+
+	logger := log.FromContext(ctx)
+	current := int32(cd.Spec.Replicas)
+	decision, err := r.Autoscaler.Decide(ctx, key, cd.Spec.Autoscaling, current)
+	if err != nil {
+		logger.Error(err, "Failed to compute the replicas from the metric", "action", "autoscale")
+		r.Recorder.Eventf(cd, corev1.EventTypeWarning, reasonFailedGetMetric, "Failed to get the metric: %v", err)
+		return &autoscaleResult{err: err}, nil
+	}
+	result := &autoscaleResult{decision: decision}
+	if decision.Desired == current {
+		return result, nil
+	}
+
+	cd.Spec.Replicas = int(decision.Desired)
+	if err := r.Update(ctx, cd); err != nil {
+		logger.Error(err, "Failed to update the replicas", "action", "autoscale", "desired", decision.Desired)
+		return nil, err
+	}
+	logger.Info("Autoscaled", "action", "autoscale", "from", current, "to", decision.Desired, "metricValue", decision.MetricValue)
+	r.Recorder.Eventf(cd, corev1.EventTypeNormal, reasonAutoscaled, "Scaled from %d to %d replicas, the metric is %s for a target of %s per replica",
+		current, decision.Desired, metricQuantity(decision.MetricValue).String(), cd.Spec.Autoscaling.Metric.TargetAverageValue.String())
+	result.scaled = true
+	return result, nil
+}
+
+// forgetAutoscaling drops what the autoscaler remembers of the
+// CustomDeployment identified by key.
+func (r *CustomDeploymentReconciler) forgetAutoscaling(key string) {
+	if r.Autoscaler != nil {
+		r.Autoscaler.Forget(key)
+	}
+}
+
+// setAutoscalingStatus reports the decision of the autoscaler in the status:
+// the ScalingActive and ScalingLimited conditions and the autoscaling
+// status. Both are removed when the CustomDeployment is not autoscaled.
+func setAutoscalingStatus(status *demov1alpha1.CustomDeploymentStatus, cd *demov1alpha1.CustomDeployment, result *autoscaleResult, now metav1.Time) {
+	if result == nil {
+		status.Autoscaling = nil
+		meta.RemoveStatusCondition(&status.Conditions, demov1alpha1.ConditionScalingActive)
+		meta.RemoveStatusCondition(&status.Conditions, demov1alpha1.ConditionScalingLimited)
+		return
+	}
+	if result.err != nil {
+		setCondition(status, demov1alpha1.ConditionScalingActive, metav1.ConditionFalse, reasonFailedGetMetric, result.err.Error())
+		return
+	}
+
+	decision := result.decision
+	setCondition(status, demov1alpha1.ConditionScalingActive, metav1.ConditionTrue, reasonValidMetricFound,
+		"The replicas are computed from the metric.")
+	switch {
+	case decision.Limited && decision.Desired == cd.Spec.Autoscaling.MaxReplicas:
+		setCondition(status, demov1alpha1.ConditionScalingLimited, metav1.ConditionTrue, reasonTooManyReplicas,
+			fmt.Sprintf("The metric asks for %d replicas, more than the maximum of %d.", decision.Recommended, decision.Desired))
+	case decision.Limited:
+		setCondition(status, demov1alpha1.ConditionScalingLimited, metav1.ConditionTrue, reasonTooFewReplicas,
+			fmt.Sprintf("The metric asks for %d replicas, fewer than the minimum of %d.", decision.Recommended, decision.Desired))
+	default:
+		setCondition(status, demov1alpha1.ConditionScalingLimited, metav1.ConditionFalse, reasonDesiredWithinRange,
+			"The desired replicas are within the acceptable range.")
+	}
+
+	if status.Autoscaling == nil {
+		status.Autoscaling = &autoscaling.Status{}
+	}
+	status.Autoscaling.CurrentMetricValue = metricQuantity(decision.MetricValue)
+	status.Autoscaling.DesiredReplicas = decision.Desired
+	if result.scaled {
+		status.Autoscaling.LastScaleTime = &now
+	}
+}
+
+// metricQuantity returns the value of a metric as a quantity, rounded to
+// the thousandth.
+func metricQuantity(value float64) *resource.Quantity {
+	milli := math.Round(value * 1000)
+	if milli >= math.MaxInt64 {
+		return resource.NewMilliQuantity(math.MaxInt64, resource.DecimalSI)
+	}
+	return resource.NewMilliQuantity(int64(milli), resource.DecimalSI)
+}
@@ -0,0 +1,141 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	demov1alpha1 "github.com/mbtamuli/hello-world/api/v1alpha1"
+	"github.com/mbtamuli/hello-world/pkg/autoscaling"
+)
+
+const testMetricQuery = `sum(rate(http_requests_total{service="web"}[2m]))`
+
+func TestAutoscale(t *testing.T) {
+	minReplicas := int32(1)
+	cd := newTestCustomDeployment(1, "nginx:1.21")
+	cd.Spec.Autoscaling = &autoscaling.Spec{
+		MinReplicas: &minReplicas,
+		MaxReplicas: 5,
+		Metric:      autoscaling.Metric{Query: testMetricQuery, TargetAverageValue: resource.MustParse("10")},
+	}
+	r := newTestReconciler(cd)
+	metrics := &autoscaling.FakeSource{}
+	r.Autoscaler = autoscaling.NewAutoscaler(metrics)
+	recorder := r.Recorder.(*record.FakeRecorder)
+	ctx := context.Background()
+	now := metav1.Now()
+
+	autoscale := func() demov1alpha1.CustomDeploymentStatus {
+		t.Helper()
+		if err := r.Get(ctx, client.ObjectKeyFromObject(cd), cd); err != nil {
+			t.Fatal(err)
+		}
+		result, err := r.autoscale(ctx, cd)
+		if err != nil {
+			t.Fatalf("autoscale() error = %v", err)
+		}
+		status := cd.Status
+		setAutoscalingStatus(&status, cd, result, now)
+		cd.Status = status
+		return status
+	}
+	condition := func(status demov1alpha1.CustomDeploymentStatus, conditionType string) *metav1.Condition {
+		return meta.FindStatusCondition(status.Conditions, conditionType)
+	}
+
+	metrics.SetValue(testMetricQuery, 30)
+	status := autoscale()
+	if cd.Spec.Replicas != 3 {
+		t.Fatalf("Replicas = %d, want 3", cd.Spec.Replicas)
+	}
+	if c := condition(status, demov1alpha1.ConditionScalingActive); c == nil || c.Status != metav1.ConditionTrue {
+		t.Errorf("expected ScalingActive to be True, got %+v", c)
+	}
+	if c := condition(status, demov1alpha1.ConditionScalingLimited); c == nil || c.Status != metav1.ConditionFalse {
+		t.Errorf("expected ScalingLimited to be False, got %+v", c)
+	}
+	if a := status.Autoscaling; a == nil || a.DesiredReplicas != 3 || a.CurrentMetricValue.Cmp(resource.MustParse("30")) != 0 || a.LastScaleTime == nil {
+		t.Errorf("unexpected autoscaling status %+v", a)
+	}
+	if event := <-recorder.Events; !strings.Contains(event, reasonAutoscaled) || !strings.Contains(event, "from 1 to 3") {
+		t.Errorf("unexpected event %q", event)
+	}
+
+	metrics.SetValue(testMetricQuery, 100)
+	status = autoscale()
+	if cd.Spec.Replicas != 5 {
+		t.Fatalf("Replicas = %d, want the maximum of 5", cd.Spec.Replicas)
+	}
+	if c := condition(status, demov1alpha1.ConditionScalingLimited); c == nil || c.Status != metav1.ConditionTrue || c.Reason != reasonTooManyReplicas {
+		t.Errorf("expected ScalingLimited to be True, got %+v", c)
+	}
+	<-recorder.Events
+
+	metrics.SetError(testMetricQuery, errors.New("prometheus unavailable"))
+	status = autoscale()
+	if cd.Spec.Replicas != 5 {
+		t.Errorf("Replicas = %d, want them left at 5", cd.Spec.Replicas)
+	}
+	if c := condition(status, demov1alpha1.ConditionScalingActive); c == nil || c.Status != metav1.ConditionFalse || c.Reason != reasonFailedGetMetric {
+		t.Errorf("expected ScalingActive to be False, got %+v", c)
+	}
+	if event := <-recorder.Events; !strings.Contains(event, reasonFailedGetMetric) {
+		t.Errorf("unexpected event %q", event)
+	}
+
+	cd.Spec.Autoscaling = nil
+	if err := r.Update(ctx, cd); err != nil {
+		t.Fatal(err)
+	}
+	status = autoscale()
+	if status.Autoscaling != nil || condition(status, demov1alpha1.ConditionScalingActive) != nil || condition(status, demov1alpha1.ConditionScalingLimited) != nil {
+		t.Errorf("expected the autoscaling status to be removed, got %+v", status)
+	}
+}
+
+func TestAutoscaleWithoutMetricsSource(t *testing.T) {
+	cd := newTestCustomDeployment(2, "nginx:1.21")
+	cd.Spec.Autoscaling = &autoscaling.Spec{
+		MaxReplicas: 5,
+		Metric:      autoscaling.Metric{Query: testMetricQuery, TargetAverageValue: resource.MustParse("10")},
+	}
+	r := newTestReconciler(cd)
+
+	result, err := r.autoscale(context.Background(), cd)
+	if err != nil {
+		t.Fatalf("autoscale() error = %v", err)
+	}
+	status := cd.Status
+	setAutoscalingStatus(&status, cd, result, metav1.Now())
+	c := meta.FindStatusCondition(status.Conditions, demov1alpha1.ConditionScalingActive)
+	if c == nil || c.Status != metav1.ConditionFalse || c.Message != errNoMetricsSource.Error() {
+		t.Errorf("expected ScalingActive to be False, got %+v", c)
+	}
+	if cd.Spec.Replicas != 2 {
+		t.Errorf("Replicas = %d, want them left at 2", cd.Spec.Replicas)
+	}
+}
@@ -17,58 +17,143 @@ limitations under the License.
 package controllers
 
 import (
-	"math/rand"
+	"fmt"
+	"reflect"
 	"strings"
-	"time"
 
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 
 	"context"
 
-	"github.com/go-logr/logr"
+	"k8s.io/apimachinery/pkg/api/equality"
 	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/workqueue"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 
 	demov1alpha1 "github.com/mbtamuli/hello-world/api/v1alpha1"
+	"github.com/mbtamuli/hello-world/pkg/autoscaling"
+	"github.com/mbtamuli/k8s/common/imagepolicy"
+	"github.com/mbtamuli/k8s/common/logging"
+	"github.com/mbtamuli/k8s/common/metrics"
+	"github.com/mbtamuli/k8s/common/tracing"
+)
+
+const (
+	// defaultContainerName is the name of the container created from
+	// Spec.Image when the template has no containers.
+	defaultContainerName = demov1alpha1.DefaultContainerName
+
+	// maxCreateAttempts bounds how often a pod creation is retried when
+	// its generated name collides with an existing pod.
+	maxCreateAttempts = 3
+)
+
+// Reasons used for the CustomDeployment events, on top of the FailedCreate
+// and FailedDelete condition reasons. They stay the same from one reconcile
+// to the next so that the event recorder aggregates repeated events, the
+// details are in the message.
+const (
+	reasonSuccessfulCreate = "SuccessfulCreate"
+	reasonSuccessfulDelete = "SuccessfulDelete"
+	reasonScaled           = "Scaled"
+	reasonInvalidSelector  = "InvalidSelector"
 )
 
 // CustomDeploymentReconciler reconciles a CustomDeployment object
 type CustomDeploymentReconciler struct {
 	client.Client
-	Log    logr.Logger
 	Scheme *runtime.Scheme
+	// Recorder emits the events of the CustomDeployment, shown by kubectl
+	// describe
+	Recorder record.EventRecorder
+	// ImagePolicy is the image policy violations are reported against,
+	// none are reported when nil
+	ImagePolicy imagepolicy.Source
+	// Autoscaler computes the replicas of the autoscaled CustomDeployments,
+	// they are reported as failing to scale when nil
+	Autoscaler *autoscaling.Autoscaler
+	// MaxConcurrentReconciles is the number of CustomDeployments reconciled
+	// at the same time, 1 when not set
+	MaxConcurrentReconciles int
+
+	// expectations tracks the pod creations and deletions not yet observed
+	// in the cache, it is set up by SetupWithManager
+	expectations *expectations
 }
 
 // +kubebuilder:rbac:groups=demo.mriyam.dev,resources=customdeployments,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=demo.mriyam.dev,resources=customdeployments/status,verbs=get;update;patch
 // +kubebuilder:rbac:groups=demo.mriyam.dev,resources=customdeployments/finalizers,verbs=update
 // +kubebuilder:rbac:groups=apps,resources=deployments,verbs=get;list;watch;create;update;patch;delete
-// +kubebuilder:rbac:groups=core,resources=pods,verbs=get;list;
-
-// Reconcile is part of the main kubernetes reconciliation loop which aims to
-// move the current state of the cluster closer to the desired state.
-// TODO(user): Modify the Reconcile function to compare the state specified by
-// the CustomDeployment object against the actual cluster state, and then
-// perform operations to make the cluster state reflect the state specified by
-// the user.
+// +kubebuilder:rbac:groups=apps,resources=controllerrevisions,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=core,resources=pods,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=core,resources=events,verbs=create;patch
+// +kubebuilder:rbac:groups=demo.mriyam.dev,resources=imagepolicies,verbs=get;list;watch
+
+// Reconcile moves the pods of a CustomDeployment towards its spec. It
+// finalizes a CustomDeployment being deleted, restores the revision requested
+// by a rollback, lets the autoscaler pick the replicas, records the current
+// template in a ControllerRevision, creates, deletes or replaces pods to match
+// the replicas and the template, and finally reports what it observed in the
+// status.
 //
 // For more details, check Reconcile and its Result here:
 // - https://pkg.go.dev/sigs.k8s.io/controller-runtime@v0.7.0/pkg/reconcile
 func (r *CustomDeploymentReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
-	log := r.Log.WithValues("customdeployment", req.NamespacedName)
+	logger := log.FromContext(ctx)
 
 	// Fetch the CustomDeployment instance
 	deployment := &demov1alpha1.CustomDeployment{}
 	err := r.Get(ctx, req.NamespacedName, deployment)
 	if err != nil {
 		if errors.IsNotFound(err) {
-			log.Info("CustomDeployment resource not found. Ignoring since object must be deleted")
+			logger.V(logging.Debug).Info("CustomDeployment not found, it must have been deleted")
+			r.expectations.DeleteExpectations(req.NamespacedName.String())
+			r.forgetAutoscaling(req.NamespacedName.String())
+			forgetMetrics(req.Namespace, req.Name)
 			return ctrl.Result{}, nil
 		}
-		log.Error(err, "Failed to get CustomDeployment")
+		logger.Error(err, "Failed to get CustomDeployment")
+		return ctrl.Result{}, err
+	}
+
+	// Drain or orphan the pods before letting the CustomDeployment go
+	if !deployment.DeletionTimestamp.IsZero() {
+		return ctrl.Result{}, r.finalize(ctx, deployment)
+	}
+	if err = r.ensureFinalizer(ctx, deployment); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	// Restore an older template if a rollback was requested
+	if deployment.Spec.RollbackTo != nil {
+		return ctrl.Result{}, r.rollback(ctx, deployment)
+	}
+
+	// Let the autoscaler pick the replicas before acting on them
+	autoscaled, err := r.autoscale(ctx, deployment)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	selector, err := selectorForCustomDeployment(deployment)
+	if err != nil {
+		logger.Error(err, "Invalid selector")
+		r.Recorder.Eventf(deployment, corev1.EventTypeWarning, reasonInvalidSelector, "Invalid selector: %v", err)
 		return ctrl.Result{}, err
 	}
 
@@ -76,54 +161,224 @@ func (r *CustomDeploymentReconciler) Reconcile(ctx context.Context, req ctrl.Req
 	podList := &corev1.PodList{}
 	opts := []client.ListOption{
 		client.InNamespace(deployment.Namespace),
-		client.MatchingLabels(labelsForCustomDeployment(deployment.Name)),
+		client.MatchingLabelsSelector{Selector: selector},
 	}
-	log.Info("Trying to List pods")
 	if err = r.List(ctx, podList, opts...); err != nil {
-		log.Info("testing")
-		log.Error(err, "Failed to list pods", "CustomDeployment.Namespace", deployment.Namespace, "CustomDeployment.Name", deployment.Name)
+		logger.Error(err, "Failed to list pods")
 		return ctrl.Result{}, err
 	}
+	logger.V(logging.Trace).Info("Listed pods", "pods", getPodNames(podList.Items))
 
-	// podNames := getPodNames(podList.Items)
-	// for _, pod := range podNames {
-	// 	log.Info(pod)
-	// }
+	// Ensure the pods match the spec, rolling out template changes if needed.
+	// The selector may match pods we did not create, so only consider the
+	// ones we control.
+	templateHash := computeTemplateHash(deployment)
+	revision, err := r.syncRevisions(ctx, deployment, templateHash)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	controlledPods := filterControlledPods(podList.Items, deployment)
+	var manageErr error
+	if r.expectations.SatisfiedExpectations(req.NamespacedName.String()) {
+		manageErr = r.manageReplicas(ctx, deployment, selector, templateHash, controlledPods)
+	} else {
+		// The cache has not caught up with our own changes yet, acting now
+		// would create or delete the same pods again.
+		logger.V(logging.Debug).Info("Waiting for pending pod creations and deletions to be observed")
+	}
 
-	// Ensure the CustomDeployment size is the same as the spec
-	size := deployment.Spec.Replicas
-	if len(podList.Items) != size {
-		pod := r.getPodForCustomDeployment(deployment)
-		log.Info(pod.Name)
-		err = r.Create(ctx, pod)
-		if err != nil {
-			log.Error(err, "Failed to create new Pod", "CustomDeployment.Namespace", pod.Namespace, "CustomDeployment.Name", pod.Name)
+	// Report what we observed, including any failure to create or delete pods
+	status := calculateStatus(deployment, selector, templateHash, filterActivePods(controlledPods), manageErr)
+	status.CurrentRevision = revision
+	r.recordRolloutComplete(deployment, status)
+	r.checkImagePolicy(ctx, deployment, &status)
+	setAutoscalingStatus(&status, deployment, autoscaled, metav1.Now())
+	customDeploymentMetrics.SetReplicas(deployment.Namespace, deployment.Name, int32(deployment.Spec.Replicas), status.ReadyReplicas)
+	if !equality.Semantic.DeepEqual(status, deployment.Status) {
+		deployment.Status = status
+		if err = r.Status().Update(ctx, deployment); err != nil {
+			logger.Error(err, "Failed to update CustomDeployment status")
 			return ctrl.Result{}, err
 		}
-		// Spec updated - return and requeue
-		return ctrl.Result{Requeue: true}, nil
 	}
 
-	log.Info("Nothing happened?")
-	return ctrl.Result{}, nil
+	// Read the metric again later, nothing else would trigger a reconcile
+	var result ctrl.Result
+	if autoscaled != nil {
+		result.RequeueAfter = autoscaleSyncPeriod
+	}
+	return result, manageErr
+}
+
+// manageReplicas creates or deletes pods so that the active pods match the
+// spec. Pods running an outdated template are replaced according to the
+// rollout strategy. Scaling is handled in a single pass so that we converge
+// without requeueing.
+func (r *CustomDeploymentReconciler) manageReplicas(ctx context.Context, cd *demov1alpha1.CustomDeployment, selector labels.Selector, templateHash string, pods []corev1.Pod) error {
+	if cd.Spec.PodManagementPolicy == demov1alpha1.OrderedReadyPodManagement {
+		return r.manageOrderedReplicas(ctx, cd, selector, templateHash, pods)
+	}
+
+	newPods, oldPods := splitPodsByTemplateHash(filterActivePods(pods), templateHash)
+	if cd.Spec.Strategy.Type == demov1alpha1.RecreateCustomDeploymentStrategyType {
+		return r.rolloutRecreate(ctx, cd, selector, templateHash, pods, newPods, oldPods)
+	}
+	if len(oldPods) > 0 {
+		return r.rolloutRolling(ctx, cd, selector, templateHash, newPods, oldPods)
+	}
+	return r.scalePods(ctx, cd, selector, templateHash, newPods, cd.Spec.Replicas)
+}
+
+// scalePods creates or deletes pods running the current template until
+// there are exactly replicas of them.
+func (r *CustomDeploymentReconciler) scalePods(ctx context.Context, cd *demov1alpha1.CustomDeployment, selector labels.Selector, templateHash string, pods []*corev1.Pod, replicas int) error {
+	diff := len(pods) - replicas
+	switch {
+	case diff < 0:
+		r.Recorder.Eventf(cd, corev1.EventTypeNormal, reasonScaled, "Scaling up from %d to %d replicas", len(pods), replicas)
+		return r.createPods(ctx, cd, selector, r.getPodsForCustomDeployment(cd, templateHash, -diff), metrics.ReasonScaleUp)
+	case diff > 0:
+		r.Recorder.Eventf(cd, corev1.EventTypeNormal, reasonScaled, "Scaling down from %d to %d replicas", len(pods), replicas)
+		return r.deletePods(ctx, cd, getPodsToDelete(pods, diff), metrics.ReasonScaleDown)
+	}
+	return nil
+}
+
+// createPods creates the given pods. The creations are expected before they
+// are issued, so that a concurrent reconcile does not act on a cache that has
+// not seen them yet. A single event is recorded for all the pods created,
+// and they are counted under the given reason.
+func (r *CustomDeploymentReconciler) createPods(ctx context.Context, cd *demov1alpha1.CustomDeployment, selector labels.Selector, pods []*corev1.Pod, reason string) error {
+	logger := log.FromContext(ctx)
+	key := client.ObjectKeyFromObject(cd).String()
+	r.expectations.ExpectCreations(key, len(pods))
+	var created []string
+	defer func() {
+		r.recordPods(cd, reasonSuccessfulCreate, "Created", created)
+		customDeploymentMetrics.PodsCreated(cd.Namespace, cd.Name, reason, len(created))
+	}()
+	for i, pod := range pods {
+		var err error
+		if !selector.Matches(labels.Set(pod.Labels)) {
+			// Pods we cannot select would never count towards the
+			// replicas, creating them would never converge.
+			err = fmt.Errorf("selector %q does not match template labels", selector)
+		} else {
+			err = r.createPod(ctx, pod)
+		}
+		if err != nil {
+			logger.Error(err, "Failed to create pod", "action", "create", "reason", reason, "pod", pod.Name, "generateName", pod.GenerateName)
+			r.Recorder.Eventf(cd, corev1.EventTypeWarning, reasonFailedCreate, "Error creating pod: %v", err)
+			// The remaining creations will never be observed
+			for range pods[i:] {
+				r.expectations.CreationObserved(key)
+			}
+			return err
+		}
+		created = append(created, pod.Name)
+	}
+	return nil
+}
+
+// createPod creates the pod, retrying with a new generated name when the
+// API server picked one that is already taken. Pods with a fixed name are
+// not retried.
+func (r *CustomDeploymentReconciler) createPod(ctx context.Context, pod *corev1.Pod) error {
+	logger := log.FromContext(ctx)
+	for attempt := 1; ; attempt++ {
+		err := r.Create(ctx, pod)
+		if err == nil {
+			logger.Info("Created pod", "action", "create", "pod", pod.Name)
+			return nil
+		}
+		if !errors.IsAlreadyExists(err) || pod.GenerateName == "" || attempt == maxCreateAttempts {
+			return err
+		}
+		logger.V(logging.Debug).Info("Generated pod name already exists, retrying", "action", "create", "generateName", pod.GenerateName, "attempt", attempt)
+		pod.Name = ""
+		pod.ResourceVersion = ""
+	}
+}
+
+// deletePods deletes the given pods, ignoring the ones that are already gone.
+// Like creations, deletions are expected before they are issued, a single
+// event is recorded for all the pods deleted, and they are counted under the
+// given reason.
+func (r *CustomDeploymentReconciler) deletePods(ctx context.Context, cd *demov1alpha1.CustomDeployment, pods []*corev1.Pod, reason string) error {
+	logger := log.FromContext(ctx)
+	key := client.ObjectKeyFromObject(cd).String()
+	podKeys := make([]string, 0, len(pods))
+	for _, pod := range pods {
+		podKeys = append(podKeys, client.ObjectKeyFromObject(pod).String())
+	}
+	r.expectations.ExpectDeletions(key, podKeys)
+	var deleted []string
+	defer func() {
+		r.recordPods(cd, reasonSuccessfulDelete, "Deleted", deleted)
+		customDeploymentMetrics.PodsDeleted(cd.Namespace, cd.Name, reason, len(deleted))
+	}()
+	for i, pod := range pods {
+		logger.Info("Deleting pod", "action", "delete", "reason", reason, "pod", pod.Name)
+		if err := r.Delete(ctx, pod); err != nil {
+			// The pod may never produce a deletion event
+			r.expectations.DeletionObserved(key, podKeys[i])
+			if errors.IsNotFound(err) {
+				continue
+			}
+			logger.Error(err, "Failed to delete pod", "action", "delete", "reason", reason, "pod", pod.Name)
+			r.Recorder.Eventf(cd, corev1.EventTypeWarning, reasonFailedDelete, "Error deleting pod %s: %v", pod.Name, err)
+			for _, podKey := range podKeys[i+1:] {
+				r.expectations.DeletionObserved(key, podKey)
+			}
+			return err
+		}
+		deleted = append(deleted, pod.Name)
+	}
+	return nil
+}
+
+// recordPods records a single event naming the pods created or deleted, if
+// any.
+func (r *CustomDeploymentReconciler) recordPods(cd *demov1alpha1.CustomDeployment, reason, verb string, names []string) {
+	if len(names) == 0 {
+		return
+	}
+	noun := "pods"
+	if len(names) == 1 {
+		noun = "pod"
+	}
+	r.Recorder.Eventf(cd, corev1.EventTypeNormal, reason, "%s %d %s: %s", verb, len(names), noun, strings.Join(names, ", "))
 }
 
-func (r *CustomDeploymentReconciler) getPodForCustomDeployment(cd *demov1alpha1.CustomDeployment) *corev1.Pod {
-	ls := labelsForCustomDeployment(cd.Name)
+// getPodForCustomDeployment renders a pod from the CustomDeployment template
+// and labels it with the template hash. When the template has no containers,
+// a single container running Spec.Image is used instead.
+func (r *CustomDeploymentReconciler) getPodForCustomDeployment(cd *demov1alpha1.CustomDeployment, templateHash string) *corev1.Pod {
+	template := cd.Spec.PodTemplate()
+
+	ls := make(map[string]string, len(template.Labels))
+	for k, v := range template.Labels {
+		ls[k] = v
+	}
+	for k, v := range labelsForCustomDeployment(cd.Name) {
+		ls[k] = v
+	}
+	ls[podTemplateHashLabel] = templateHash
 
 	pod := &corev1.Pod{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      getRandomPodName(cd.Name),
-			Namespace: cd.Namespace,
-			Labels:    ls,
-		},
-		Spec: corev1.PodSpec{
-			Containers: []corev1.Container{{
-				Image:   cd.Spec.Image,
-				Name:    getRandomPodName(cd.Name),
-				Command: []string{"sleep", "3600"},
-			}},
+			GenerateName: podNamePrefix(cd.Name, templateHash),
+			Namespace:    cd.Namespace,
+			Labels:       ls,
+			Annotations:  template.Annotations,
 		},
+		Spec: template.Spec,
+	}
+	if len(pod.Spec.Containers) == 0 {
+		pod.Spec.Containers = []corev1.Container{{
+			Image: cd.Spec.Image,
+			Name:  defaultContainerName,
+		}}
 	}
 
 	// Set CustomDeployment instance as the owner and controller
@@ -131,10 +386,20 @@ func (r *CustomDeploymentReconciler) getPodForCustomDeployment(cd *demov1alpha1.
 	return pod
 }
 
-// podName returns randomized name for pods of a given customdeployment CR name.
-func getRandomPodName(name string) string {
-	randomizedName := name + "-" + randomStringGenerator(5) + "-" + randomStringGenerator(5)
-	return randomizedName
+// getPodsForCustomDeployment renders n pods from the CustomDeployment template.
+func (r *CustomDeploymentReconciler) getPodsForCustomDeployment(cd *demov1alpha1.CustomDeployment, templateHash string, n int) []*corev1.Pod {
+	pods := make([]*corev1.Pod, 0, n)
+	for i := 0; i < n; i++ {
+		pods = append(pods, r.getPodForCustomDeployment(cd, templateHash))
+	}
+	return pods
+}
+
+// podNamePrefix returns the GenerateName of the pods of a given
+// customdeployment CR name and template hash. The API server appends a
+// random suffix, so pods created together never share a name.
+func podNamePrefix(name, templateHash string) string {
+	return name + "-" + templateHash + "-"
 }
 
 // labelsForCustomDeployment returns the labels for selecting the resources
@@ -143,6 +408,16 @@ func labelsForCustomDeployment(name string) map[string]string {
 	return map[string]string{"app": "customdeployment", "customdeployment_cr": name}
 }
 
+// selectorForCustomDeployment returns the selector for the pods of the given
+// CustomDeployment, falling back to labelsForCustomDeployment when the spec
+// does not set one.
+func selectorForCustomDeployment(cd *demov1alpha1.CustomDeployment) (labels.Selector, error) {
+	if cd.Spec.Selector == nil {
+		return labels.SelectorFromSet(labelsForCustomDeployment(cd.Name)), nil
+	}
+	return metav1.LabelSelectorAsSelector(cd.Spec.Selector)
+}
+
 // getPodNames returns the pod names of the array of pods passed in
 func getPodNames(pods []corev1.Pod) []string {
 	var podNames []string
@@ -152,38 +427,103 @@ func getPodNames(pods []corev1.Pod) []string {
 	return podNames
 }
 
-// randomStringGenerator generates a string of fixed length
-func randomStringGenerator(n int) string {
-	const letterBytes = "abcdefghijklmnopqrstuvwxyz"
-	const (
-		letterIdxBits = 6                    // 6 bits to represent a letter index
-		letterIdxMask = 1<<letterIdxBits - 1 // All 1-bits, as many as letterIdxBits
-		letterIdxMax  = 63 / letterIdxBits   // # of letter indices fitting in 63 bits
-	)
-
-	var src = rand.NewSource(time.Now().UnixNano())
-
-	sb := strings.Builder{}
-	sb.Grow(n)
-	// A src.Int63() generates 63 random bits, enough for letterIdxMax characters!
-	for i, cache, remain := n-1, src.Int63(), letterIdxMax; i >= 0; {
-		if remain == 0 {
-			cache, remain = src.Int63(), letterIdxMax
+// podChanged filters out pod updates that cannot affect the CustomDeployment,
+// so that we react to phase and readiness transitions, deletions and label
+// changes without reconciling on every status heartbeat.
+var podChanged = predicate.Funcs{
+	UpdateFunc: func(e event.UpdateEvent) bool {
+		oldPod, ok := e.ObjectOld.(*corev1.Pod)
+		if !ok {
+			return true
 		}
-		if idx := int(cache & letterIdxMask); idx < len(letterBytes) {
-			sb.WriteByte(letterBytes[idx])
-			i--
+		newPod, ok := e.ObjectNew.(*corev1.Pod)
+		if !ok {
+			return true
 		}
-		cache >>= letterIdxBits
-		remain--
+		return oldPod.Status.Phase != newPod.Status.Phase ||
+			isPodReady(oldPod) != isPodReady(newPod) ||
+			!oldPod.DeletionTimestamp.Equal(newPod.DeletionTimestamp) ||
+			!reflect.DeepEqual(oldPod.Labels, newPod.Labels) ||
+			!reflect.DeepEqual(oldPod.OwnerReferences, newPod.OwnerReferences)
+	},
+}
+
+// podEventHandler enqueues the CustomDeployment controlling a pod, like
+// handler.EnqueueRequestForOwner, and records the observed pod creations and
+// deletions in the expectations.
+func (r *CustomDeploymentReconciler) podEventHandler() handler.EventHandler {
+	return handler.Funcs{
+		CreateFunc: func(e event.CreateEvent, q workqueue.RateLimitingInterface) {
+			key, ok := controllerKeyOf(e.Object)
+			if !ok {
+				return
+			}
+			if e.Object.GetDeletionTimestamp() != nil {
+				// A pod that was already terminating when the cache was
+				// (re)synced counts as deleted.
+				r.expectations.DeletionObserved(key.String(), client.ObjectKeyFromObject(e.Object).String())
+			} else {
+				r.expectations.CreationObserved(key.String())
+			}
+			q.Add(reconcile.Request{NamespacedName: key})
+		},
+		UpdateFunc: func(e event.UpdateEvent, q workqueue.RateLimitingInterface) {
+			if !podChanged.Update(e) {
+				return
+			}
+			oldKey, oldOK := controllerKeyOf(e.ObjectOld)
+			key, ok := controllerKeyOf(e.ObjectNew)
+			if oldOK && (!ok || oldKey != key) {
+				q.Add(reconcile.Request{NamespacedName: oldKey})
+			}
+			if !ok {
+				return
+			}
+			if e.ObjectOld.GetDeletionTimestamp() == nil && e.ObjectNew.GetDeletionTimestamp() != nil {
+				// Graceful deletion starts with an update, the pod stops
+				// counting as active as soon as it is terminating.
+				r.expectations.DeletionObserved(key.String(), client.ObjectKeyFromObject(e.ObjectNew).String())
+			}
+			q.Add(reconcile.Request{NamespacedName: key})
+		},
+		DeleteFunc: func(e event.DeleteEvent, q workqueue.RateLimitingInterface) {
+			key, ok := controllerKeyOf(e.Object)
+			if !ok {
+				return
+			}
+			r.expectations.DeletionObserved(key.String(), client.ObjectKeyFromObject(e.Object).String())
+			q.Add(reconcile.Request{NamespacedName: key})
+		},
+		GenericFunc: func(e event.GenericEvent, q workqueue.RateLimitingInterface) {
+			if key, ok := controllerKeyOf(e.Object); ok {
+				q.Add(reconcile.Request{NamespacedName: key})
+			}
+		},
 	}
+}
 
-	return sb.String()
+// controllerKeyOf returns the key of the CustomDeployment controlling obj.
+func controllerKeyOf(obj client.Object) (types.NamespacedName, bool) {
+	ref := metav1.GetControllerOf(obj)
+	if ref == nil || ref.Kind != "CustomDeployment" {
+		return types.NamespacedName{}, false
+	}
+	gv, err := schema.ParseGroupVersion(ref.APIVersion)
+	if err != nil || gv.Group != demov1alpha1.GroupVersion.Group {
+		return types.NamespacedName{}, false
+	}
+	return types.NamespacedName{Namespace: obj.GetNamespace(), Name: ref.Name}, true
 }
 
 // SetupWithManager sets up the controller with the Manager.
 func (r *CustomDeploymentReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	if r.expectations == nil {
+		r.expectations = newExpectations()
+	}
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&demov1alpha1.CustomDeployment{}).
-		Complete(r)
+		Watches(&source.Kind{Type: &corev1.Pod{}}, r.podEventHandler()).
+		Watches(&source.Kind{Type: &demov1alpha1.ImagePolicy{}}, handler.EnqueueRequestsFromMapFunc(r.enqueueForImagePolicy)).
+		WithOptions(controller.Options{MaxConcurrentReconciles: r.MaxConcurrentReconciles}).
+		Complete(tracing.Reconciler{Reconciler: logging.Reconciler{Reconciler: r}, Kind: "CustomDeployment"})
 }
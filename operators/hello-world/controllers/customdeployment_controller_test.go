@@ -0,0 +1,147 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	demov1alpha1 "github.com/mbtamuli/hello-world/api/v1alpha1"
+	"github.com/mbtamuli/k8s/common/metrics"
+)
+
+// collidingClient fails the first creations with AlreadyExists, as the API
+// server does when a generated name is already taken.
+type collidingClient struct {
+	client.Client
+	collisions int
+}
+
+func (c *collidingClient) Create(ctx context.Context, obj client.Object, opts ...client.CreateOption) error {
+	if c.collisions > 0 {
+		c.collisions--
+		return errors.NewAlreadyExists(schema.GroupResource{Resource: "pods"}, obj.GetGenerateName()+"abcde")
+	}
+	return c.Client.Create(ctx, obj, opts...)
+}
+
+func TestCreatePods(t *testing.T) {
+	ctx := context.Background()
+	cd := newTestCustomDeployment(2, "nginx:1.21")
+	r := newTestReconciler(cd)
+	r.Client = &collidingClient{Client: r.Client, collisions: 1}
+	hash := computeTemplateHash(cd)
+	selector, _ := selectorForCustomDeployment(cd)
+
+	if err := r.createPods(ctx, cd, selector, r.getPodsForCustomDeployment(cd, hash, 2), metrics.ReasonScaleUp); err != nil {
+		t.Fatalf("createPods() error = %v", err)
+	}
+
+	pods := listTestPods(t, r, cd)
+	if len(pods) != 2 {
+		t.Fatalf("expected 2 pods, got %d", len(pods))
+	}
+	if pods[0].Name == pods[1].Name {
+		t.Errorf("expected distinct pod names, got %s twice", pods[0].Name)
+	}
+	for _, pod := range pods {
+		if !strings.HasPrefix(pod.Name, "web-"+hash+"-") {
+			t.Errorf("pod name %s does not start with the template hash", pod.Name)
+		}
+		if c := pod.Spec.Containers; len(c) != 1 || c[0].Name != defaultContainerName {
+			t.Errorf("expected a single %q container, got %v", defaultContainerName, c)
+		}
+	}
+
+	// Collisions beyond the retry budget are reported
+	r.expectations = newExpectations()
+	r.Client = &collidingClient{Client: r.Client, collisions: maxCreateAttempts}
+	if err := r.createPods(ctx, cd, selector, r.getPodsForCustomDeployment(cd, hash, 1), metrics.ReasonScaleUp); !errors.IsAlreadyExists(err) {
+		t.Errorf("createPods() error = %v, want AlreadyExists", err)
+	}
+	if !r.expectations.SatisfiedExpectations(client.ObjectKeyFromObject(cd).String()) {
+		t.Error("expected the failed creation to be dropped from the expectations")
+	}
+}
+
+var _ = Describe("CustomDeployment controller", func() {
+	const (
+		namespace = "default"
+		timeout   = 10 * time.Second
+		interval  = 250 * time.Millisecond
+	)
+
+	// controlledPods returns the number of active pods controlled by the
+	// CustomDeployment
+	controlledPods := func(cd *demov1alpha1.CustomDeployment) func() int {
+		return func() int {
+			podList := &corev1.PodList{}
+			Expect(k8sClient.List(ctx, podList, client.InNamespace(namespace))).To(Succeed())
+			return len(filterActivePods(filterControlledPods(podList.Items, cd)))
+		}
+	}
+
+	It("follows the replicas set through the scale subresource", func() {
+		cd := &demov1alpha1.CustomDeployment{
+			ObjectMeta: metav1.ObjectMeta{Name: "scale-subresource", Namespace: namespace},
+			Spec:       demov1alpha1.CustomDeploymentSpec{Replicas: 1, Image: "nginx:1.21"},
+		}
+		Expect(k8sClient.Create(ctx, cd)).To(Succeed())
+		Eventually(controlledPods(cd), timeout, interval).Should(Equal(1))
+
+		scales := dynamic.NewForConfigOrDie(testEnv.Config).
+			Resource(demov1alpha1.GroupVersion.WithResource("customdeployments")).
+			Namespace(namespace)
+		scaleTo := func(replicas int64) {
+			scale, err := scales.Get(ctx, cd.Name, metav1.GetOptions{}, "scale")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(unstructured.SetNestedField(scale.Object, replicas, "spec", "replicas")).To(Succeed())
+			_, err = scales.Update(ctx, scale, metav1.UpdateOptions{}, "scale")
+			Expect(err).NotTo(HaveOccurred())
+		}
+		scaleStatus := func() map[string]interface{} {
+			scale, err := scales.Get(ctx, cd.Name, metav1.GetOptions{}, "scale")
+			Expect(err).NotTo(HaveOccurred())
+			status, _, err := unstructured.NestedMap(scale.Object, "status")
+			Expect(err).NotTo(HaveOccurred())
+			return status
+		}
+
+		scaleTo(3)
+		Eventually(controlledPods(cd), timeout, interval).Should(Equal(3))
+		Eventually(scaleStatus, timeout, interval).Should(And(
+			HaveKeyWithValue("replicas", BeNumerically("==", 3)),
+			HaveKeyWithValue("selector", "app=customdeployment,customdeployment_cr=scale-subresource"),
+		))
+
+		scaleTo(0)
+		Eventually(controlledPods(cd), timeout, interval).Should(Equal(0))
+		Consistently(controlledPods(cd), time.Second, interval).Should(Equal(0))
+	})
+})
@@ -0,0 +1,134 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	demov1alpha1 "github.com/mbtamuli/hello-world/api/v1alpha1"
+	"github.com/mbtamuli/k8s/common/imagepolicy"
+)
+
+// reconcileTestEvents reconciles the CustomDeployment once, as if every
+// earlier change had been observed, and returns the events recorded
+// meanwhile.
+func reconcileTestEvents(t *testing.T, r *CustomDeploymentReconciler, cd *demov1alpha1.CustomDeployment) []string {
+	t.Helper()
+	key := client.ObjectKeyFromObject(cd)
+	r.expectations.DeleteExpectations(key.String())
+	// Errors are reported through the events under test
+	_, _ = r.Reconcile(context.Background(), ctrl.Request{NamespacedName: key})
+	if err := r.Get(context.Background(), key, cd); err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	return drainTestEvents(r.Recorder.(*record.FakeRecorder))
+}
+
+func expectTestEvents(t *testing.T, got []string, want ...string) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("got events %q, want %q", got, want)
+	}
+	for i := range want {
+		if !strings.HasPrefix(got[i], want[i]) {
+			t.Errorf("event %d = %q, want it to start with %q", i, got[i], want[i])
+		}
+	}
+}
+
+func updateTestCustomDeployment(t *testing.T, r *CustomDeploymentReconciler, cd *demov1alpha1.CustomDeployment, mutate func()) {
+	t.Helper()
+	mutate()
+	if err := r.Update(context.Background(), cd); err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+}
+
+func TestReconcileRecordsEvents(t *testing.T) {
+	cd := newTestCustomDeployment(2, "nginx:1.20")
+	r := newTestReconciler(cd)
+
+	expectTestEvents(t, reconcileTestEvents(t, r, cd),
+		"Normal Scaled Scaling up from 0 to 2 replicas",
+		"Normal SuccessfulCreate Created 2 pods: web-")
+	markTestPodsReady(t, r, listTestPods(t, r, cd))
+	expectTestEvents(t, reconcileTestEvents(t, r, cd))
+
+	// A rollout is announced once it starts and once it is over
+	updateTestCustomDeployment(t, r, cd, func() { cd.Spec.Image = "nginx:1.21" })
+	expectTestEvents(t, reconcileTestEvents(t, r, cd),
+		"Normal RollingOut Rolling out revision 2",
+		"Normal SuccessfulCreate Created 1 pod: web-")
+	var events []string
+	for i := 0; len(events) == 0 || events[len(events)-1] != "Normal RolloutComplete Rolled out revision 2"; i++ {
+		if i == 10 {
+			t.Fatalf("got events %q, want the rollout to complete", events)
+		}
+		markTestPodsReady(t, r, listTestPods(t, r, cd))
+		events = append(events, reconcileTestEvents(t, r, cd)...)
+	}
+	for _, event := range events {
+		if strings.HasPrefix(event, "Warning") {
+			t.Errorf("unexpected warning %q", event)
+		}
+	}
+
+	updateTestCustomDeployment(t, r, cd, func() { cd.Spec.RollbackTo = &demov1alpha1.RollbackConfig{Revision: 1} })
+	expectTestEvents(t, reconcileTestEvents(t, r, cd), "Normal RolledBack Rolled back to revision 1")
+	if cd.Spec.Image != "nginx:1.20" {
+		t.Errorf("Image = %q after the rollback, want nginx:1.20", cd.Spec.Image)
+	}
+
+	updateTestCustomDeployment(t, r, cd, func() { cd.Spec.RollbackTo = &demov1alpha1.RollbackConfig{Revision: 42} })
+	expectTestEvents(t, reconcileTestEvents(t, r, cd), "Warning RollbackRevisionNotFound Unable to find revision 42 to roll back to")
+}
+
+func TestReconcileRecordsValidationFailures(t *testing.T) {
+	t.Run("invalid selector", func(t *testing.T) {
+		cd := newTestCustomDeployment(1, "nginx:1.21")
+		cd.Spec.Selector = &metav1.LabelSelector{
+			MatchExpressions: []metav1.LabelSelectorRequirement{{Key: "app", Operator: "Bogus"}},
+		}
+		expectTestEvents(t, reconcileTestEvents(t, newTestReconciler(cd), cd),
+			"Warning InvalidSelector Invalid selector: ")
+	})
+
+	t.Run("selector not matching the template", func(t *testing.T) {
+		cd := newTestCustomDeployment(2, "nginx:1.21")
+		cd.Spec.Selector = &metav1.LabelSelector{MatchLabels: map[string]string{"app": "other"}}
+		expectTestEvents(t, reconcileTestEvents(t, newTestReconciler(cd), cd),
+			"Normal Scaled Scaling up from 0 to 2 replicas",
+			`Warning FailedCreate Error creating pod: selector "app=other" does not match template labels`)
+	})
+
+	t.Run("image policy violation", func(t *testing.T) {
+		cd := newTestCustomDeployment(0, "nginx:1.21")
+		r := newTestReconciler(cd)
+		r.ImagePolicy = imagepolicy.StaticSource{Policy: &imagepolicy.Policy{AllowedRegistries: []string{"ghcr.io"}}}
+		expectTestEvents(t, reconcileTestEvents(t, r, cd), "Warning ImageForbidden ")
+
+		// The violation is only reported once
+		expectTestEvents(t, reconcileTestEvents(t, r, cd))
+	})
+}
@@ -0,0 +1,169 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	demov1alpha1 "github.com/mbtamuli/hello-world/api/v1alpha1"
+	"github.com/mbtamuli/k8s/common/logging"
+	"github.com/mbtamuli/k8s/common/metrics"
+)
+
+// cleanupFinalizer keeps a deleted CustomDeployment around until its pods
+// have been drained or orphaned according to Spec.DeletionPolicy.
+const cleanupFinalizer = "demo.mriyam.dev/cleanup"
+
+// Reasons used for the events emitted while cleaning up.
+const (
+	reasonDrainingPods     = "DrainingPods"
+	reasonOrphanedPod      = "OrphanedPod"
+	reasonCleanupFailed    = "CleanupFailed"
+	reasonCleanupSucceeded = "CleanupSucceeded"
+)
+
+// ensureFinalizer adds the cleanup finalizer to the CustomDeployment if it
+// is missing.
+func (r *CustomDeploymentReconciler) ensureFinalizer(ctx context.Context, cd *demov1alpha1.CustomDeployment) error {
+	if controllerutil.ContainsFinalizer(cd, cleanupFinalizer) {
+		return nil
+	}
+	controllerutil.AddFinalizer(cd, cleanupFinalizer)
+	if err := r.Update(ctx, cd); err != nil {
+		log.FromContext(ctx).Error(err, "Failed to add finalizer")
+		return err
+	}
+	return nil
+}
+
+// finalize runs the cleanup phase of a deleted CustomDeployment. With the
+// Orphan policy the pods are released at once. Otherwise they are drained:
+// all at once for Parallel, one at a time from the highest ordinal down for
+// OrderedReady. The finalizer is removed once no controlled pod is left,
+// each step being triggered by the pod events of the previous one.
+func (r *CustomDeploymentReconciler) finalize(ctx context.Context, cd *demov1alpha1.CustomDeployment) error {
+	if !controllerutil.ContainsFinalizer(cd, cleanupFinalizer) {
+		return nil
+	}
+	logger := log.FromContext(ctx)
+	key := client.ObjectKeyFromObject(cd).String()
+	if !r.expectations.SatisfiedExpectations(key) {
+		logger.V(logging.Debug).Info("Waiting for pending pod creations and deletions to be observed")
+		return nil
+	}
+
+	// The selector may have changed since the pods were created, so look
+	// for every pod we control instead
+	podList := &corev1.PodList{}
+	if err := r.List(ctx, podList, client.InNamespace(cd.Namespace)); err != nil {
+		logger.Error(err, "Failed to list pods")
+		return err
+	}
+	pods := filterControlledPods(podList.Items, cd)
+
+	var err error
+	if cd.Spec.DeletionPolicy == demov1alpha1.OrphanDeletionPolicy {
+		err = r.orphanPods(ctx, cd, pods)
+		pods = nil
+	} else {
+		pods, err = r.drainPods(ctx, cd, pods)
+	}
+	if err != nil {
+		r.Recorder.Eventf(cd, corev1.EventTypeWarning, reasonCleanupFailed, "Failed to clean up pods: %v", err)
+		return err
+	}
+	if len(pods) > 0 {
+		return nil
+	}
+
+	controllerutil.RemoveFinalizer(cd, cleanupFinalizer)
+	if err := r.Update(ctx, cd); err != nil {
+		logger.Error(err, "Failed to remove finalizer")
+		return err
+	}
+	r.Recorder.Event(cd, corev1.EventTypeNormal, reasonCleanupSucceeded, "All pods were cleaned up")
+	r.expectations.DeleteExpectations(key)
+	return nil
+}
+
+// drainPods deletes the next batch of pods and returns the pods that are
+// still around.
+func (r *CustomDeploymentReconciler) drainPods(ctx context.Context, cd *demov1alpha1.CustomDeployment, pods []corev1.Pod) ([]corev1.Pod, error) {
+	logger := log.FromContext(ctx)
+	active := filterActivePods(pods)
+	if len(active) == 0 {
+		if len(pods) > 0 {
+			logger.V(logging.Debug).Info("Waiting for pods to terminate", "pods", getPodNames(pods))
+		}
+		return pods, nil
+	}
+
+	if cd.Spec.PodManagementPolicy == demov1alpha1.OrderedReadyPodManagement {
+		if len(active) < len(pods) {
+			logger.V(logging.Debug).Info("Waiting for pod to terminate before draining the next one")
+			return pods, nil
+		}
+		// Pods without an ordinal sort last and go first
+		sortPodsByOrdinal(cd, active)
+		active = active[len(active)-1:]
+	} else {
+		active = sortPodsForDeletion(active)
+	}
+
+	names := make([]string, 0, len(active))
+	for _, pod := range active {
+		names = append(names, pod.Name)
+	}
+	r.recordPods(cd, reasonDrainingPods, "Draining", names)
+	return pods, r.deletePods(ctx, cd, active, metrics.ReasonCleanup)
+}
+
+// orphanPods removes the owner reference to the CustomDeployment from the
+// pods, so the garbage collector leaves them alone.
+func (r *CustomDeploymentReconciler) orphanPods(ctx context.Context, cd *demov1alpha1.CustomDeployment, pods []corev1.Pod) error {
+	logger := log.FromContext(ctx)
+	var orphaned []string
+	defer func() { r.recordPods(cd, reasonOrphanedPod, "Orphaned", orphaned) }()
+	for i := range pods {
+		pod := &pods[i]
+		patch := client.MergeFrom(pod.DeepCopy())
+		var refs []metav1.OwnerReference
+		for _, ref := range pod.OwnerReferences {
+			if ref.UID != cd.UID {
+				refs = append(refs, ref)
+			}
+		}
+		pod.OwnerReferences = refs
+		logger.Info("Orphaning pod", "action", "orphan", "pod", pod.Name)
+		if err := r.Patch(ctx, pod, patch); err != nil {
+			if errors.IsNotFound(err) {
+				continue
+			}
+			logger.Error(err, "Failed to orphan pod", "action", "orphan", "pod", pod.Name)
+			return err
+		}
+		orphaned = append(orphaned, pod.Name)
+	}
+	return nil
+}
@@ -0,0 +1,153 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	demov1alpha1 "github.com/mbtamuli/hello-world/api/v1alpha1"
+)
+
+// deleteTestCustomDeployment marks the CustomDeployment as deleted, like the
+// API server does for objects with finalizers.
+func deleteTestCustomDeployment(t *testing.T, r *CustomDeploymentReconciler, cd *demov1alpha1.CustomDeployment) {
+	t.Helper()
+	now := metav1.Now()
+	cd.DeletionTimestamp = &now
+	controllerutil.AddFinalizer(cd, cleanupFinalizer)
+	if err := r.Update(context.Background(), cd); err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+}
+
+// finalizeTestCustomDeployment runs finalize once, after forgetting the
+// expectations as if the informer had observed every change.
+func finalizeTestCustomDeployment(t *testing.T, r *CustomDeploymentReconciler, cd *demov1alpha1.CustomDeployment) {
+	t.Helper()
+	if err := r.Get(context.Background(), client.ObjectKeyFromObject(cd), cd); err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	r.expectations.DeleteExpectations(client.ObjectKeyFromObject(cd).String())
+	if err := r.finalize(context.Background(), cd); err != nil {
+		t.Fatalf("finalize() error = %v", err)
+	}
+}
+
+func drainTestEvents(recorder *record.FakeRecorder) []string {
+	var events []string
+	for {
+		select {
+		case event := <-recorder.Events:
+			events = append(events, event)
+		default:
+			return events
+		}
+	}
+}
+
+func TestFinalizeDelete(t *testing.T) {
+	cd := newTestCustomDeployment(3, "nginx:1.20")
+	r := newTestReconciler(cd)
+	syncTestPods(t, r, cd)
+	deleteTestCustomDeployment(t, r, cd)
+	recorder := r.Recorder.(*record.FakeRecorder)
+	drainTestEvents(recorder)
+
+	finalizeTestCustomDeployment(t, r, cd)
+	if pods := listTestPods(t, r, cd); len(pods) != 0 {
+		t.Fatalf("expected all pods to be drained at once, got %d pods", len(pods))
+	}
+	if !controllerutil.ContainsFinalizer(cd, cleanupFinalizer) {
+		t.Fatal("finalizer removed before the pod deletions were observed")
+	}
+	finalizeTestCustomDeployment(t, r, cd)
+	if controllerutil.ContainsFinalizer(cd, cleanupFinalizer) {
+		t.Fatal("finalizer not removed once the pods are gone")
+	}
+
+	events := drainTestEvents(recorder)
+	if len(events) != 3 || !strings.Contains(events[0], "Draining 3 pods") || !strings.Contains(events[2], reasonCleanupSucceeded) {
+		t.Errorf("unexpected events %v", events)
+	}
+}
+
+func TestFinalizeOrderedReady(t *testing.T) {
+	cd := newTestCustomDeployment(3, "nginx:1.20")
+	cd.Spec.PodManagementPolicy = demov1alpha1.OrderedReadyPodManagement
+	r := newTestReconciler(cd)
+	for i := 0; i < 3; i++ {
+		markTestPodsReady(t, r, syncTestPods(t, r, cd))
+	}
+	deleteTestCustomDeployment(t, r, cd)
+
+	for _, want := range [][]string{
+		{"web-0", "web-1"},
+		{"web-0"},
+		nil,
+	} {
+		finalizeTestCustomDeployment(t, r, cd)
+		if got := testPodNames(listTestPods(t, r, cd)); !reflect.DeepEqual(got, want) {
+			t.Fatalf("pods = %v, want %v", got, want)
+		}
+	}
+	finalizeTestCustomDeployment(t, r, cd)
+	if controllerutil.ContainsFinalizer(cd, cleanupFinalizer) {
+		t.Fatal("finalizer not removed once the pods are gone")
+	}
+}
+
+func TestFinalizeOrphan(t *testing.T) {
+	cd := newTestCustomDeployment(2, "nginx:1.20")
+	cd.Spec.DeletionPolicy = demov1alpha1.OrphanDeletionPolicy
+	r := newTestReconciler(cd)
+	syncTestPods(t, r, cd)
+	deleteTestCustomDeployment(t, r, cd)
+	recorder := r.Recorder.(*record.FakeRecorder)
+	drainTestEvents(recorder)
+
+	finalizeTestCustomDeployment(t, r, cd)
+	if controllerutil.ContainsFinalizer(cd, cleanupFinalizer) {
+		t.Fatal("finalizer not removed after orphaning the pods")
+	}
+	if pods := listTestPods(t, r, cd); len(pods) != 0 {
+		t.Fatalf("expected no controlled pods, got %d", len(pods))
+	}
+	podList := &corev1.PodList{}
+	if err := r.List(context.Background(), podList); err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	if len(podList.Items) != 2 {
+		t.Fatalf("expected the 2 orphaned pods to survive, got %d", len(podList.Items))
+	}
+	for _, pod := range podList.Items {
+		if len(pod.OwnerReferences) != 0 {
+			t.Errorf("pod %s still has owner references %v", pod.Name, pod.OwnerReferences)
+		}
+	}
+	if events := drainTestEvents(recorder); len(events) != 2 || !strings.Contains(events[0], "Orphaned 2 pods") {
+		t.Errorf("unexpected events %v", events)
+	}
+}
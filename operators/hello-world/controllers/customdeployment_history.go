@@ -0,0 +1,257 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apiequality "k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	demov1alpha1 "github.com/mbtamuli/hello-world/api/v1alpha1"
+)
+
+// defaultRevisionHistoryLimit is used when Spec.RevisionHistoryLimit is not set.
+const defaultRevisionHistoryLimit = 10
+
+// revisionData is what a ControllerRevision of a CustomDeployment stores. It
+// is shaped as a patch of the CustomDeployment so tooling can apply it as is.
+type revisionData struct {
+	Spec revisionSpec `json:"spec"`
+}
+
+type revisionSpec struct {
+	Image    string                 `json:"image,omitempty"`
+	Template corev1.PodTemplateSpec `json:"template"`
+}
+
+// syncRevisions makes sure a ControllerRevision exists for the current
+// template and that it has the highest revision number, then prunes old
+// revisions beyond the history limit. It returns the current revision number.
+func (r *CustomDeploymentReconciler) syncRevisions(ctx context.Context, cd *demov1alpha1.CustomDeployment, templateHash string) (int64, error) {
+	logger := log.FromContext(ctx)
+	revisions, err := r.listRevisions(ctx, cd)
+	if err != nil {
+		return 0, err
+	}
+
+	var current *appsv1.ControllerRevision
+	var maxRevision int64
+	for _, rev := range revisions {
+		if rev.Labels[podTemplateHashLabel] == templateHash {
+			current = rev
+		}
+		if rev.Revision > maxRevision {
+			maxRevision = rev.Revision
+		}
+	}
+
+	switch {
+	case current == nil:
+		current, err = r.newRevision(cd, templateHash, maxRevision+1)
+		if err != nil {
+			return 0, err
+		}
+		logger.Info("Creating ControllerRevision", "action", "create", "controllerRevision", current.Name, "revision", current.Revision)
+		err = r.Create(ctx, current)
+		if errors.IsAlreadyExists(err) {
+			err = r.replaceRevision(ctx, cd, current)
+		}
+		if err != nil {
+			logger.Error(err, "Failed to create ControllerRevision", "action", "create", "controllerRevision", current.Name)
+			return 0, err
+		}
+		revisions = append(revisions, current)
+		if maxRevision > 0 {
+			r.Recorder.Eventf(cd, corev1.EventTypeNormal, reasonRollingOut, "Rolling out revision %d", current.Revision)
+			rolloutStarted(cd.Namespace, cd.Name, time.Now())
+		}
+	case current.Revision < maxRevision:
+		// An older template is back, e.g. after a rollback. Like for
+		// Deployments it becomes the latest revision again.
+		current.Revision = maxRevision + 1
+		logger.Info("Updating ControllerRevision", "action", "update", "controllerRevision", current.Name, "revision", current.Revision)
+		if err := r.Update(ctx, current); err != nil {
+			logger.Error(err, "Failed to update ControllerRevision", "action", "update", "controllerRevision", current.Name)
+			return 0, err
+		}
+		r.Recorder.Eventf(cd, corev1.EventTypeNormal, reasonRollingOut, "Rolling out revision %d", current.Revision)
+		rolloutStarted(cd.Namespace, cd.Name, time.Now())
+	}
+
+	limit := defaultRevisionHistoryLimit
+	if cd.Spec.RevisionHistoryLimit != nil {
+		limit = int(*cd.Spec.RevisionHistoryLimit)
+	}
+	var old []*appsv1.ControllerRevision
+	for _, rev := range revisions {
+		if rev != current {
+			old = append(old, rev)
+		}
+	}
+	sort.Slice(old, func(i, j int) bool { return old[i].Revision < old[j].Revision })
+	for i := 0; i < len(old)-limit; i++ {
+		logger.Info("Pruning ControllerRevision", "action", "delete", "controllerRevision", old[i].Name, "revision", old[i].Revision)
+		if err := r.Delete(ctx, old[i]); err != nil && !errors.IsNotFound(err) {
+			logger.Error(err, "Failed to delete ControllerRevision", "action", "delete", "controllerRevision", old[i].Name)
+			return 0, err
+		}
+	}
+
+	return current.Revision, nil
+}
+
+// rollback restores the template of the revision requested in
+// Spec.RollbackTo and clears the request. The rollout itself happens on the
+// next reconcile, once the updated spec is observed.
+func (r *CustomDeploymentReconciler) rollback(ctx context.Context, cd *demov1alpha1.CustomDeployment) error {
+	logger := log.FromContext(ctx)
+	revisions, err := r.listRevisions(ctx, cd)
+	if err != nil {
+		return err
+	}
+
+	target := findRollbackRevision(revisions, computeTemplateHash(cd), cd.Spec.RollbackTo.Revision)
+	if target == nil {
+		logger.Info("Unable to find the revision to roll back to, skipping the rollback", "action", "rollback", "revision", cd.Spec.RollbackTo.Revision)
+		r.Recorder.Eventf(cd, corev1.EventTypeWarning, reasonRollbackRevisionNotFound, "Unable to find revision %d to roll back to", cd.Spec.RollbackTo.Revision)
+	} else {
+		data := revisionData{}
+		if err := json.Unmarshal(target.Data.Raw, &data); err != nil {
+			return fmt.Errorf("decoding ControllerRevision %s: %w", target.Name, err)
+		}
+		logger.Info("Rolling back", "action", "rollback", "revision", target.Revision)
+		cd.Spec.Image = data.Spec.Image
+		cd.Spec.Template = nil
+		// Revisions of a CustomDeployment with only an Image store an empty
+		// template
+		if template := data.Spec.Template; !apiequality.Semantic.DeepEqual(template, corev1.PodTemplateSpec{}) {
+			cd.Spec.Template = &template
+		}
+	}
+
+	cd.Spec.RollbackTo = nil
+	if err := r.Update(ctx, cd); err != nil {
+		logger.Error(err, "Failed to update CustomDeployment for rollback", "action", "rollback")
+		return err
+	}
+	if target != nil {
+		r.Recorder.Eventf(cd, corev1.EventTypeNormal, reasonRolledBack, "Rolled back to revision %d", target.Revision)
+	}
+	return nil
+}
+
+// findRollbackRevision returns the revision with the given number, or the
+// latest revision not running the current template when revision is 0.
+func findRollbackRevision(revisions []*appsv1.ControllerRevision, templateHash string, revision int64) *appsv1.ControllerRevision {
+	var target *appsv1.ControllerRevision
+	for _, rev := range revisions {
+		switch {
+		case revision != 0:
+			if rev.Revision == revision {
+				return rev
+			}
+		case rev.Labels[podTemplateHashLabel] != templateHash:
+			if target == nil || rev.Revision > target.Revision {
+				target = rev
+			}
+		}
+	}
+	return target
+}
+
+// listRevisions returns the ControllerRevisions controlled by the CustomDeployment.
+func (r *CustomDeploymentReconciler) listRevisions(ctx context.Context, cd *demov1alpha1.CustomDeployment) ([]*appsv1.ControllerRevision, error) {
+	revisionList := &appsv1.ControllerRevisionList{}
+	opts := []client.ListOption{
+		client.InNamespace(cd.Namespace),
+		client.MatchingLabels(labelsForCustomDeployment(cd.Name)),
+	}
+	if err := r.List(ctx, revisionList, opts...); err != nil {
+		return nil, err
+	}
+
+	var revisions []*appsv1.ControllerRevision
+	for i := range revisionList.Items {
+		if metav1.IsControlledBy(&revisionList.Items[i], cd) {
+			revisions = append(revisions, &revisionList.Items[i])
+		}
+	}
+	return revisions, nil
+}
+
+// replaceRevision creates rev in place of the ControllerRevision of the same
+// name, which a deleted CustomDeployment of the same name left behind,
+// either for the garbage collector or orphaned. Revisions controlled by
+// anything else are left alone.
+func (r *CustomDeploymentReconciler) replaceRevision(ctx context.Context, cd *demov1alpha1.CustomDeployment, rev *appsv1.ControllerRevision) error {
+	existing := &appsv1.ControllerRevision{}
+	if err := r.Get(ctx, client.ObjectKeyFromObject(rev), existing); err != nil {
+		return err
+	}
+	owner := metav1.GetControllerOf(existing)
+	switch {
+	case owner == nil && !labels.SelectorFromSet(labelsForCustomDeployment(cd.Name)).Matches(labels.Set(existing.Labels)),
+		owner != nil && (owner.Kind != "CustomDeployment" || owner.Name != cd.Name):
+		return fmt.Errorf("ControllerRevision %s already exists and does not belong to CustomDeployment %s", rev.Name, cd.Name)
+	case owner != nil && owner.UID == cd.UID:
+		// Created by an earlier reconcile the cache does not show yet
+		return errors.NewAlreadyExists(appsv1.Resource("controllerrevisions"), rev.Name)
+	}
+
+	log.FromContext(ctx).Info("Replacing ControllerRevision of a deleted CustomDeployment", "action", "delete", "controllerRevision", existing.Name)
+	if err := r.Delete(ctx, existing, client.Preconditions{UID: &existing.UID}); err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	return r.Create(ctx, rev)
+}
+
+// newRevision returns a ControllerRevision snapshotting the current template.
+func (r *CustomDeploymentReconciler) newRevision(cd *demov1alpha1.CustomDeployment, templateHash string, revision int64) (*appsv1.ControllerRevision, error) {
+	data, err := json.Marshal(revisionData{Spec: revisionSpec{Image: cd.Spec.Image, Template: *cd.Spec.PodTemplate()}})
+	if err != nil {
+		return nil, err
+	}
+
+	ls := labelsForCustomDeployment(cd.Name)
+	ls[podTemplateHashLabel] = templateHash
+	rev := &appsv1.ControllerRevision{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      cd.Name + "-" + templateHash,
+			Namespace: cd.Namespace,
+			Labels:    ls,
+		},
+		Data:     runtime.RawExtension{Raw: data},
+		Revision: revision,
+	}
+	if err := ctrl.SetControllerReference(cd, rev, r.Scheme); err != nil {
+		return nil, err
+	}
+	return rev, nil
+}
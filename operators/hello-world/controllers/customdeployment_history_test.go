@@ -0,0 +1,128 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	demov1alpha1 "github.com/mbtamuli/hello-world/api/v1alpha1"
+)
+
+func TestRevisionHistory(t *testing.T) {
+	ctx := context.Background()
+	cd := newTestCustomDeployment(1, "nginx:1.19")
+	limit := int32(1)
+	cd.Spec.RevisionHistoryLimit = &limit
+	r := newTestReconciler(cd)
+
+	for i, image := range []string{"nginx:1.19", "nginx:1.20", "nginx:1.21"} {
+		cd.Spec.Image = image
+		revision, err := r.syncRevisions(ctx, cd, computeTemplateHash(cd))
+		if err != nil {
+			t.Fatalf("syncRevisions() error = %v", err)
+		}
+		if want := int64(i + 1); revision != want {
+			t.Errorf("syncRevisions() = %d, want %d", revision, want)
+		}
+	}
+
+	revisions, err := r.listRevisions(ctx, cd)
+	if err != nil {
+		t.Fatalf("listRevisions() error = %v", err)
+	}
+	if len(revisions) != 2 {
+		t.Fatalf("expected the current and 1 old revision, got %d", len(revisions))
+	}
+
+	// Roll back to the previous revision
+	cd.Spec.RollbackTo = &demov1alpha1.RollbackConfig{}
+	if err := r.Update(ctx, cd); err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+	if err := r.rollback(ctx, cd); err != nil {
+		t.Fatalf("rollback() error = %v", err)
+	}
+	got := &demov1alpha1.CustomDeployment{}
+	if err := r.Get(ctx, types.NamespacedName{Namespace: cd.Namespace, Name: cd.Name}, got); err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if got.Spec.Image != "nginx:1.20" || got.Spec.Template != nil || got.Spec.RollbackTo != nil {
+		t.Fatalf("expected image nginx:1.20, no template and no rollbackTo, got %q, %v and %v", got.Spec.Image, got.Spec.Template, got.Spec.RollbackTo)
+	}
+
+	// The restored template becomes the latest revision again
+	revision, err := r.syncRevisions(ctx, got, computeTemplateHash(got))
+	if err != nil {
+		t.Fatalf("syncRevisions() error = %v", err)
+	}
+	if revision != 4 {
+		t.Errorf("syncRevisions() = %d, want 4", revision)
+	}
+}
+
+func TestRevisionOfRecreatedCustomDeployment(t *testing.T) {
+	ctx := context.Background()
+	deleted := newTestCustomDeployment(1, "nginx:1.21")
+	deleted.UID = "deleted-uid"
+	r := newTestReconciler()
+	stale, err := r.newRevision(deleted, computeTemplateHash(deleted), 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Left for the garbage collector by the deleted CustomDeployment
+	if err := r.Create(ctx, stale); err != nil {
+		t.Fatal(err)
+	}
+
+	cd := newTestCustomDeployment(1, "nginx:1.21")
+	revision, err := r.syncRevisions(ctx, cd, computeTemplateHash(cd))
+	if err != nil {
+		t.Fatalf("syncRevisions() error = %v", err)
+	}
+	if revision != 1 {
+		t.Errorf("syncRevisions() = %d, want 1", revision)
+	}
+	got := &appsv1.ControllerRevision{}
+	if err := r.Get(ctx, client.ObjectKeyFromObject(stale), got); err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if !metav1.IsControlledBy(got, cd) {
+		t.Errorf("ControllerRevision is controlled by %v, want the recreated CustomDeployment", metav1.GetControllerOf(got))
+	}
+
+	// Revisions of anything else are not touched
+	other := newTestCustomDeployment(1, "nginx:1.22")
+	foreign, err := r.newRevision(other, computeTemplateHash(other), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	foreign.OwnerReferences = nil
+	foreign.Labels = nil
+	if err := r.Create(ctx, foreign); err != nil {
+		t.Fatal(err)
+	}
+	cd.Spec.Image = "nginx:1.22"
+	if _, err := r.syncRevisions(ctx, cd, computeTemplateHash(cd)); err == nil {
+		t.Error("syncRevisions() replaced a ControllerRevision that is not the CustomDeployment's")
+	}
+}
@@ -0,0 +1,96 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	demov1alpha1 "github.com/mbtamuli/hello-world/api/v1alpha1"
+)
+
+// reasonImageForbidden is the reason of the ImagePolicyViolation condition.
+const reasonImageForbidden = "ImageForbidden"
+
+// checkImagePolicy reports the images of the CustomDeployment violating the
+// image policy in the ImagePolicyViolation condition. The webhook keeps new
+// violations out, this catches the objects admitted before the policy was
+// tightened. A warning event is recorded when the violations change. The
+// condition is left alone when the policy cannot be read.
+func (r *CustomDeploymentReconciler) checkImagePolicy(ctx context.Context, cd *demov1alpha1.CustomDeployment, status *demov1alpha1.CustomDeploymentStatus) {
+	if r.ImagePolicy == nil {
+		return
+	}
+	policy, err := r.ImagePolicy.ImagePolicy(ctx)
+	if err != nil {
+		log.FromContext(ctx).Error(err, "Failed to read the image policy")
+		return
+	}
+
+	var violations []string
+	for _, image := range podImages(&r.getPodForCustomDeployment(cd, "").Spec) {
+		if err := policy.Check(image); err != nil {
+			violations = append(violations, err.Error())
+		}
+	}
+	if len(violations) == 0 {
+		meta.RemoveStatusCondition(&status.Conditions, demov1alpha1.ConditionImagePolicyViolation)
+		return
+	}
+	message := strings.Join(violations, "; ")
+	if c := meta.FindStatusCondition(cd.Status.Conditions, demov1alpha1.ConditionImagePolicyViolation); c == nil || c.Message != message {
+		r.Recorder.Event(cd, corev1.EventTypeWarning, reasonImageForbidden, message)
+	}
+	setCondition(status, demov1alpha1.ConditionImagePolicyViolation, metav1.ConditionTrue, reasonImageForbidden, message)
+}
+
+// podImages returns the images of the containers of the pod spec.
+func podImages(spec *corev1.PodSpec) []string {
+	var images []string
+	for _, c := range spec.InitContainers {
+		images = append(images, c.Image)
+	}
+	for _, c := range spec.Containers {
+		images = append(images, c.Image)
+	}
+	return images
+}
+
+// enqueueForImagePolicy enqueues every CustomDeployment when the cluster
+// ImagePolicy changes, so that their condition is brought up to date.
+func (r *CustomDeploymentReconciler) enqueueForImagePolicy(obj client.Object) []reconcile.Request {
+	if obj.GetName() != demov1alpha1.ClusterImagePolicyName {
+		return nil
+	}
+	list := &demov1alpha1.CustomDeploymentList{}
+	if err := r.List(context.Background(), list); err != nil {
+		log.Log.Error(err, "Failed to list CustomDeployments for the image policy")
+		return nil
+	}
+	requests := make([]reconcile.Request, 0, len(list.Items))
+	for _, cd := range list.Items {
+		requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&cd)})
+	}
+	return requests
+}
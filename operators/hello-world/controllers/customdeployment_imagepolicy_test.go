@@ -0,0 +1,64 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	demov1alpha1 "github.com/mbtamuli/hello-world/api/v1alpha1"
+	"github.com/mbtamuli/k8s/common/imagepolicy"
+)
+
+func TestCheckImagePolicy(t *testing.T) {
+	policy := &demov1alpha1.ImagePolicy{
+		ObjectMeta: metav1.ObjectMeta{Name: demov1alpha1.ClusterImagePolicyName},
+		Spec:       imagepolicy.Policy{AllowedRegistries: []string{"ghcr.io"}},
+	}
+	cd := newTestCustomDeployment(1, "nginx:1.20")
+	r := newTestReconciler(cd, policy)
+	r.ImagePolicy = demov1alpha1.ClusterImagePolicySource{Reader: r.Client}
+	ctx := context.Background()
+
+	status := cd.Status
+	r.checkImagePolicy(ctx, cd, &status)
+	c := meta.FindStatusCondition(status.Conditions, demov1alpha1.ConditionImagePolicyViolation)
+	if c == nil || c.Status != metav1.ConditionTrue || c.Reason != reasonImageForbidden {
+		t.Fatalf("expected ImagePolicyViolation to be True, got %+v", c)
+	}
+	if !strings.Contains(c.Message, "nginx:1.20") {
+		t.Errorf("expected the message to name the image, got %q", c.Message)
+	}
+
+	cd.Spec.Image = "ghcr.io/example/nginx:1.20"
+	r.checkImagePolicy(ctx, cd, &status)
+	if c := meta.FindStatusCondition(status.Conditions, demov1alpha1.ConditionImagePolicyViolation); c != nil {
+		t.Errorf("expected ImagePolicyViolation to be removed, got %+v", c)
+	}
+
+	if requests := r.enqueueForImagePolicy(policy); len(requests) != 1 || requests[0].Name != cd.Name {
+		t.Errorf("enqueueForImagePolicy() = %v, want the CustomDeployment", requests)
+	}
+	other := &demov1alpha1.ImagePolicy{ObjectMeta: metav1.ObjectMeta{Name: "other"}}
+	if requests := r.enqueueForImagePolicy(other); len(requests) != 0 {
+		t.Errorf("enqueueForImagePolicy() = %v for an ignored policy, want none", requests)
+	}
+}
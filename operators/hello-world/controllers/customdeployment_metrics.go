@@ -0,0 +1,84 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	ctrlmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	"github.com/mbtamuli/k8s/common/metrics"
+)
+
+// customDeploymentMetrics are the CustomDeployment metrics, served on the
+// manager metrics endpoint next to the controller-runtime ones.
+var customDeploymentMetrics = metrics.NewWorkload("customdeployment", "CustomDeployment")
+
+var rolloutDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+	Name: "customdeployment_rollout_duration_seconds",
+	Help: "Time from the creation of a new revision to every replica running it, by strategy.",
+	// From 5 seconds to about 40 minutes
+	Buckets: prometheus.ExponentialBuckets(5, 2, 10),
+}, []string{"strategy"})
+
+func init() {
+	ctrlmetrics.Registry.MustRegister(customDeploymentMetrics.Collectors()...)
+	ctrlmetrics.Registry.MustRegister(rolloutDuration)
+}
+
+// rollouts holds the start time of the rollouts in progress, keyed by
+// namespace and name. Like the expectations it only lives in memory, a
+// rollout in progress when the operator restarts is not observed.
+var rollouts = struct {
+	sync.Mutex
+	started map[string]time.Time
+}{started: map[string]time.Time{}}
+
+// rolloutStarted records the start of a rollout of a CustomDeployment. A
+// rollout replacing one in progress keeps its start time.
+func rolloutStarted(namespace, name string, now time.Time) {
+	rollouts.Lock()
+	defer rollouts.Unlock()
+	key := namespace + "/" + name
+	if _, ok := rollouts.started[key]; !ok {
+		rollouts.started[key] = now
+	}
+}
+
+// rolloutCompleted observes the duration of the rollout of a CustomDeployment
+// that just completed, if its start was recorded.
+func rolloutCompleted(namespace, name, strategy string, now time.Time) {
+	rollouts.Lock()
+	defer rollouts.Unlock()
+	key := namespace + "/" + name
+	started, ok := rollouts.started[key]
+	if !ok {
+		return
+	}
+	delete(rollouts.started, key)
+	rolloutDuration.WithLabelValues(strategy).Observe(now.Sub(started).Seconds())
+}
+
+// forgetMetrics drops the series of a deleted CustomDeployment.
+func forgetMetrics(namespace, name string) {
+	customDeploymentMetrics.Forget(namespace, name)
+	rollouts.Lock()
+	delete(rollouts.started, namespace+"/"+name)
+	rollouts.Unlock()
+}
@@ -0,0 +1,61 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestRolloutDuration(t *testing.T) {
+	// The reconciler tests roll out CustomDeployments too
+	rolloutDuration.Reset()
+	forgetMetrics("default", "web")
+
+	start := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	rolloutStarted("default", "web", start)
+	// A second rollout before the first completed is measured from the first
+	rolloutStarted("default", "web", start.Add(time.Minute))
+	rolloutCompleted("default", "web", "RollingUpdate", start.Add(90*time.Second))
+	// Completions without a recorded start, e.g. after a restart, are ignored
+	rolloutCompleted("default", "web", "RollingUpdate", start.Add(time.Hour))
+	rolloutCompleted("default", "other", "Recreate", start)
+
+	want := `
+# HELP customdeployment_rollout_duration_seconds Time from the creation of a new revision to every replica running it, by strategy.
+# TYPE customdeployment_rollout_duration_seconds histogram
+customdeployment_rollout_duration_seconds_bucket{strategy="RollingUpdate",le="5"} 0
+customdeployment_rollout_duration_seconds_bucket{strategy="RollingUpdate",le="10"} 0
+customdeployment_rollout_duration_seconds_bucket{strategy="RollingUpdate",le="20"} 0
+customdeployment_rollout_duration_seconds_bucket{strategy="RollingUpdate",le="40"} 0
+customdeployment_rollout_duration_seconds_bucket{strategy="RollingUpdate",le="80"} 0
+customdeployment_rollout_duration_seconds_bucket{strategy="RollingUpdate",le="160"} 1
+customdeployment_rollout_duration_seconds_bucket{strategy="RollingUpdate",le="320"} 1
+customdeployment_rollout_duration_seconds_bucket{strategy="RollingUpdate",le="640"} 1
+customdeployment_rollout_duration_seconds_bucket{strategy="RollingUpdate",le="1280"} 1
+customdeployment_rollout_duration_seconds_bucket{strategy="RollingUpdate",le="2560"} 1
+customdeployment_rollout_duration_seconds_bucket{strategy="RollingUpdate",le="+Inf"} 1
+customdeployment_rollout_duration_seconds_sum{strategy="RollingUpdate"} 90
+customdeployment_rollout_duration_seconds_count{strategy="RollingUpdate"} 1
+`
+	if err := testutil.CollectAndCompare(rolloutDuration, strings.NewReader(want)); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,127 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"sort"
+	"strconv"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	demov1alpha1 "github.com/mbtamuli/hello-world/api/v1alpha1"
+	"github.com/mbtamuli/k8s/common/logging"
+	"github.com/mbtamuli/k8s/common/metrics"
+)
+
+// podOrdinalLabel is set on the pods of OrderedReady CustomDeployments to
+// their ordinal, i.e. the index at the end of their name.
+const podOrdinalLabel = "demo.mriyam.dev/pod-ordinal"
+
+// manageOrderedReplicas makes one step towards the spec for an OrderedReady
+// CustomDeployment, much like a StatefulSet does. Pods are named after their
+// ordinal, and at most one pod is created or deleted per reconcile:
+//
+//  1. while any pod is terminating, nothing happens;
+//  2. the lowest missing ordinal below Replicas is created, and failed pods
+//     are deleted so they get recreated, but only once every lower ordinal
+//     is Running and Ready;
+//  3. pods with an ordinal at or above Replicas are deleted, highest first;
+//  4. pods running an outdated template are replaced, highest first.
+//
+// The next step is taken when the pod event of the previous one is observed.
+func (r *CustomDeploymentReconciler) manageOrderedReplicas(ctx context.Context, cd *demov1alpha1.CustomDeployment, selector labels.Selector, templateHash string, pods []corev1.Pod) error {
+	logger := log.FromContext(ctx)
+	replicas := make([]*corev1.Pod, cd.Spec.Replicas)
+	var condemned []*corev1.Pod
+	for i := range pods {
+		pod := &pods[i]
+		if pod.DeletionTimestamp != nil {
+			logger.V(logging.Debug).Info("Waiting for pod to terminate", "pod", pod.Name)
+			return nil
+		}
+		ordinal, ok := podOrdinal(cd, pod)
+		if ok && ordinal < len(replicas) {
+			replicas[ordinal] = pod
+		} else {
+			condemned = append(condemned, pod)
+		}
+	}
+
+	for ordinal, pod := range replicas {
+		switch {
+		case pod == nil:
+			return r.createPods(ctx, cd, selector, []*corev1.Pod{r.getOrderedPodForCustomDeployment(cd, templateHash, ordinal)}, metrics.ReasonScaleUp)
+		case pod.Status.Phase == corev1.PodFailed || pod.Status.Phase == corev1.PodSucceeded:
+			return r.deletePods(ctx, cd, []*corev1.Pod{pod}, metrics.ReasonPodFailed)
+		case pod.Status.Phase != corev1.PodRunning || !isPodReady(pod):
+			logger.V(logging.Debug).Info("Waiting for pod to be running and ready", "pod", pod.Name)
+			return nil
+		}
+	}
+
+	if len(condemned) > 0 {
+		sortPodsByOrdinal(cd, condemned)
+		return r.deletePods(ctx, cd, condemned[len(condemned)-1:], metrics.ReasonScaleDown)
+	}
+
+	for ordinal := len(replicas) - 1; ordinal >= 0; ordinal-- {
+		if pod := replicas[ordinal]; pod.Labels[podTemplateHashLabel] != templateHash {
+			return r.deletePods(ctx, cd, []*corev1.Pod{pod}, metrics.ReasonRollout)
+		}
+	}
+	return nil
+}
+
+// getOrderedPodForCustomDeployment renders the pod with the given ordinal.
+func (r *CustomDeploymentReconciler) getOrderedPodForCustomDeployment(cd *demov1alpha1.CustomDeployment, templateHash string, ordinal int) *corev1.Pod {
+	pod := r.getPodForCustomDeployment(cd, templateHash)
+	pod.GenerateName = ""
+	pod.Name = orderedPodName(cd.Name, ordinal)
+	pod.Labels[podOrdinalLabel] = strconv.Itoa(ordinal)
+	return pod
+}
+
+// orderedPodName returns the stable name of the pod with the given ordinal.
+func orderedPodName(name string, ordinal int) string {
+	return name + "-" + strconv.Itoa(ordinal)
+}
+
+// podOrdinal returns the ordinal of the pod, and false when the pod was not
+// created by manageOrderedReplicas, e.g. before switching policies.
+func podOrdinal(cd *demov1alpha1.CustomDeployment, pod *corev1.Pod) (int, bool) {
+	ordinal, err := strconv.Atoi(pod.Labels[podOrdinalLabel])
+	if err != nil || ordinal < 0 || pod.Name != orderedPodName(cd.Name, ordinal) {
+		return 0, false
+	}
+	return ordinal, true
+}
+
+// sortPodsByOrdinal sorts the pods by increasing ordinal. Pods without an
+// ordinal come last so they are deleted first.
+func sortPodsByOrdinal(cd *demov1alpha1.CustomDeployment, pods []*corev1.Pod) {
+	sort.SliceStable(pods, func(i, j int) bool {
+		oi, iok := podOrdinal(cd, pods[i])
+		oj, jok := podOrdinal(cd, pods[j])
+		if iok != jok {
+			return iok
+		}
+		return oi < oj
+	})
+}
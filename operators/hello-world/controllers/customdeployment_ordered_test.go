@@ -0,0 +1,97 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+
+	demov1alpha1 "github.com/mbtamuli/hello-world/api/v1alpha1"
+)
+
+func testPodNames(pods []corev1.Pod) []string {
+	names := getPodNames(pods)
+	sort.Strings(names)
+	return names
+}
+
+func TestOrderedReady(t *testing.T) {
+	cd := newTestCustomDeployment(3, "nginx:1.20")
+	cd.Spec.PodManagementPolicy = demov1alpha1.OrderedReadyPodManagement
+	r := newTestReconciler(cd)
+
+	// Pods are created one at a time, each once the previous one is ready
+	for _, want := range [][]string{
+		{"web-0"},
+		{"web-0", "web-1"},
+		{"web-0", "web-1", "web-2"},
+	} {
+		pods := syncTestPods(t, r, cd)
+		if got := testPodNames(pods); !reflect.DeepEqual(got, want) {
+			t.Fatalf("pods = %v, want %v", got, want)
+		}
+		if got := testPodNames(syncTestPods(t, r, cd)); !reflect.DeepEqual(got, want) {
+			t.Fatalf("pods = %v before the last one is ready, want %v", got, want)
+		}
+		markTestPodsReady(t, r, pods)
+	}
+	for _, pod := range listTestPods(t, r, cd) {
+		if ordinal, ok := podOrdinal(cd, &pod); !ok || orderedPodName("web", ordinal) != pod.Name {
+			t.Errorf("pod %s has ordinal label %q", pod.Name, pod.Labels[podOrdinalLabel])
+		}
+	}
+
+	// Outdated pods are replaced from the highest ordinal down
+	cd.Spec.Image = "nginx:1.21"
+	hash := computeTemplateHash(cd)
+	var replaced []string
+	for i := 0; i < 20; i++ {
+		before := map[string]bool{}
+		for _, pod := range listTestPods(t, r, cd) {
+			before[pod.Name] = true
+		}
+		pods := syncTestPods(t, r, cd)
+		if len(pods) < 2 {
+			t.Fatalf("step %d: more than one pod replaced at once", i)
+		}
+		for _, pod := range pods {
+			if !before[pod.Name] {
+				replaced = append(replaced, pod.Name)
+			}
+		}
+		markTestPodsReady(t, r, pods)
+	}
+	if want := []string{"web-2", "web-1", "web-0"}; !reflect.DeepEqual(replaced, want) {
+		t.Errorf("replaced %v, want %v", replaced, want)
+	}
+	newPods, _ := splitPodsByTemplateHash(filterActivePods(listTestPods(t, r, cd)), hash)
+	if len(newPods) != 3 {
+		t.Fatalf("expected 3 updated pods, got %d", len(newPods))
+	}
+
+	// Scaling down deletes the highest ordinal first
+	cd.Spec.Replicas = 1
+	if got, want := testPodNames(syncTestPods(t, r, cd)), []string{"web-0", "web-1"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("pods = %v, want %v", got, want)
+	}
+	if got, want := testPodNames(syncTestPods(t, r, cd)), []string{"web-0"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("pods = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,211 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/rand"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	demov1alpha1 "github.com/mbtamuli/hello-world/api/v1alpha1"
+	"github.com/mbtamuli/k8s/common/logging"
+	"github.com/mbtamuli/k8s/common/metrics"
+)
+
+// podTemplateHashLabel is set on every pod to the hash of the template it
+// was created from, so outdated pods can be told apart.
+const podTemplateHashLabel = "pod-template-hash"
+
+// Reasons used for the rollout and rollback events.
+const (
+	reasonRollingOut               = "RollingOut"
+	reasonRolloutComplete          = "RolloutComplete"
+	reasonRolledBack               = "RolledBack"
+	reasonRollbackRevisionNotFound = "RollbackRevisionNotFound"
+	reasonInvalidStrategy          = "InvalidStrategy"
+)
+
+// defaultMaxSurgeOrUnavailable is used for MaxSurge and MaxUnavailable when
+// they are not set, matching the Deployment defaults.
+var defaultMaxSurgeOrUnavailable = intstr.FromString("25%")
+
+// computeTemplateHash returns a hash of everything that ends up in the pod
+// spec, so that any change to it triggers a rollout.
+func computeTemplateHash(cd *demov1alpha1.CustomDeployment) string {
+	hasher := fnv.New32a()
+	template := cd.Spec.PodTemplate()
+	// Marshalling a PodTemplateSpec cannot fail
+	data, _ := json.Marshal(template)
+	hasher.Write(data)
+	if len(template.Spec.Containers) == 0 {
+		hasher.Write([]byte(cd.Spec.Image))
+	}
+	return rand.SafeEncodeString(fmt.Sprint(hasher.Sum32()))
+}
+
+// splitPodsByTemplateHash separates the pods running the template with the
+// given hash from the outdated ones.
+func splitPodsByTemplateHash(pods []*corev1.Pod, templateHash string) (newPods, oldPods []*corev1.Pod) {
+	for _, pod := range pods {
+		if pod.Labels[podTemplateHashLabel] == templateHash {
+			newPods = append(newPods, pod)
+		} else {
+			oldPods = append(oldPods, pod)
+		}
+	}
+	return newPods, oldPods
+}
+
+// resolveFenceposts returns the absolute MaxSurge and MaxUnavailable for the
+// given number of replicas. Like for Deployments, MaxUnavailable is bumped to
+// 1 when both are 0 so that the rollout can make progress.
+func resolveFenceposts(rollingUpdate *demov1alpha1.RollingUpdateCustomDeployment, replicas int) (int, int, error) {
+	maxSurge, maxUnavailable := &defaultMaxSurgeOrUnavailable, &defaultMaxSurgeOrUnavailable
+	if rollingUpdate != nil {
+		if rollingUpdate.MaxSurge != nil {
+			maxSurge = rollingUpdate.MaxSurge
+		}
+		if rollingUpdate.MaxUnavailable != nil {
+			maxUnavailable = rollingUpdate.MaxUnavailable
+		}
+	}
+
+	surge, err := intstr.GetValueFromIntOrPercent(maxSurge, replicas, true)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid maxSurge: %w", err)
+	}
+	unavailable, err := intstr.GetValueFromIntOrPercent(maxUnavailable, replicas, false)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid maxUnavailable: %w", err)
+	}
+	if surge == 0 && unavailable == 0 {
+		unavailable = 1
+	}
+	return surge, unavailable, nil
+}
+
+// minAvailable returns how many pods must be available for the
+// CustomDeployment to be considered Available.
+func minAvailable(cd *demov1alpha1.CustomDeployment) int {
+	if cd.Spec.Strategy.Type == demov1alpha1.RecreateCustomDeploymentStrategyType {
+		return cd.Spec.Replicas
+	}
+	_, maxUnavailable, err := resolveFenceposts(cd.Spec.Strategy.RollingUpdate, cd.Spec.Replicas)
+	if err != nil {
+		return cd.Spec.Replicas
+	}
+	if maxUnavailable > cd.Spec.Replicas {
+		return 0
+	}
+	return cd.Spec.Replicas - maxUnavailable
+}
+
+// rolloutRolling makes one step of a rolling update: it creates new pods
+// within the MaxSurge budget and deletes old pods as long as at least
+// Replicas-MaxUnavailable pods stay ready. Pods that are not ready yet are
+// picked up again on the next reconcile.
+func (r *CustomDeploymentReconciler) rolloutRolling(ctx context.Context, cd *demov1alpha1.CustomDeployment, selector labels.Selector, templateHash string, newPods, oldPods []*corev1.Pod) error {
+	desired := cd.Spec.Replicas
+	maxSurge, maxUnavailable, err := resolveFenceposts(cd.Spec.Strategy.RollingUpdate, desired)
+	if err != nil {
+		r.Recorder.Eventf(cd, corev1.EventTypeWarning, reasonInvalidStrategy, "Invalid rolling update strategy: %v", err)
+		return err
+	}
+
+	// Scale up the new pods within the surge budget, or trim them if the
+	// CustomDeployment was scaled down in the middle of the rollout.
+	if len(newPods) > desired {
+		if err := r.deletePods(ctx, cd, getPodsToDelete(newPods, len(newPods)-desired), metrics.ReasonScaleDown); err != nil {
+			return err
+		}
+	} else if n := min(desired-len(newPods), desired+maxSurge-len(newPods)-len(oldPods)); n > 0 {
+		if err := r.createPods(ctx, cd, selector, r.getPodsForCustomDeployment(cd, templateHash, n), metrics.ReasonRollout); err != nil {
+			return err
+		}
+	}
+
+	// Scale down the old pods while enough pods stay available. Old pods
+	// that are not ready do not count towards availability, so they can
+	// always go.
+	canDelete := countReadyPods(newPods) + countReadyPods(oldPods) - (desired - maxUnavailable)
+	var victims []*corev1.Pod
+	for _, pod := range sortPodsForDeletion(oldPods) {
+		if isPodReady(pod) {
+			if canDelete <= 0 {
+				continue
+			}
+			canDelete--
+		}
+		victims = append(victims, pod)
+	}
+	return r.deletePods(ctx, cd, victims, metrics.ReasonRollout)
+}
+
+// rolloutRecreate deletes all old pods and waits for them to be gone before
+// scaling up the new ones.
+func (r *CustomDeploymentReconciler) rolloutRecreate(ctx context.Context, cd *demov1alpha1.CustomDeployment, selector labels.Selector, templateHash string, pods []corev1.Pod, newPods, oldPods []*corev1.Pod) error {
+	if len(oldPods) > 0 {
+		return r.deletePods(ctx, cd, oldPods, metrics.ReasonRollout)
+	}
+	for i := range pods {
+		if pods[i].DeletionTimestamp != nil && pods[i].Labels[podTemplateHashLabel] != templateHash {
+			log.FromContext(ctx).V(logging.Debug).Info("Waiting for old pod to terminate", "pod", pods[i].Name)
+			return nil
+		}
+	}
+	return r.scalePods(ctx, cd, selector, templateHash, newPods, cd.Spec.Replicas)
+}
+
+// recordRolloutComplete records an event when the status shows that the
+// rollout in progress at the start of the reconcile is over.
+func (r *CustomDeploymentReconciler) recordRolloutComplete(cd *demov1alpha1.CustomDeployment, status demov1alpha1.CustomDeploymentStatus) {
+	before := meta.FindStatusCondition(cd.Status.Conditions, demov1alpha1.ConditionProgressing)
+	after := meta.FindStatusCondition(status.Conditions, demov1alpha1.ConditionProgressing)
+	if before == nil || before.Reason != reasonRollingOutTemplate || after == nil || after.Reason == reasonRollingOutTemplate {
+		return
+	}
+	r.Recorder.Eventf(cd, corev1.EventTypeNormal, reasonRolloutComplete, "Rolled out revision %d", status.CurrentRevision)
+	rolloutCompleted(cd.Namespace, cd.Name, rolloutStrategy(cd), time.Now())
+}
+
+// rolloutStrategy returns how the pods of the CustomDeployment are replaced,
+// the strategy type or OrderedReady.
+func rolloutStrategy(cd *demov1alpha1.CustomDeployment) string {
+	switch {
+	case cd.Spec.PodManagementPolicy == demov1alpha1.OrderedReadyPodManagement:
+		return string(demov1alpha1.OrderedReadyPodManagement)
+	case cd.Spec.Strategy.Type == "":
+		return string(demov1alpha1.RollingUpdateCustomDeploymentStrategyType)
+	}
+	return string(cd.Spec.Strategy.Type)
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,172 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	demov1alpha1 "github.com/mbtamuli/hello-world/api/v1alpha1"
+)
+
+func newTestReconciler(objs ...runtime.Object) *CustomDeploymentReconciler {
+	s := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(s)
+	_ = demov1alpha1.AddToScheme(s)
+	return &CustomDeploymentReconciler{
+		Client:   fake.NewFakeClientWithScheme(s, objs...),
+		Scheme:   s,
+		Recorder: record.NewFakeRecorder(100),
+
+		expectations: newExpectations(),
+	}
+}
+
+func newTestCustomDeployment(replicas int, image string) *demov1alpha1.CustomDeployment {
+	return &demov1alpha1.CustomDeployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default", UID: "web-uid"},
+		Spec:       demov1alpha1.CustomDeploymentSpec{Replicas: replicas, Image: image},
+	}
+}
+
+// syncTestPods runs manageReplicas once and returns the controlled pods.
+func syncTestPods(t *testing.T, r *CustomDeploymentReconciler, cd *demov1alpha1.CustomDeployment) []corev1.Pod {
+	t.Helper()
+	ctx := context.Background()
+	selector, _ := selectorForCustomDeployment(cd)
+	if err := r.manageReplicas(ctx, cd, selector, computeTemplateHash(cd), listTestPods(t, r, cd)); err != nil {
+		t.Fatalf("manageReplicas() error = %v", err)
+	}
+	return listTestPods(t, r, cd)
+}
+
+func listTestPods(t *testing.T, r *CustomDeploymentReconciler, cd *demov1alpha1.CustomDeployment) []corev1.Pod {
+	t.Helper()
+	podList := &corev1.PodList{}
+	if err := r.List(context.Background(), podList, client.InNamespace(cd.Namespace)); err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	return filterControlledPods(podList.Items, cd)
+}
+
+// markTestPodsReady plays the part of the kubelet.
+func markTestPodsReady(t *testing.T, r *CustomDeploymentReconciler, pods []corev1.Pod) {
+	t.Helper()
+	for i := range pods {
+		pods[i].Status.Phase = corev1.PodRunning
+		pods[i].Status.Conditions = []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionTrue}}
+		if err := r.Update(context.Background(), &pods[i]); err != nil {
+			t.Fatalf("Update() error = %v", err)
+		}
+	}
+}
+
+func TestRollingUpdate(t *testing.T) {
+	cd := newTestCustomDeployment(4, "nginx:1.20")
+	r := newTestReconciler(cd)
+	markTestPodsReady(t, r, syncTestPods(t, r, cd))
+
+	cd.Spec.Image = "nginx:1.21"
+	hash := computeTemplateHash(cd)
+	for i := 0; ; i++ {
+		if i == 20 {
+			t.Fatal("rollout did not converge")
+		}
+		pods := syncTestPods(t, r, cd)
+		active := filterActivePods(pods)
+		// 25% of 4 replicas: one surge pod, one unavailable pod
+		if len(active) > 5 {
+			t.Fatalf("step %d: %d pods exceed maxSurge", i, len(active))
+		}
+		if ready := countReadyPods(active); ready < 3 {
+			t.Fatalf("step %d: only %d pods ready, below maxUnavailable", i, ready)
+		}
+		newPods, oldPods := splitPodsByTemplateHash(active, hash)
+		if len(oldPods) == 0 && len(newPods) == 4 {
+			break
+		}
+		markTestPodsReady(t, r, pods)
+	}
+}
+
+func TestRecreate(t *testing.T) {
+	cd := newTestCustomDeployment(3, "nginx:1.20")
+	cd.Spec.Strategy.Type = demov1alpha1.RecreateCustomDeploymentStrategyType
+	r := newTestReconciler(cd)
+	markTestPodsReady(t, r, syncTestPods(t, r, cd))
+
+	cd.Spec.Image = "nginx:1.21"
+	if pods := syncTestPods(t, r, cd); len(pods) != 0 {
+		t.Fatalf("expected all old pods to be deleted first, got %d pods", len(pods))
+	}
+	pods := syncTestPods(t, r, cd)
+	newPods, _ := splitPodsByTemplateHash(filterActivePods(pods), computeTemplateHash(cd))
+	if len(pods) != 3 || len(newPods) != 3 {
+		t.Fatalf("expected 3 new pods, got %d pods of which %d new", len(pods), len(newPods))
+	}
+}
+
+func TestComputeTemplateHashWithoutTemplate(t *testing.T) {
+	cd := newTestCustomDeployment(1, "nginx:1.21")
+	withEmptyTemplate := cd.DeepCopy()
+	withEmptyTemplate.Spec.Template = &corev1.PodTemplateSpec{}
+	// CustomDeployments stored before the template became optional must
+	// not roll out
+	if got, want := computeTemplateHash(cd), computeTemplateHash(withEmptyTemplate); got != want {
+		t.Errorf("computeTemplateHash() = %q without a template, want %q as with an empty one", got, want)
+	}
+}
+
+func TestResolveFenceposts(t *testing.T) {
+	zero := intstr.FromInt(0)
+	tests := []struct {
+		name            string
+		rollingUpdate   *demov1alpha1.RollingUpdateCustomDeployment
+		replicas        int
+		wantSurge       int
+		wantUnavailable int
+	}{
+		{name: "defaults", replicas: 10, wantSurge: 3, wantUnavailable: 2},
+		{
+			name:            "both zero",
+			rollingUpdate:   &demov1alpha1.RollingUpdateCustomDeployment{MaxSurge: &zero, MaxUnavailable: &zero},
+			replicas:        10,
+			wantSurge:       0,
+			wantUnavailable: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			surge, unavailable, err := resolveFenceposts(tt.rollingUpdate, tt.replicas)
+			if err != nil {
+				t.Fatalf("resolveFenceposts() error = %v", err)
+			}
+			if surge != tt.wantSurge || unavailable != tt.wantUnavailable {
+				t.Errorf("resolveFenceposts() = %d, %d, want %d, %d", surge, unavailable, tt.wantSurge, tt.wantUnavailable)
+			}
+		})
+	}
+}
@@ -0,0 +1,107 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+
+	demov1alpha1 "github.com/mbtamuli/hello-world/api/v1alpha1"
+)
+
+// Reasons used for the CustomDeployment conditions.
+const (
+	reasonMinimumReplicasAvailable   = "MinimumReplicasAvailable"
+	reasonMinimumReplicasUnavailable = "MinimumReplicasUnavailable"
+	reasonRollingOutTemplate         = "RollingOutTemplate"
+	reasonScalingReplicas            = "ScalingReplicas"
+	reasonWaitingForReadyPods        = "WaitingForReadyPods"
+	reasonReplicasReady              = "ReplicasReady"
+	reasonFailedCreate               = "FailedCreate"
+	reasonFailedDelete               = "FailedDelete"
+)
+
+// calculateStatus computes the status of the CustomDeployment from the active
+// pods observed at the start of the reconcile and the error, if any, returned
+// while creating or deleting pods.
+func calculateStatus(cd *demov1alpha1.CustomDeployment, selector labels.Selector, templateHash string, activePods []*corev1.Pod, manageErr error) demov1alpha1.CustomDeploymentStatus {
+	status := *cd.Status.DeepCopy()
+
+	newPods, _ := splitPodsByTemplateHash(activePods, templateHash)
+	current := int32(len(activePods))
+	updated := int32(len(newPods))
+	ready := int32(countReadyPods(activePods))
+	desired := int32(cd.Spec.Replicas)
+
+	status.Replicas = current
+	status.ReadyReplicas = ready
+	status.AvailableReplicas = ready
+	status.UpdatedReplicas = updated
+	status.TemplateHash = templateHash
+	status.ObservedGeneration = cd.Generation
+	status.Selector = selector.String()
+
+	if ready >= int32(minAvailable(cd)) {
+		setCondition(&status, demov1alpha1.ConditionAvailable, metav1.ConditionTrue, reasonMinimumReplicasAvailable,
+			"CustomDeployment has minimum availability.")
+	} else {
+		setCondition(&status, demov1alpha1.ConditionAvailable, metav1.ConditionFalse, reasonMinimumReplicasUnavailable,
+			fmt.Sprintf("%d of %d replicas are available.", ready, desired))
+	}
+
+	switch {
+	case updated != current:
+		setCondition(&status, demov1alpha1.ConditionProgressing, metav1.ConditionTrue, reasonRollingOutTemplate,
+			fmt.Sprintf("Rolling out template %s: %d of %d pods updated.", templateHash, updated, desired))
+	case current != desired:
+		setCondition(&status, demov1alpha1.ConditionProgressing, metav1.ConditionTrue, reasonScalingReplicas,
+			fmt.Sprintf("Scaling from %d to %d replicas.", current, desired))
+	case ready < desired:
+		setCondition(&status, demov1alpha1.ConditionProgressing, metav1.ConditionTrue, reasonWaitingForReadyPods,
+			fmt.Sprintf("Waiting for %d pods to become ready.", desired-ready))
+	default:
+		setCondition(&status, demov1alpha1.ConditionProgressing, metav1.ConditionFalse, reasonReplicasReady,
+			"All replicas are ready.")
+	}
+
+	if manageErr != nil {
+		reason := reasonFailedCreate
+		if current > desired {
+			reason = reasonFailedDelete
+		}
+		setCondition(&status, demov1alpha1.ConditionReplicaFailure, metav1.ConditionTrue, reason, manageErr.Error())
+	} else {
+		meta.RemoveStatusCondition(&status.Conditions, demov1alpha1.ConditionReplicaFailure)
+	}
+
+	return status
+}
+
+// setCondition adds or updates the condition of the given type. The last
+// transition time is only bumped when the status changes.
+func setCondition(status *demov1alpha1.CustomDeploymentStatus, conditionType string, conditionStatus metav1.ConditionStatus, reason, message string) {
+	meta.SetStatusCondition(&status.Conditions, metav1.Condition{
+		Type:    conditionType,
+		Status:  conditionStatus,
+		Reason:  reason,
+		Message: message,
+	})
+}
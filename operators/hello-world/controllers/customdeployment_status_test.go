@@ -0,0 +1,80 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	demov1alpha1 "github.com/mbtamuli/hello-world/api/v1alpha1"
+)
+
+func TestCalculateStatus(t *testing.T) {
+	cd := &demov1alpha1.CustomDeployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "sample", Generation: 3},
+		Spec:       demov1alpha1.CustomDeploymentSpec{Replicas: 2},
+	}
+	pods := []*corev1.Pod{
+		newTestPod("ready", "a", time.Minute, true),
+		newTestPod("unready", "a", time.Minute, false),
+	}
+	for _, pod := range pods {
+		pod.Labels = map[string]string{podTemplateHashLabel: "hash"}
+	}
+
+	selector, _ := selectorForCustomDeployment(cd)
+	status := calculateStatus(cd, selector, "hash", pods, nil)
+	if status.Replicas != 2 || status.ReadyReplicas != 1 || status.AvailableReplicas != 1 || status.UpdatedReplicas != 2 {
+		t.Errorf("unexpected replica counts: %+v", status)
+	}
+	if status.ObservedGeneration != 3 {
+		t.Errorf("ObservedGeneration = %d, want 3", status.ObservedGeneration)
+	}
+	if want := "app=customdeployment,customdeployment_cr=sample"; status.Selector != want {
+		t.Errorf("Selector = %q, want %q", status.Selector, want)
+	}
+	if !meta.IsStatusConditionFalse(status.Conditions, demov1alpha1.ConditionAvailable) {
+		t.Errorf("expected Available to be False")
+	}
+	if !meta.IsStatusConditionTrue(status.Conditions, demov1alpha1.ConditionProgressing) {
+		t.Errorf("expected Progressing to be True")
+	}
+
+	cd.Status = status
+	pods[1].Status.Conditions[0].Status = corev1.ConditionTrue
+	status = calculateStatus(cd, selector, "hash", pods, errors.New("boom"))
+	if !meta.IsStatusConditionTrue(status.Conditions, demov1alpha1.ConditionAvailable) {
+		t.Errorf("expected Available to be True")
+	}
+	if !meta.IsStatusConditionFalse(status.Conditions, demov1alpha1.ConditionProgressing) {
+		t.Errorf("expected Progressing to be False")
+	}
+	if c := meta.FindStatusCondition(status.Conditions, demov1alpha1.ConditionReplicaFailure); c == nil || c.Message != "boom" {
+		t.Errorf("expected ReplicaFailure with the error message, got %+v", c)
+	}
+
+	cd.Status = status
+	status = calculateStatus(cd, selector, "hash", pods, nil)
+	if meta.FindStatusCondition(status.Conditions, demov1alpha1.ConditionReplicaFailure) != nil {
+		t.Errorf("expected ReplicaFailure to be removed")
+	}
+}
@@ -0,0 +1,138 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"sync"
+	"time"
+)
+
+// expectationsTimeout is how long we wait for the cache to observe pending
+// creations and deletions before acting again anyway. It guards against
+// events that are never delivered, e.g. when a watch is re-established.
+const expectationsTimeout = 5 * time.Minute
+
+// expectations tracks, per controller key, the pod creations and deletions a
+// controller has issued but not yet seen in its informer cache. Like the
+// ReplicaSet controller's ControllerExpectations, it lets the reconciler
+// skip acting on a stale cache, which would otherwise create or delete the
+// same pods twice.
+//
+// Deletions are tracked by pod key rather than by count, so that the several
+// events a single deletion produces (update with a deletion timestamp, then
+// delete) are only counted once.
+type expectations struct {
+	mu    sync.Mutex
+	store map[string]*controlleeExpectations
+	now   func() time.Time
+}
+
+// controlleeExpectations are the pending operations of one controller.
+type controlleeExpectations struct {
+	add       int
+	del       map[string]struct{}
+	timestamp time.Time
+}
+
+func newExpectations() *expectations {
+	return &expectations{
+		store: map[string]*controlleeExpectations{},
+		now:   time.Now,
+	}
+}
+
+// SatisfiedExpectations reports whether the controller can trust the cache
+// again: all pending operations were observed, the expectations expired, or
+// none were ever recorded.
+func (e *expectations) SatisfiedExpectations(key string) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	exp, ok := e.store[key]
+	if !ok {
+		return true
+	}
+	if exp.add <= 0 && len(exp.del) == 0 {
+		return true
+	}
+	return e.now().Sub(exp.timestamp) > expectationsTimeout
+}
+
+// ExpectCreations records that n pods are about to be created.
+func (e *expectations) ExpectCreations(key string, n int) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	exp := e.get(key)
+	exp.add += n
+	exp.timestamp = e.now()
+}
+
+// ExpectDeletions records that the pods with the given keys are about to be
+// deleted.
+func (e *expectations) ExpectDeletions(key string, podKeys []string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	exp := e.get(key)
+	for _, podKey := range podKeys {
+		exp.del[podKey] = struct{}{}
+	}
+	exp.timestamp = e.now()
+}
+
+// CreationObserved records that a pod creation was observed, or that an
+// expected creation will never happen because it failed.
+func (e *expectations) CreationObserved(key string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if exp, ok := e.store[key]; ok && exp.add > 0 {
+		exp.add--
+	}
+}
+
+// DeletionObserved records that the deletion of the given pod was observed,
+// or that an expected deletion will never happen because it failed.
+func (e *expectations) DeletionObserved(key, podKey string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if exp, ok := e.store[key]; ok {
+		delete(exp.del, podKey)
+	}
+}
+
+// DeleteExpectations forgets everything about the controller, e.g. once it
+// has been deleted.
+func (e *expectations) DeleteExpectations(key string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	delete(e.store, key)
+}
+
+// get returns the expectations of the controller, creating them if needed.
+// The caller must hold the lock.
+func (e *expectations) get(key string) *controlleeExpectations {
+	exp, ok := e.store[key]
+	if !ok {
+		exp = &controlleeExpectations{del: map[string]struct{}{}}
+		e.store[key] = exp
+	}
+	return exp
+}
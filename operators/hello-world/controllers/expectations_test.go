@@ -0,0 +1,159 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/util/workqueue"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+)
+
+func TestExpectations(t *testing.T) {
+	now := time.Now()
+	e := newExpectations()
+	e.now = func() time.Time { return now }
+
+	if !e.SatisfiedExpectations("ns/cd") {
+		t.Fatal("expected no expectations to be satisfied")
+	}
+
+	e.ExpectCreations("ns/cd", 2)
+	e.ExpectDeletions("ns/cd", []string{"ns/pod"})
+	e.CreationObserved("ns/cd")
+	e.CreationObserved("ns/cd")
+	if e.SatisfiedExpectations("ns/cd") {
+		t.Fatal("expected pending deletion to block")
+	}
+
+	// A graceful deletion is observed twice, once as an update and once as
+	// the actual delete.
+	e.DeletionObserved("ns/cd", "ns/pod")
+	e.DeletionObserved("ns/cd", "ns/pod")
+	if !e.SatisfiedExpectations("ns/cd") {
+		t.Fatal("expected all observed expectations to be satisfied")
+	}
+
+	e.ExpectCreations("ns/cd", 1)
+	if e.SatisfiedExpectations("ns/cd") {
+		t.Fatal("expected pending creation to block")
+	}
+	now = now.Add(expectationsTimeout + time.Second)
+	if !e.SatisfiedExpectations("ns/cd") {
+		t.Fatal("expected expired expectations to be satisfied")
+	}
+
+	e.DeleteExpectations("ns/cd")
+	e.CreationObserved("ns/cd")
+	if !e.SatisfiedExpectations("ns/cd") {
+		t.Fatal("expected deleted expectations to be satisfied")
+	}
+}
+
+// laggingClient serves pod lists from a snapshot, like an informer cache
+// that has not caught up with the writes made through it yet.
+type laggingClient struct {
+	client.Client
+	pods *corev1.PodList
+}
+
+func (c *laggingClient) List(ctx context.Context, list client.ObjectList, opts ...client.ListOption) error {
+	if podList, ok := list.(*corev1.PodList); ok && c.pods != nil {
+		c.pods.DeepCopyInto(podList)
+		return nil
+	}
+	return c.Client.List(ctx, list, opts...)
+}
+
+// snapshot freezes the pods the client lists to the current ones.
+func (c *laggingClient) snapshot(t *testing.T) {
+	t.Helper()
+	c.pods = nil
+	pods := &corev1.PodList{}
+	if err := c.List(context.Background(), pods); err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	c.pods = pods
+}
+
+func TestReconcileWithLaggingCache(t *testing.T) {
+	ctx := context.Background()
+	cd := newTestCustomDeployment(3, "nginx:1.21")
+	r := newTestReconciler(cd)
+	cache := &laggingClient{Client: r.Client}
+	r.Client = cache
+	req := ctrl.Request{NamespacedName: client.ObjectKeyFromObject(cd)}
+	queue := workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter())
+	defer queue.ShutDown()
+	handler := r.podEventHandler()
+
+	reconcile := func() {
+		t.Helper()
+		if _, err := r.Reconcile(ctx, req); err != nil {
+			t.Fatalf("Reconcile() error = %v", err)
+		}
+	}
+	livePods := func() []corev1.Pod {
+		t.Helper()
+		pods := &corev1.PodList{}
+		if err := cache.Client.List(ctx, pods); err != nil {
+			t.Fatalf("List() error = %v", err)
+		}
+		return pods.Items
+	}
+
+	// The cache never sees the pods created by the first reconcile
+	cache.snapshot(t)
+	reconcile()
+	reconcile()
+	if pods := livePods(); len(pods) != 3 {
+		t.Fatalf("expected 3 pods despite the stale cache, got %d", len(pods))
+	}
+
+	// Once the creations are observed, the reconciler acts again
+	for i := range livePods() {
+		handler.Create(event.CreateEvent{Object: &livePods()[i]}, queue)
+	}
+	cache.snapshot(t)
+	if !r.expectations.SatisfiedExpectations(req.String()) {
+		t.Fatal("expected creations to be observed")
+	}
+
+	// Scale down with a cache that still shows every pod: a second
+	// reconcile must not pick another victim among the survivors.
+	if err := r.Get(ctx, req.NamespacedName, cd); err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	cd.Spec.Replicas = 1
+	if err := r.Update(ctx, cd); err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+	reconcile()
+	survivors := livePods()
+	if len(survivors) != 1 {
+		t.Fatalf("expected 1 pod after scaling down, got %d", len(survivors))
+	}
+	reconcile()
+	if pods := livePods(); len(pods) != 1 || pods[0].Name != survivors[0].Name {
+		t.Fatalf("expected %s to survive the stale cache, got %v", survivors[0].Name, getPodNames(pods))
+	}
+}
@@ -0,0 +1,128 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"sort"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// filterActivePods returns the pods that are neither terminating nor in a
+// terminal phase. Only these count towards the replicas of a CustomDeployment.
+func filterActivePods(pods []corev1.Pod) []*corev1.Pod {
+	var active []*corev1.Pod
+	for i := range pods {
+		if isPodActive(&pods[i]) {
+			active = append(active, &pods[i])
+		}
+	}
+	return active
+}
+
+// filterControlledPods returns the pods whose controller is the given owner.
+func filterControlledPods(pods []corev1.Pod, owner metav1.Object) []corev1.Pod {
+	var controlled []corev1.Pod
+	for _, pod := range pods {
+		if metav1.IsControlledBy(&pod, owner) {
+			controlled = append(controlled, pod)
+		}
+	}
+	return controlled
+}
+
+// isPodActive reports whether the pod is still expected to be running.
+func isPodActive(pod *corev1.Pod) bool {
+	return pod.DeletionTimestamp == nil &&
+		pod.Status.Phase != corev1.PodSucceeded &&
+		pod.Status.Phase != corev1.PodFailed
+}
+
+// countReadyPods returns the number of pods with the Ready condition.
+func countReadyPods(pods []*corev1.Pod) int {
+	ready := 0
+	for _, pod := range pods {
+		if isPodReady(pod) {
+			ready++
+		}
+	}
+	return ready
+}
+
+// isPodReady reports whether the pod has the Ready condition set to true.
+func isPodReady(pod *corev1.Pod) bool {
+	for _, c := range pod.Status.Conditions {
+		if c.Type == corev1.PodReady {
+			return c.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// getPodsToDelete returns the diff pods that should be deleted first when
+// scaling down. The order is deterministic, see podsByDeletionPriority.
+func getPodsToDelete(pods []*corev1.Pod, diff int) []*corev1.Pod {
+	if diff >= len(pods) {
+		return pods
+	}
+	return sortPodsForDeletion(pods)[:diff]
+}
+
+// sortPodsForDeletion returns a copy of pods sorted by podsByDeletionPriority.
+func sortPodsForDeletion(pods []*corev1.Pod) []*corev1.Pod {
+	podsOnNode := map[string]int{}
+	for _, pod := range pods {
+		if pod.Spec.NodeName != "" {
+			podsOnNode[pod.Spec.NodeName]++
+		}
+	}
+
+	sorted := make([]*corev1.Pod, len(pods))
+	copy(sorted, pods)
+	sort.Sort(podsByDeletionPriority{pods: sorted, podsOnNode: podsOnNode})
+	return sorted
+}
+
+// podsByDeletionPriority sorts pods so that the ones we would rather lose
+// come first:
+//  1. not-ready pods before ready ones
+//  2. newer pods before older ones
+//  3. pods on more crowded nodes before pods on less crowded ones
+//
+// The pod name is used as a final tie breaker to keep the order stable.
+type podsByDeletionPriority struct {
+	pods       []*corev1.Pod
+	podsOnNode map[string]int
+}
+
+func (s podsByDeletionPriority) Len() int      { return len(s.pods) }
+func (s podsByDeletionPriority) Swap(i, j int) { s.pods[i], s.pods[j] = s.pods[j], s.pods[i] }
+
+func (s podsByDeletionPriority) Less(i, j int) bool {
+	pi, pj := s.pods[i], s.pods[j]
+	if ri, rj := isPodReady(pi), isPodReady(pj); ri != rj {
+		return !ri
+	}
+	if ti, tj := pi.CreationTimestamp, pj.CreationTimestamp; !ti.Equal(&tj) {
+		return tj.Before(&ti)
+	}
+	if ni, nj := s.podsOnNode[pi.Spec.NodeName], s.podsOnNode[pj.Spec.NodeName]; ni != nj {
+		return ni > nj
+	}
+	return pi.Name < pj.Name
+}
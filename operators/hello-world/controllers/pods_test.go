@@ -0,0 +1,122 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func newTestPod(name, node string, age time.Duration, ready bool) *corev1.Pod {
+	status := corev1.ConditionFalse
+	if ready {
+		status = corev1.ConditionTrue
+	}
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              name,
+			CreationTimestamp: metav1.NewTime(time.Unix(1600000000, 0).Add(-age)),
+		},
+		Spec: corev1.PodSpec{NodeName: node},
+		Status: corev1.PodStatus{
+			Phase:      corev1.PodRunning,
+			Conditions: []corev1.PodCondition{{Type: corev1.PodReady, Status: status}},
+		},
+	}
+}
+
+func TestGetPodsToDelete(t *testing.T) {
+	tests := []struct {
+		name string
+		pods []*corev1.Pod
+		diff int
+		want []string
+	}{
+		{
+			name: "not ready pods go first",
+			pods: []*corev1.Pod{
+				newTestPod("young-ready", "a", time.Minute, true),
+				newTestPod("old-unready", "a", time.Hour, false),
+			},
+			diff: 1,
+			want: []string{"old-unready"},
+		},
+		{
+			name: "newest pods go next",
+			pods: []*corev1.Pod{
+				newTestPod("old", "a", time.Hour, true),
+				newTestPod("young", "b", time.Minute, true),
+				newTestPod("middle", "c", 10*time.Minute, true),
+			},
+			diff: 2,
+			want: []string{"young", "middle"},
+		},
+		{
+			name: "crowded nodes break ties",
+			pods: []*corev1.Pod{
+				newTestPod("alone", "a", time.Minute, true),
+				newTestPod("crowded-1", "b", time.Minute, true),
+				newTestPod("crowded-2", "b", time.Minute, true),
+			},
+			diff: 1,
+			want: []string{"crowded-1"},
+		},
+		{
+			name: "diff larger than pods",
+			pods: []*corev1.Pod{
+				newTestPod("only", "a", time.Minute, true),
+			},
+			diff: 3,
+			want: []string{"only"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := getPodNames(derefPods(getPodsToDelete(tt.pods, tt.diff)))
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("getPodsToDelete() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFilterActivePods(t *testing.T) {
+	now := metav1.Now()
+	running := *newTestPod("running", "a", time.Minute, true)
+	failed := *newTestPod("failed", "a", time.Minute, false)
+	failed.Status.Phase = corev1.PodFailed
+	terminating := *newTestPod("terminating", "a", time.Minute, true)
+	terminating.DeletionTimestamp = &now
+
+	got := getPodNames(derefPods(filterActivePods([]corev1.Pod{running, failed, terminating})))
+	if want := []string{"running"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("filterActivePods() = %v, want %v", got, want)
+	}
+}
+
+func derefPods(pods []*corev1.Pod) []corev1.Pod {
+	out := make([]corev1.Pod, 0, len(pods))
+	for _, pod := range pods {
+		out = append(out, *pod)
+	}
+	return out
+}
@@ -0,0 +1,167 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package autoscaling
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+// Tolerance is how far the metric may drift from its target before the
+// replicas change, as a fraction of the target.
+const Tolerance = 0.1
+
+// Decision is the outcome of an autoscaling round.
+type Decision struct {
+	// MetricValue is the value of the metric read.
+	MetricValue float64
+	// Recommended is the number of replicas the metric asks for, before
+	// stabilization and limits.
+	Recommended int32
+	// Desired is the number of replicas to run.
+	Desired int32
+	// Limited is true when MinReplicas or MaxReplicas kept the replicas
+	// from following the metric.
+	Limited bool
+}
+
+// recommendation is a number of replicas recommended at some point.
+type recommendation struct {
+	replicas int32
+	time     time.Time
+}
+
+// Autoscaler computes the desired replicas of workloads from their metric.
+// It remembers the recent recommendations of each workload to stabilize
+// them, like the HorizontalPodAutoscaler does. They are kept in memory and
+// start over when the operator restarts.
+type Autoscaler struct {
+	metrics MetricsSource
+	// now returns the current time, it is replaced in tests
+	now func() time.Time
+
+	mu              sync.Mutex
+	recommendations map[string][]recommendation
+}
+
+// NewAutoscaler returns an Autoscaler reading the metrics from the source.
+func NewAutoscaler(metrics MetricsSource) *Autoscaler {
+	return &Autoscaler{
+		metrics:         metrics,
+		now:             time.Now,
+		recommendations: map[string][]recommendation{},
+	}
+}
+
+// Decide reads the metric of the workload identified by key and returns the
+// replicas it should run, given it currently asks for current replicas.
+func (a *Autoscaler) Decide(ctx context.Context, key string, spec *Spec, current int32) (Decision, error) {
+	target := float64(spec.Metric.TargetAverageValue.MilliValue()) / 1000
+	if target <= 0 {
+		return Decision{}, fmt.Errorf("target average value %s is not positive", spec.Metric.TargetAverageValue.String())
+	}
+	value, err := a.metrics.MetricValue(ctx, spec.Metric.Query)
+	if err != nil {
+		return Decision{}, err
+	}
+
+	recommended := replicasForMetric(value, target, current)
+	stabilized := a.stabilize(key, spec, current, recommended)
+	desired := stabilized
+	if floor := spec.minReplicas(); desired < floor {
+		desired = floor
+	}
+	if desired > spec.MaxReplicas {
+		desired = spec.MaxReplicas
+	}
+	return Decision{
+		MetricValue: value,
+		Recommended: recommended,
+		Desired:     desired,
+		Limited:     desired != stabilized,
+	}, nil
+}
+
+// Forget drops the recommendations of the workload, once it is deleted.
+func (a *Autoscaler) Forget(key string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	delete(a.recommendations, key)
+}
+
+// replicasForMetric returns the replicas needed for each to handle the
+// target value of the metric. The current replicas are kept while the
+// metric is within the tolerance of the target.
+func replicasForMetric(value, target float64, current int32) int32 {
+	if value <= 0 {
+		return 0
+	}
+	if current > 0 && math.Abs(value/(target*float64(current))-1) <= Tolerance {
+		return current
+	}
+	replicas := math.Ceil(value / target)
+	if replicas > math.MaxInt32 {
+		return math.MaxInt32
+	}
+	return int32(replicas)
+}
+
+// stabilize records the recommendation and returns the replicas to scale
+// to: scaling up follows the lowest recommendation over the scale up window
+// and scaling down the highest over the scale down window.
+func (a *Autoscaler) stabilize(key string, spec *Spec, current, recommended int32) int32 {
+	upWindow := time.Duration(spec.ScaleUp.stabilizationWindowSeconds(DefaultScaleUpStabilizationWindowSeconds)) * time.Second
+	downWindow := time.Duration(spec.ScaleDown.stabilizationWindowSeconds(DefaultScaleDownStabilizationWindowSeconds)) * time.Second
+	longest := upWindow
+	if downWindow > longest {
+		longest = downWindow
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	now := a.now()
+	// Drop the recommendations no window looks at anymore
+	var kept []recommendation
+	for _, r := range a.recommendations[key] {
+		if !r.time.Before(now.Add(-longest)) {
+			kept = append(kept, r)
+		}
+	}
+	kept = append(kept, recommendation{replicas: recommended, time: now})
+	a.recommendations[key] = kept
+
+	up, down := recommended, recommended
+	for _, r := range kept {
+		if !r.time.Before(now.Add(-upWindow)) && r.replicas < up {
+			up = r.replicas
+		}
+		if !r.time.Before(now.Add(-downWindow)) && r.replicas > down {
+			down = r.replicas
+		}
+	}
+	stabilized := current
+	if stabilized < up {
+		stabilized = up
+	}
+	if stabilized > down {
+		stabilized = down
+	}
+	return stabilized
+}
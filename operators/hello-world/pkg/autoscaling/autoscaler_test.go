@@ -0,0 +1,168 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package autoscaling
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+const testQuery = "sum(rate(http_requests_total[2m]))"
+
+func int32Ptr(i int32) *int32 { return &i }
+
+func newTestSpec(min, max int32) *Spec {
+	return &Spec{
+		MinReplicas: int32Ptr(min),
+		MaxReplicas: max,
+		Metric:      Metric{Query: testQuery, TargetAverageValue: resource.MustParse("10")},
+	}
+}
+
+// newTestAutoscaler returns an Autoscaler reading from metrics with a clock
+// advanced by the returned func.
+func newTestAutoscaler(metrics MetricsSource) (*Autoscaler, func(time.Duration)) {
+	a := NewAutoscaler(metrics)
+	now := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	a.now = func() time.Time { return now }
+	return a, func(d time.Duration) { now = now.Add(d) }
+}
+
+func TestReplicasForMetric(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   float64
+		current int32
+		want    int32
+	}{
+		{name: "rounds up", value: 21, current: 1, want: 3},
+		{name: "exact", value: 40, current: 2, want: 4},
+		{name: "within tolerance above", value: 32, current: 3, want: 3},
+		{name: "within tolerance below", value: 28, current: 3, want: 3},
+		{name: "outside tolerance", value: 34, current: 3, want: 4},
+		{name: "from zero", value: 5, current: 0, want: 1},
+		{name: "no load", value: 0, current: 4, want: 0},
+		{name: "overflow", value: 1e12, current: 1, want: 1<<31 - 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := replicasForMetric(tt.value, 10, tt.current); got != tt.want {
+				t.Errorf("replicasForMetric(%v, 10, %d) = %d, want %d", tt.value, tt.current, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecideLimits(t *testing.T) {
+	metrics := &FakeSource{}
+	a, advance := newTestAutoscaler(metrics)
+	spec := newTestSpec(2, 5)
+	spec.ScaleDown = &ScalingRules{StabilizationWindowSeconds: int32Ptr(0)}
+
+	tests := []struct {
+		value       float64
+		want        int32
+		wantLimited bool
+	}{
+		{value: 30, want: 3},
+		{value: 100, want: 5, wantLimited: true},
+		{value: 0, want: 2, wantLimited: true},
+	}
+	for _, tt := range tests {
+		advance(time.Second)
+		metrics.SetValue(testQuery, tt.value)
+		got, err := a.Decide(context.Background(), "default/web", spec, 3)
+		if err != nil {
+			t.Fatalf("Decide() error = %v", err)
+		}
+		if got.Desired != tt.want || got.Limited != tt.wantLimited || got.MetricValue != tt.value {
+			t.Errorf("Decide() with %v = %+v, want %d replicas, limited %v", tt.value, got, tt.want, tt.wantLimited)
+		}
+	}
+}
+
+func TestDecideStabilization(t *testing.T) {
+	metrics := &FakeSource{}
+	a, advance := newTestAutoscaler(metrics)
+	spec := newTestSpec(1, 10)
+	spec.ScaleUp = &ScalingRules{StabilizationWindowSeconds: int32Ptr(60)}
+	ctx := context.Background()
+	decide := func(value float64, current int32) int32 {
+		t.Helper()
+		metrics.SetValue(testQuery, value)
+		d, err := a.Decide(ctx, "default/web", spec, current)
+		if err != nil {
+			t.Fatalf("Decide() error = %v", err)
+		}
+		return d.Desired
+	}
+
+	// Scaling up waits for the metric to stay high for the whole window
+	if got := decide(20, 2); got != 2 {
+		t.Fatalf("first spike: Desired = %d, want 2", got)
+	}
+	advance(30 * time.Second)
+	if got := decide(50, 2); got != 2 {
+		t.Fatalf("spike within the window: Desired = %d, want 2", got)
+	}
+	advance(31 * time.Second)
+	if got := decide(50, 2); got != 5 {
+		t.Fatalf("spike past the window: Desired = %d, want 5", got)
+	}
+
+	// Scaling down holds the highest recommendation for 5 minutes
+	advance(time.Minute)
+	if got := decide(10, 5); got != 5 {
+		t.Fatalf("drop: Desired = %d, want 5", got)
+	}
+	advance(4 * time.Minute)
+	if got := decide(10, 5); got != 5 {
+		t.Fatalf("drop within the window: Desired = %d, want 5", got)
+	}
+	advance(time.Minute + time.Second)
+	if got := decide(10, 5); got != 1 {
+		t.Fatalf("drop past the window: Desired = %d, want 1", got)
+	}
+
+	// Forgetting the workload starts the history over
+	a.Forget("default/web")
+	if got := decide(10, 5); got != 1 {
+		t.Fatalf("after Forget: Desired = %d, want 1", got)
+	}
+}
+
+func TestDecideErrors(t *testing.T) {
+	metrics := &FakeSource{}
+	a, _ := newTestAutoscaler(metrics)
+	ctx := context.Background()
+
+	metrics.SetError(testQuery, errors.New("prometheus unavailable"))
+	if _, err := a.Decide(ctx, "default/web", newTestSpec(1, 3), 1); err == nil {
+		t.Error("Decide() succeeded without the metric, want an error")
+	}
+
+	metrics.SetValue(testQuery, 10)
+	spec := newTestSpec(1, 3)
+	spec.Metric.TargetAverageValue = resource.MustParse("0")
+	if _, err := a.Decide(ctx, "default/web", spec, 1); err == nil {
+		t.Error("Decide() succeeded with a zero target, want an error")
+	}
+}
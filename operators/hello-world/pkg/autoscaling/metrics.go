@@ -0,0 +1,72 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package autoscaling
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// MetricsSource reads the current value of metrics.
+type MetricsSource interface {
+	MetricValue(ctx context.Context, query string) (float64, error)
+}
+
+// FakeSource is an in-memory MetricsSource, for tests.
+type FakeSource struct {
+	mu     sync.Mutex
+	values map[string]float64
+	errs   map[string]error
+}
+
+var _ MetricsSource = &FakeSource{}
+
+// SetValue makes the query return the value.
+func (s *FakeSource) SetValue(query string, value float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.values == nil {
+		s.values = map[string]float64{}
+	}
+	delete(s.errs, query)
+	s.values[query] = value
+}
+
+// SetError makes the query fail with err.
+func (s *FakeSource) SetError(query string, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.errs == nil {
+		s.errs = map[string]error{}
+	}
+	s.errs[query] = err
+}
+
+// MetricValue implements MetricsSource. Unknown queries fail.
+func (s *FakeSource) MetricValue(_ context.Context, query string) (float64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err, ok := s.errs[query]; ok {
+		return 0, err
+	}
+	value, ok := s.values[query]
+	if !ok {
+		return 0, fmt.Errorf("no value for query %q", query)
+	}
+	return value, nil
+}
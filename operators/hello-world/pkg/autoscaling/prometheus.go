@@ -0,0 +1,72 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package autoscaling
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/prometheus/client_golang/api"
+	promv1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
+)
+
+// PrometheusSource is a MetricsSource running instant queries against a
+// Prometheus server.
+type PrometheusSource struct {
+	api promv1.API
+}
+
+var _ MetricsSource = &PrometheusSource{}
+
+// NewPrometheusSource returns a PrometheusSource querying the Prometheus
+// server at address, e.g. "http://prometheus.monitoring:9090".
+func NewPrometheusSource(address string) (*PrometheusSource, error) {
+	client, err := api.NewClient(api.Config{Address: address})
+	if err != nil {
+		return nil, fmt.Errorf("creating Prometheus client: %w", err)
+	}
+	return &PrometheusSource{api: promv1.NewAPI(client)}, nil
+}
+
+// MetricValue implements MetricsSource. The query must return a scalar or a
+// vector of exactly one sample.
+func (s *PrometheusSource) MetricValue(ctx context.Context, query string) (float64, error) {
+	result, _, err := s.api.Query(ctx, query, time.Now())
+	if err != nil {
+		return 0, fmt.Errorf("querying Prometheus: %w", err)
+	}
+
+	var value model.SampleValue
+	switch result := result.(type) {
+	case *model.Scalar:
+		value = result.Value
+	case model.Vector:
+		if len(result) != 1 {
+			return 0, fmt.Errorf("query %q returned %d samples, expected exactly one", query, len(result))
+		}
+		value = result[0].Value
+	default:
+		return 0, fmt.Errorf("query %q returned a %s, expected a scalar or a vector", query, result.Type())
+	}
+	if math.IsNaN(float64(value)) || math.IsInf(float64(value), 0) {
+		return 0, fmt.Errorf("query %q returned %v", query, value)
+	}
+	return float64(value), nil
+}
@@ -0,0 +1,87 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package autoscaling
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// newFakePrometheus serves the instant queries of the Prometheus HTTP API,
+// answering each query with the data it maps to.
+func newFakePrometheus(results map[string]string) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/query", func(w http.ResponseWriter, req *http.Request) {
+		if err := req.ParseForm(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		data, ok := results[req.Form.Get("query")]
+		if !ok {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"status":"error","errorType":"bad_data","error":"parse error"}`)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"status":"success","data":%s}`, data)
+	})
+	return httptest.NewServer(mux)
+}
+
+func TestPrometheusSource(t *testing.T) {
+	server := newFakePrometheus(map[string]string{
+		"scalar":      `{"resultType":"scalar","result":[1600000000,"42"]}`,
+		"vector":      `{"resultType":"vector","result":[{"metric":{},"value":[1600000000,"12.5"]}]}`,
+		"empty":       `{"resultType":"vector","result":[]}`,
+		"two-samples": `{"resultType":"vector","result":[{"metric":{"pod":"a"},"value":[1600000000,"1"]},{"metric":{"pod":"b"},"value":[1600000000,"2"]}]}`,
+		"matrix":      `{"resultType":"matrix","result":[]}`,
+		"nan":         `{"resultType":"scalar","result":[1600000000,"NaN"]}`,
+	})
+	defer server.Close()
+	source, err := NewPrometheusSource(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		query   string
+		want    float64
+		wantErr bool
+	}{
+		{query: "scalar", want: 42},
+		{query: "vector", want: 12.5},
+		{query: "empty", wantErr: true},
+		{query: "two-samples", wantErr: true},
+		{query: "matrix", wantErr: true},
+		{query: "nan", wantErr: true},
+		{query: "unknown", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			got, err := source.MetricValue(context.Background(), tt.query)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("MetricValue() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("MetricValue() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,120 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package autoscaling computes the replicas of a workload from a metric,
+// the way a HorizontalPodAutoscaler would, so that the operator can scale
+// its workloads without a separate HorizontalPodAutoscaler object.
+package autoscaling
+
+import (
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// DefaultScaleUpStabilizationWindowSeconds scales up as soon as the
+	// metric asks for it.
+	DefaultScaleUpStabilizationWindowSeconds = 0
+	// DefaultScaleDownStabilizationWindowSeconds holds the replicas for five
+	// minutes after the metric last asked for them.
+	DefaultScaleDownStabilizationWindowSeconds = 300
+)
+
+// Spec configures the autoscaler of a workload.
+// +kubebuilder:object:generate=true
+type Spec struct {
+	// MinReplicas is the lower limit for the number of replicas. Defaults
+	// to 1.
+	// +optional
+	// +kubebuilder:default=1
+	// +kubebuilder:validation:Minimum=0
+	MinReplicas *int32 `json:"minReplicas,omitempty"`
+
+	// MaxReplicas is the upper limit for the number of replicas. It cannot
+	// be lower than MinReplicas.
+	// +kubebuilder:validation:Minimum=1
+	MaxReplicas int32 `json:"maxReplicas"`
+
+	// Metric is the metric the replicas are computed from.
+	Metric Metric `json:"metric"`
+
+	// ScaleUp configures how fast the replicas go up. The stabilization
+	// window defaults to 0 seconds.
+	// +optional
+	ScaleUp *ScalingRules `json:"scaleUp,omitempty"`
+
+	// ScaleDown configures how fast the replicas go down. The stabilization
+	// window defaults to 300 seconds.
+	// +optional
+	ScaleDown *ScalingRules `json:"scaleDown,omitempty"`
+}
+
+// Metric is a metric of the whole workload and its target value per replica.
+type Metric struct {
+	// Query is the Prometheus query returning the current value of the
+	// metric for the whole workload, as a scalar or a single sample, e.g.
+	// sum(rate(http_requests_total{namespace="default",service="web"}[2m])).
+	// +kubebuilder:validation:MinLength=1
+	Query string `json:"query"`
+
+	// TargetAverageValue is the value of the metric each replica should
+	// handle. The desired replicas are the value of the metric divided by
+	// it, rounded up.
+	TargetAverageValue resource.Quantity `json:"targetAverageValue"`
+}
+
+// ScalingRules configures scaling in one direction.
+type ScalingRules struct {
+	// StabilizationWindowSeconds is the number of seconds for which past
+	// recommendations are considered. Scaling up uses the lowest
+	// recommendation over the window, scaling down the highest, which
+	// avoids flapping when the metric fluctuates.
+	// +optional
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=3600
+	StabilizationWindowSeconds *int32 `json:"stabilizationWindowSeconds,omitempty"`
+}
+
+// Status is the last decision of the autoscaler.
+// +kubebuilder:object:generate=true
+type Status struct {
+	// CurrentMetricValue is the value of the metric last read.
+	// +optional
+	CurrentMetricValue *resource.Quantity `json:"currentMetricValue,omitempty"`
+
+	// DesiredReplicas is the number of replicas last computed.
+	DesiredReplicas int32 `json:"desiredReplicas"`
+
+	// LastScaleTime is the last time the autoscaler changed the replicas.
+	// +optional
+	LastScaleTime *metav1.Time `json:"lastScaleTime,omitempty"`
+}
+
+// minReplicas returns the lower limit for the replicas, defaulting to 1.
+func (s *Spec) minReplicas() int32 {
+	if s.MinReplicas == nil {
+		return 1
+	}
+	return *s.MinReplicas
+}
+
+// stabilizationWindowSeconds returns the window of the rules, or def.
+func (r *ScalingRules) stabilizationWindowSeconds(def int32) int32 {
+	if r == nil || r.StabilizationWindowSeconds == nil {
+		return def
+	}
+	return *r.StabilizationWindowSeconds
+}
@@ -0,0 +1,64 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package autoscaling
+
+import (
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+// Validate returns the errors of the spec found at fldPath, on top of what
+// the CRD schema already checks.
+func (s *Spec) Validate(fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	if s.MaxReplicas < 1 {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("maxReplicas"), s.MaxReplicas, "must be greater than or equal to 1"))
+	}
+	if s.MinReplicas != nil {
+		if *s.MinReplicas < 0 {
+			allErrs = append(allErrs, field.Invalid(fldPath.Child("minReplicas"), *s.MinReplicas, "must be greater than or equal to 0"))
+		} else if *s.MinReplicas > s.MaxReplicas {
+			allErrs = append(allErrs, field.Invalid(fldPath.Child("minReplicas"), *s.MinReplicas,
+				fmt.Sprintf("must be less than or equal to maxReplicas (%d)", s.MaxReplicas)))
+		}
+	}
+	if s.Metric.Query == "" {
+		allErrs = append(allErrs, field.Required(fldPath.Child("metric", "query"), ""))
+	}
+	if s.Metric.TargetAverageValue.Sign() <= 0 {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("metric", "targetAverageValue"), s.Metric.TargetAverageValue.String(), "must be positive"))
+	}
+	allErrs = append(allErrs, s.ScaleUp.validate(fldPath.Child("scaleUp"))...)
+	allErrs = append(allErrs, s.ScaleDown.validate(fldPath.Child("scaleDown"))...)
+	return allErrs
+}
+
+// maxStabilizationWindowSeconds is the longest stabilization window, an
+// hour as for the HorizontalPodAutoscaler.
+const maxStabilizationWindowSeconds = 3600
+
+func (r *ScalingRules) validate(fldPath *field.Path) field.ErrorList {
+	if r == nil || r.StabilizationWindowSeconds == nil {
+		return nil
+	}
+	if window := *r.StabilizationWindowSeconds; window < 0 || window > maxStabilizationWindowSeconds {
+		return field.ErrorList{field.Invalid(fldPath.Child("stabilizationWindowSeconds"), window,
+			fmt.Sprintf("must be between 0 and %d", maxStabilizationWindowSeconds))}
+	}
+	return nil
+}
@@ -0,0 +1,63 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package autoscaling
+
+import (
+	"testing"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		mutate func(*Spec)
+		want   []string
+	}{
+		{name: "valid", mutate: func(*Spec) {}},
+		{name: "min equals max", mutate: func(s *Spec) { s.MinReplicas = int32Ptr(3) }},
+		{name: "scale to zero", mutate: func(s *Spec) { s.MinReplicas = int32Ptr(0) }},
+		{name: "min over max", mutate: func(s *Spec) { s.MinReplicas = int32Ptr(4) }, want: []string{"spec.autoscaling.minReplicas"}},
+		{name: "no max", mutate: func(s *Spec) { s.MinReplicas, s.MaxReplicas = nil, 0 }, want: []string{"spec.autoscaling.maxReplicas"}},
+		{name: "no query", mutate: func(s *Spec) { s.Metric.Query = "" }, want: []string{"spec.autoscaling.metric.query"}},
+		{name: "zero target", mutate: func(s *Spec) { s.Metric.TargetAverageValue = resource.Quantity{} }, want: []string{"spec.autoscaling.metric.targetAverageValue"}},
+		{name: "negative target", mutate: func(s *Spec) { s.Metric.TargetAverageValue = resource.MustParse("-1") }, want: []string{"spec.autoscaling.metric.targetAverageValue"}},
+		{name: "window too long", mutate: func(s *Spec) {
+			s.ScaleUp = &ScalingRules{StabilizationWindowSeconds: int32Ptr(0)}
+			s.ScaleDown = &ScalingRules{StabilizationWindowSeconds: int32Ptr(3601)}
+		}, want: []string{"spec.autoscaling.scaleDown.stabilizationWindowSeconds"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spec := newTestSpec(1, 3)
+			tt.mutate(spec)
+			var got []string
+			for _, err := range spec.Validate(field.NewPath("spec", "autoscaling")) {
+				got = append(got, err.Field)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("Validate() errors on %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("Validate() errors on %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,344 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package webhooks holds the admission webhooks of CustomDeployment. They
+// live outside of the API package so that its types carry no settings.
+package webhooks
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	ctrl "sigs.k8s.io/controller-runtime"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	ctrlmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	demov1alpha1 "github.com/mbtamuli/hello-world/api/v1alpha1"
+	"github.com/mbtamuli/k8s/common/imagepolicy"
+	"github.com/mbtamuli/k8s/common/logging"
+	"github.com/mbtamuli/k8s/common/metrics"
+)
+
+// log is for logging in this package.
+var customdeploymentlog = logf.Log.WithName("customdeployment-resource")
+
+// imagePolicyTimeout bounds the time spent reading the image policy and
+// resolving digests, well within the 10s admission timeout.
+const imagePolicyTimeout = 5 * time.Second
+
+// Paths the webhooks are served on, as generated for the markers below.
+const (
+	mutateCustomDeploymentPath   = "/mutate-demo-mriyam-dev-v1alpha1-customdeployment"
+	validateCustomDeploymentPath = "/validate-demo-mriyam-dev-v1alpha1-customdeployment"
+)
+
+// CustomDeploymentWebhook defaults and validates CustomDeployments, enforcing
+// the image policy.
+type CustomDeploymentWebhook struct {
+	// DefaultImage is the image of the CustomDeployments and containers
+	// that do not name one
+	DefaultImage string
+	// ImagePolicy is the image policy the images are checked against
+	ImagePolicy imagepolicy.Source
+	// Resolver pins image tags to digests when the image policy asks for it
+	Resolver imagepolicy.Resolver
+
+	decoder    *admission.Decoder
+	admissions *metrics.Admissions
+}
+
+// SetupWithManager registers the defaulting and validating webhooks of
+// CustomDeployment with the manager, along with the conversion webhook.
+func (w *CustomDeploymentWebhook) SetupWithManager(mgr ctrl.Manager) error {
+	decoder, err := admission.NewDecoder(mgr.GetScheme())
+	if err != nil {
+		return err
+	}
+	w.decoder = decoder
+	// The requests are counted where they are served, registering the
+	// collectors along with the handlers
+	w.admissions = metrics.NewAdmissions("customdeployment", "CustomDeployment")
+	for _, c := range w.admissions.Collectors() {
+		if err := ctrlmetrics.Registry.Register(c); err != nil {
+			return err
+		}
+	}
+
+	server := mgr.GetWebhookServer()
+	server.Register(mutateCustomDeploymentPath, &webhook.Admission{Handler: admission.HandlerFunc(w.handleDefault)})
+	server.Register(validateCustomDeploymentPath, &webhook.Admission{Handler: admission.HandlerFunc(w.handleValidate)})
+	// CustomDeployment is neither a Defaulter nor a Validator, only the
+	// conversion webhook is registered
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(&demov1alpha1.CustomDeployment{}).
+		Complete()
+}
+
+// +kubebuilder:webhook:path=/mutate-demo-mriyam-dev-v1alpha1-customdeployment,mutating=true,failurePolicy=fail,sideEffects=None,groups=demo.mriyam.dev,resources=customdeployments,verbs=create;update,versions=v1alpha1,name=mcustomdeployment.kb.io,admissionReviewVersions={v1,v1beta1}
+
+// handleDefault responds to the requests of the mutating webhook with a
+// patch applying the defaults.
+func (w *CustomDeploymentWebhook) handleDefault(ctx context.Context, req admission.Request) admission.Response {
+	cd := &demov1alpha1.CustomDeployment{}
+	if err := w.decoder.Decode(req, cd); err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+	var old *demov1alpha1.CustomDeployment
+	if req.Operation == admissionv1.Update {
+		old = &demov1alpha1.CustomDeployment{}
+		if err := w.decoder.DecodeRaw(req.OldObject, old); err != nil {
+			return admission.Errored(http.StatusBadRequest, err)
+		}
+	}
+	if err := w.Default(ctx, old, cd); err != nil {
+		return admission.Denied(err.Error())
+	}
+	marshalled, err := json.Marshal(cd)
+	if err != nil {
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+	return admission.PatchResponseFromRaw(req.Object.Raw, marshalled)
+}
+
+// Default applies the defaults to the CustomDeployment, old is the stored
+// CustomDeployment on UPDATE and nil on CREATE. Replicas are defaulted by the
+// CRD schema, since an omitted int cannot be told apart from 0 here.
+//
+// The default image is used when Spec.Image is needed but empty, and for the
+// containers without an image. Image references without a tag or digest are
+// pinned to an explicit ":latest", then to a digest when the image policy
+// asks for it. Only the images old does not run are resolved, so an update
+// does not roll out newer digests of unchanged tags. An error is returned
+// when an image cannot be resolved, rather than running the tag the policy
+// asks to pin. Containers running a latest image are pulled Always so the
+// tag is resolved again on every start. Malformed references are left for
+// the validating webhook to report.
+func (w *CustomDeploymentWebhook) Default(ctx context.Context, old, cd *demov1alpha1.CustomDeployment) error {
+	customdeploymentlog.V(logging.Debug).Info("default", "namespace", cd.Namespace, "name", cd.Name)
+
+	ctx, cancel := context.WithTimeout(ctx, imagePolicyTimeout)
+	defer cancel()
+	policy, err := w.ImagePolicy.ImagePolicy(ctx)
+	if err != nil {
+		// The validating webhook rejects the object then
+		customdeploymentlog.Error(err, "unable to read the image policy", "namespace", cd.Namespace, "name", cd.Name)
+	}
+	var storedImages map[string]bool
+	if old != nil {
+		storedImages = images(old)
+	}
+
+	if cd.Spec.Image == "" && cd.Spec.Template == nil {
+		cd.Spec.Image = w.DefaultImage
+	}
+	if cd.Spec.Image, err = w.defaultImage(ctx, policy, storedImages, cd.Spec.Image); err != nil {
+		return err
+	}
+	if cd.Spec.Template == nil {
+		return nil
+	}
+	podSpec := &cd.Spec.Template.Spec
+	for i := range podSpec.InitContainers {
+		if err := w.defaultContainer(ctx, policy, storedImages, &podSpec.InitContainers[i]); err != nil {
+			return err
+		}
+	}
+	for i := range podSpec.Containers {
+		if err := w.defaultContainer(ctx, policy, storedImages, &podSpec.Containers[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// defaultContainer defaults the image of the container and its pull policy
+// accordingly.
+func (w *CustomDeploymentWebhook) defaultContainer(ctx context.Context, policy *imagepolicy.Policy, storedImages map[string]bool, container *corev1.Container) error {
+	if container.Image == "" {
+		container.Image = w.DefaultImage
+	}
+	image, err := w.defaultImage(ctx, policy, storedImages, container.Image)
+	if err != nil {
+		return err
+	}
+	container.Image = image
+	if container.ImagePullPolicy != "" {
+		return nil
+	}
+	ref, err := imagepolicy.ParseReference(container.Image)
+	if err != nil {
+		return nil
+	}
+	if ref.IsLatest() {
+		container.ImagePullPolicy = corev1.PullAlways
+	} else {
+		container.ImagePullPolicy = corev1.PullIfNotPresent
+	}
+	return nil
+}
+
+// defaultImage makes the implicit ":latest" tag of the image explicit, and
+// pins the image to a digest if the policy asks for it and storedImages does
+// not have it.
+func (w *CustomDeploymentWebhook) defaultImage(ctx context.Context, policy *imagepolicy.Policy, storedImages map[string]bool, image string) (string, error) {
+	ref, err := imagepolicy.ParseReference(image)
+	if err != nil {
+		return image, nil
+	}
+	if ref.Tag == "" && ref.Digest == "" {
+		image += ":latest"
+	}
+	pinned, err := policy.PinChanged(ctx, w.Resolver, image, storedImages)
+	if err != nil {
+		return "", fmt.Errorf("the image policy requires pinning images to a digest: %w", err)
+	}
+	return pinned, nil
+}
+
+// +kubebuilder:webhook:path=/validate-demo-mriyam-dev-v1alpha1-customdeployment,mutating=false,failurePolicy=fail,sideEffects=None,groups=demo.mriyam.dev,resources=customdeployments,verbs=create;update,versions=v1alpha1,name=vcustomdeployment.kb.io,admissionReviewVersions={v1,v1beta1}
+
+// handleValidate responds to the requests of the validating webhook.
+func (w *CustomDeploymentWebhook) handleValidate(ctx context.Context, req admission.Request) admission.Response {
+	cd := &demov1alpha1.CustomDeployment{}
+	if err := w.decoder.Decode(req, cd); err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+	var err error
+	switch req.Operation {
+	case admissionv1.Create:
+		err = w.ValidateCreate(ctx, cd)
+		w.admissions.Reviewed("create", err)
+	case admissionv1.Update:
+		old := &demov1alpha1.CustomDeployment{}
+		if err := w.decoder.DecodeRaw(req.OldObject, old); err != nil {
+			return admission.Errored(http.StatusBadRequest, err)
+		}
+		err = w.ValidateUpdate(ctx, old, cd)
+		w.admissions.Reviewed("update", err)
+	}
+	return validationResponse(err)
+}
+
+// ValidateCreate returns an Invalid error listing every problem with a new
+// CustomDeployment, or nil.
+func (w *CustomDeploymentWebhook) ValidateCreate(ctx context.Context, cd *demov1alpha1.CustomDeployment) error {
+	customdeploymentlog.V(logging.Debug).Info("validate create", "namespace", cd.Namespace, "name", cd.Name)
+
+	return w.validateCustomDeployment(ctx, cd, nil)
+}
+
+// ValidateUpdate returns an Invalid error listing every problem with an
+// updated CustomDeployment, or nil.
+func (w *CustomDeploymentWebhook) ValidateUpdate(ctx context.Context, old, cd *demov1alpha1.CustomDeployment) error {
+	customdeploymentlog.V(logging.Debug).Info("validate update", "namespace", cd.Namespace, "name", cd.Name)
+
+	return w.validateCustomDeployment(ctx, cd, images(old))
+}
+
+// validateCustomDeployment returns an Invalid error listing every problem
+// with the spec, or nil. Images in oldImages were admitted before, they are
+// not checked against the image policy again so that a stricter policy does
+// not block unrelated changes. The controller reports them instead.
+func (w *CustomDeploymentWebhook) validateCustomDeployment(ctx context.Context, cd *demov1alpha1.CustomDeployment, oldImages map[string]bool) error {
+	ctx, cancel := context.WithTimeout(ctx, imagePolicyTimeout)
+	defer cancel()
+	policy, err := w.ImagePolicy.ImagePolicy(ctx)
+	if err != nil {
+		return apierrors.NewInternalError(fmt.Errorf("reading the image policy: %w", err))
+	}
+	validateImage := func(image string, fldPath *field.Path) field.ErrorList {
+		if image == "" {
+			return field.ErrorList{field.Required(fldPath, "")}
+		}
+		if _, err := imagepolicy.ParseReference(image); err != nil {
+			return field.ErrorList{field.Invalid(fldPath, image, err.Error())}
+		}
+		if err := policy.Check(image); err != nil && !oldImages[image] {
+			return field.ErrorList{field.Forbidden(fldPath, err.Error())}
+		}
+		return nil
+	}
+
+	var allErrs field.ErrorList
+	specPath := field.NewPath("spec")
+
+	if cd.Spec.Replicas < 0 {
+		allErrs = append(allErrs, field.Invalid(specPath.Child("replicas"), cd.Spec.Replicas, "must be greater than or equal to 0"))
+	}
+	if cd.Spec.Autoscaling != nil {
+		allErrs = append(allErrs, cd.Spec.Autoscaling.Validate(specPath.Child("autoscaling"))...)
+	}
+
+	// Spec.Image is only used when the template has no containers
+	podSpec := cd.Spec.PodTemplate().Spec
+	if len(podSpec.Containers) == 0 || cd.Spec.Image != "" {
+		allErrs = append(allErrs, validateImage(cd.Spec.Image, specPath.Child("image"))...)
+	}
+	containersPath := specPath.Child("template", "spec", "containers")
+	for i, c := range podSpec.Containers {
+		allErrs = append(allErrs, validateImage(c.Image, containersPath.Index(i).Child("image"))...)
+	}
+	initContainersPath := specPath.Child("template", "spec", "initContainers")
+	for i, c := range podSpec.InitContainers {
+		allErrs = append(allErrs, validateImage(c.Image, initContainersPath.Index(i).Child("image"))...)
+	}
+
+	if len(allErrs) == 0 {
+		return nil
+	}
+	return apierrors.NewInvalid(demov1alpha1.GroupVersion.WithKind("CustomDeployment").GroupKind(), cd.Name, allErrs)
+}
+
+// images returns the images the CustomDeployment may run.
+func images(cd *demov1alpha1.CustomDeployment) map[string]bool {
+	images := map[string]bool{}
+	if cd.Spec.Image != "" {
+		images[cd.Spec.Image] = true
+	}
+	podSpec := cd.Spec.PodTemplate().Spec
+	for _, c := range podSpec.InitContainers {
+		images[c.Image] = true
+	}
+	for _, c := range podSpec.Containers {
+		images[c.Image] = true
+	}
+	return images
+}
+
+// validationResponse allows the request when err is nil, and denies it with
+// the status of err otherwise, the way controller-runtime does for the
+// webhook.Validator types.
+func validationResponse(err error) admission.Response {
+	if err == nil {
+		return admission.Allowed("")
+	}
+	var apiStatus apierrors.APIStatus
+	if errors.As(err, &apiStatus) {
+		status := apiStatus.Status()
+		return admission.Response{AdmissionResponse: admissionv1.AdmissionResponse{Allowed: false, Result: &status}}
+	}
+	return admission.Denied(err.Error())
+}
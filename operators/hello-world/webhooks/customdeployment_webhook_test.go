@@ -0,0 +1,250 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhooks
+
+import (
+	"context"
+	"errors"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	demov1alpha1 "github.com/mbtamuli/hello-world/api/v1alpha1"
+	"github.com/mbtamuli/k8s/common/imagepolicy"
+)
+
+var _ = Describe("CustomDeployment webhooks", func() {
+	const namespace = "default"
+
+	newCustomDeployment := func(name string, mutate func(*demov1alpha1.CustomDeployment)) *demov1alpha1.CustomDeployment {
+		cd := &demov1alpha1.CustomDeployment{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+			Spec:       demov1alpha1.CustomDeploymentSpec{Replicas: 1, Image: "nginx:1.21"},
+		}
+		if mutate != nil {
+			mutate(cd)
+		}
+		return cd
+	}
+
+	DescribeTable("defaults the admitted object",
+		func(cd *demov1alpha1.CustomDeployment, check func(*demov1alpha1.CustomDeployment)) {
+			Expect(k8sClient.Create(ctx, cd)).To(Succeed())
+			admitted := &demov1alpha1.CustomDeployment{}
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: cd.Name, Namespace: namespace}, admitted)).To(Succeed())
+			check(admitted)
+		},
+		Entry("pins the implicit latest tag", newCustomDeployment("pin-image", func(cd *demov1alpha1.CustomDeployment) {
+			cd.Spec.Image = "nginx"
+		}), func(cd *demov1alpha1.CustomDeployment) {
+			Expect(cd.Spec.Image).To(Equal("nginx:latest"))
+		}),
+		Entry("keeps explicit tags and digests", newCustomDeployment("keep-image", func(cd *demov1alpha1.CustomDeployment) {
+			cd.Spec.Template = podTemplate(
+				corev1.Container{Name: "tagged", Image: "registry.example.com:5000/team/nginx:1.21"},
+				corev1.Container{Name: "digest", Image: "nginx@sha256:0d17b565c37bcbd895e9d92315a05c1c3c9a29f762b011a10c54a66cd53c9b31"},
+			)
+		}), func(cd *demov1alpha1.CustomDeployment) {
+			containers := cd.Spec.Template.Spec.Containers
+			Expect(containers[0].Image).To(Equal("registry.example.com:5000/team/nginx:1.21"))
+			Expect(containers[0].ImagePullPolicy).To(Equal(corev1.PullIfNotPresent))
+			Expect(containers[1].Image).To(Equal("nginx@sha256:0d17b565c37bcbd895e9d92315a05c1c3c9a29f762b011a10c54a66cd53c9b31"))
+			Expect(containers[1].ImagePullPolicy).To(Equal(corev1.PullIfNotPresent))
+		}),
+		Entry("pulls latest images Always", newCustomDeployment("pull-latest", func(cd *demov1alpha1.CustomDeployment) {
+			cd.Spec.Template = podTemplate(
+				corev1.Container{Name: "latest", Image: "nginx:latest"},
+				corev1.Container{Name: "explicit", Image: "nginx", ImagePullPolicy: corev1.PullNever},
+			)
+			cd.Spec.Template.Spec.InitContainers = []corev1.Container{{Name: "init", Image: "busybox"}}
+		}), func(cd *demov1alpha1.CustomDeployment) {
+			podSpec := cd.Spec.Template.Spec
+			Expect(podSpec.InitContainers[0].Image).To(Equal("busybox:latest"))
+			Expect(podSpec.InitContainers[0].ImagePullPolicy).To(Equal(corev1.PullAlways))
+			Expect(podSpec.Containers[0].ImagePullPolicy).To(Equal(corev1.PullAlways))
+			Expect(podSpec.Containers[1].Image).To(Equal("nginx:latest"))
+			Expect(podSpec.Containers[1].ImagePullPolicy).To(Equal(corev1.PullNever))
+		}),
+	)
+
+	It("defaults omitted replicas to 1", func() {
+		cd := newCustomDeployment("default-replicas", nil)
+		// Typed clients always send replicas, so leave it out explicitly
+		patch := []byte(`{"apiVersion":"demo.mriyam.dev/v1alpha1","kind":"CustomDeployment",` +
+			`"metadata":{"name":"default-replicas","namespace":"default"},"spec":{"image":"nginx:1.21"}}`)
+		Expect(k8sClient.Patch(ctx, cd, client.RawPatch(types.ApplyPatchType, patch), client.FieldOwner("test"))).To(Succeed())
+		Expect(cd.Spec.Replicas).To(Equal(1))
+	})
+
+	DescribeTable("rejects invalid objects",
+		func(cd *demov1alpha1.CustomDeployment, path string) {
+			err := k8sClient.Create(ctx, cd)
+			Expect(apierrors.IsInvalid(err)).To(BeTrue(), "expected an Invalid error, got %v", err)
+			Expect(err.Error()).To(ContainSubstring(path))
+		},
+		Entry("negative replicas", newCustomDeployment("negative-replicas", func(cd *demov1alpha1.CustomDeployment) {
+			cd.Spec.Replicas = -1
+		}), "spec.replicas"),
+		Entry("no image and no containers", newCustomDeployment("no-image", func(cd *demov1alpha1.CustomDeployment) {
+			cd.Spec.Image = ""
+		}), "spec.image"),
+		Entry("malformed image", newCustomDeployment("malformed-image", func(cd *demov1alpha1.CustomDeployment) {
+			cd.Spec.Image = "Nginx:1.21"
+		}), "spec.image"),
+		Entry("malformed container image", newCustomDeployment("malformed-container-image", func(cd *demov1alpha1.CustomDeployment) {
+			cd.Spec.Template = podTemplate(corev1.Container{Name: "app", Image: "nginx:"})
+		}), "spec.template.spec.containers[0].image"),
+		Entry("empty init container image", newCustomDeployment("empty-init-image", func(cd *demov1alpha1.CustomDeployment) {
+			cd.Spec.Template = podTemplate(corev1.Container{Name: "app", Image: "nginx:1.21"})
+			cd.Spec.Template.Spec.InitContainers = []corev1.Container{{Name: "init"}}
+		}), "spec.template.spec.initContainers[0].image"),
+		Entry("template without containers", newCustomDeployment("no-containers", func(cd *demov1alpha1.CustomDeployment) {
+			cd.Spec.Template = podTemplate()
+			cd.Spec.Template.Labels = map[string]string{"app": "web"}
+		}), "spec.template.spec.containers"),
+	)
+
+	It("validates the template against the pod schema", func() {
+		cd := newCustomDeployment("template-schema", nil)
+		patch := []byte(`{"apiVersion":"demo.mriyam.dev/v1alpha1","kind":"CustomDeployment",` +
+			`"metadata":{"name":"template-schema","namespace":"default"},"spec":{"template":{"spec":{"containers":[` +
+			`{"name":"app","image":"nginx:1.21","ports":[{"containerPort":"http"}]}]}}}}`)
+		err := k8sClient.Patch(ctx, cd, client.RawPatch(types.ApplyPatchType, patch), client.FieldOwner("test"))
+		Expect(apierrors.IsInvalid(err)).To(BeTrue(), "expected an Invalid error, got %v", err)
+		Expect(err.Error()).To(ContainSubstring("spec.template.spec.containers[0].ports[0].containerPort"))
+	})
+
+	It("rejects invalid updates", func() {
+		cd := newCustomDeployment("invalid-update", nil)
+		Expect(k8sClient.Create(ctx, cd)).To(Succeed())
+
+		cd.Spec.Replicas = -3
+		err := k8sClient.Update(ctx, cd)
+		Expect(apierrors.IsInvalid(err)).To(BeTrue(), "expected an Invalid error, got %v", err)
+		Expect(err.Error()).To(ContainSubstring("spec.replicas"))
+	})
+
+	Context("with a cluster image policy", func() {
+		const digest = "sha256:0d17b565c37bcbd895e9d92315a05c1c3c9a29f762b011a10c54a66cd53c9b31"
+		var policy *demov1alpha1.ImagePolicy
+		var resolver imagepolicy.Resolver
+
+		BeforeEach(func() {
+			policy = &demov1alpha1.ImagePolicy{
+				ObjectMeta: metav1.ObjectMeta{Name: demov1alpha1.ClusterImagePolicyName},
+				Spec: imagepolicy.Policy{
+					AllowedRegistries: []string{"docker.io/library", "ghcr.io"},
+					ForbidLatest:      true,
+					ResolveDigests:    true,
+				},
+			}
+			Expect(k8sClient.Create(ctx, policy)).To(Succeed())
+
+			// Stand in for the registry, ghcr.io does not know the image
+			resolver = customDeploymentWebhook.Resolver
+			customDeploymentWebhook.Resolver = imagepolicy.ResolverFunc(func(_ context.Context, ref imagepolicy.Reference) (string, error) {
+				if ref.Domain != "docker.io" {
+					return "", errors.New("manifest unknown")
+				}
+				return digest, nil
+			})
+		})
+
+		AfterEach(func() {
+			customDeploymentWebhook.Resolver = resolver
+			Expect(k8sClient.Delete(ctx, policy)).To(Succeed())
+		})
+
+		It("pins admitted images to their digest", func() {
+			cd := newCustomDeployment("policy-pin", func(cd *demov1alpha1.CustomDeployment) {
+				cd.Spec.Template = podTemplate(corev1.Container{Name: "app", Image: "nginx:1.21"})
+			})
+			Expect(k8sClient.Create(ctx, cd)).To(Succeed())
+			Expect(cd.Spec.Image).To(Equal("nginx:1.21@" + digest))
+			containers := cd.Spec.Template.Spec.Containers
+			Expect(containers[0].Image).To(Equal("nginx:1.21@" + digest))
+			Expect(containers[0].ImagePullPolicy).To(Equal(corev1.PullIfNotPresent))
+		})
+
+		It("rejects images it cannot pin", func() {
+			cd := newCustomDeployment("policy-unresolved", func(cd *demov1alpha1.CustomDeployment) {
+				cd.Spec.Template = podTemplate(corev1.Container{Name: "app", Image: "ghcr.io/example/app:1.0"})
+			})
+			err := k8sClient.Create(ctx, cd)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("ghcr.io/example/app:1.0"))
+		})
+
+		It("keeps the digests of the unchanged images on UPDATE", func() {
+			cd := newCustomDeployment("policy-repin", func(cd *demov1alpha1.CustomDeployment) {
+				cd.Spec.Image = "nginx:1.21"
+			})
+			Expect(k8sClient.Create(ctx, cd)).To(Succeed())
+			Expect(cd.Spec.Image).To(Equal("nginx:1.21@" + digest))
+
+			// The tag moved on, and the manifest is applied again
+			customDeploymentWebhook.Resolver = imagepolicy.ResolverFunc(func(context.Context, imagepolicy.Reference) (string, error) {
+				return "sha256:1111111111111111111111111111111111111111111111111111111111111111", nil
+			})
+			cd.Spec.Image = "nginx:1.21"
+			cd.Spec.Replicas = 2
+			Expect(k8sClient.Update(ctx, cd)).To(Succeed())
+			Expect(cd.Spec.Image).To(Equal("nginx:1.21@" + digest))
+		})
+
+		DescribeTable("rejects images forbidden by the policy",
+			func(image, path string) {
+				cd := newCustomDeployment("policy-forbidden", func(cd *demov1alpha1.CustomDeployment) {
+					cd.Spec.Template = podTemplate(corev1.Container{Name: "app", Image: image})
+				})
+				err := k8sClient.Create(ctx, cd)
+				Expect(apierrors.IsInvalid(err)).To(BeTrue(), "expected an Invalid error, got %v", err)
+				Expect(err.Error()).To(ContainSubstring(path))
+			},
+			Entry("latest tag", "ghcr.io/example/app", "spec.template.spec.containers[0].image"),
+			Entry("registry not allowed", "quay.io/example/app:1.0", "spec.template.spec.containers[0].image"),
+			Entry("repository not allowed", "example/app:1.0", "spec.template.spec.containers[0].image"),
+		)
+
+		It("keeps admitting images that were admitted before the policy", func() {
+			cd := newCustomDeployment("policy-update", func(cd *demov1alpha1.CustomDeployment) {
+				cd.Spec.Image = "quay.io/example/app:1.0"
+			})
+			Expect(k8sClient.Delete(ctx, policy)).To(Succeed())
+			Expect(k8sClient.Create(ctx, cd)).To(Succeed())
+			policy.ResourceVersion = ""
+			Expect(k8sClient.Create(ctx, policy)).To(Succeed())
+
+			cd.Spec.Replicas = 2
+			Expect(k8sClient.Update(ctx, cd)).To(Succeed())
+			cd.Spec.Image = "quay.io/example/app:2.0"
+			Expect(apierrors.IsInvalid(k8sClient.Update(ctx, cd))).To(BeTrue())
+		})
+	})
+})
+
+// podTemplate returns a template running the given containers.
+func podTemplate(containers ...corev1.Container) *corev1.PodTemplateSpec {
+	return &corev1.PodTemplateSpec{Spec: corev1.PodSpec{Containers: containers}}
+}
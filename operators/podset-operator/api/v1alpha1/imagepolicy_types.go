@@ -0,0 +1,81 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"context"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/mbtamuli/k8s/common/imagepolicy"
+)
+
+// ClusterImagePolicyName is the name of the only ImagePolicy that is honoured.
+const ClusterImagePolicyName = "cluster"
+
+//+kubebuilder:object:root=true
+//+kubebuilder:resource:scope=Cluster
+
+// ImagePolicy restricts the images PodSets may run. Only the
+// ImagePolicy named "cluster" is honoured.
+type ImagePolicy struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec imagepolicy.Policy `json:"spec,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// ImagePolicyList contains a list of ImagePolicy
+type ImagePolicyList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []ImagePolicy `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&ImagePolicy{}, &ImagePolicyList{})
+}
+
+//+kubebuilder:object:generate=false
+
+// ClusterImagePolicySource is an imagepolicy.Source reading the cluster
+// ImagePolicy.
+type ClusterImagePolicySource struct {
+	Reader client.Reader
+	// Default is the policy in force when the cluster ImagePolicy does not
+	// exist
+	Default *imagepolicy.Policy
+}
+
+var _ imagepolicy.Source = ClusterImagePolicySource{}
+
+// ImagePolicy implements imagepolicy.Source. The default policy is in force
+// when the cluster ImagePolicy does not exist.
+func (s ClusterImagePolicySource) ImagePolicy(ctx context.Context) (*imagepolicy.Policy, error) {
+	policy := &ImagePolicy{}
+	if err := s.Reader.Get(ctx, client.ObjectKey{Name: ClusterImagePolicyName}, policy); err != nil {
+		if apierrors.IsNotFound(err) {
+			return s.Default, nil
+		}
+		return nil, err
+	}
+	return &policy.Spec, nil
+}
@@ -0,0 +1,73 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"fmt"
+
+	"sigs.k8s.io/controller-runtime/pkg/conversion"
+
+	"github.com/mbtamuli/k8s/podset-operator/api/v1beta1"
+)
+
+var _ conversion.Convertible = &PodSet{}
+
+// ConvertTo converts this PodSet to the Hub version (v1beta1).
+func (src *PodSet) ConvertTo(dstRaw conversion.Hub) error {
+	dst, ok := dstRaw.(*v1beta1.PodSet)
+	if !ok {
+		return fmt.Errorf("unsupported hub %T", dstRaw)
+	}
+
+	dst.ObjectMeta = *src.ObjectMeta.DeepCopy()
+	dst.Spec = v1beta1.PodSetSpec(*src.Spec.DeepCopy())
+
+	status := src.Status.DeepCopy()
+	dst.Status = v1beta1.PodSetStatus{
+		Replicas:           status.Replicas,
+		ReadyReplicas:      status.AvailableReplicas,
+		PodNames:           status.PodNames,
+		Phases:             v1beta1.PodPhaseCounts(status.Phases),
+		ObservedGeneration: status.ObservedGeneration,
+		Selector:           status.Selector,
+		Conditions:         status.Conditions,
+	}
+	return nil
+}
+
+// ConvertFrom converts from the Hub version (v1beta1) to this version.
+func (dst *PodSet) ConvertFrom(srcRaw conversion.Hub) error {
+	src, ok := srcRaw.(*v1beta1.PodSet)
+	if !ok {
+		return fmt.Errorf("unsupported hub %T", srcRaw)
+	}
+
+	dst.ObjectMeta = *src.ObjectMeta.DeepCopy()
+	dst.Spec = PodSetSpec(*src.Spec.DeepCopy())
+
+	status := src.Status.DeepCopy()
+	dst.Status = PodSetStatus{
+		Replicas:           status.Replicas,
+		AvailableReplicas:  status.ReadyReplicas,
+		PodNames:           status.PodNames,
+		Phases:             PodPhaseCounts(status.Phases),
+		ObservedGeneration: status.ObservedGeneration,
+		Selector:           status.Selector,
+		Conditions:         status.Conditions,
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"math/rand"
+	"testing"
+
+	fuzz "github.com/google/gofuzz"
+	"k8s.io/apimachinery/pkg/api/apitesting/fuzzer"
+	apiequality "k8s.io/apimachinery/pkg/api/equality"
+	metafuzzer "k8s.io/apimachinery/pkg/apis/meta/fuzzer"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/serializer"
+	"k8s.io/apimachinery/pkg/util/diff"
+
+	"github.com/mbtamuli/k8s/podset-operator/api/v1beta1"
+)
+
+const fuzzIterations = 1000
+
+func newConversionFuzzer(t *testing.T) *fuzz.Fuzzer {
+	t.Helper()
+	scheme := runtime.NewScheme()
+	if err := AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := v1beta1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	return fuzzer.FuzzerFor(metafuzzer.Funcs, rand.NewSource(rand.Int63()), serializer.NewCodecFactory(scheme))
+}
+
+func TestPodSetSpokeHubSpoke(t *testing.T) {
+	f := newConversionFuzzer(t)
+	for i := 0; i < fuzzIterations; i++ {
+		spoke := &PodSet{}
+		f.Fuzz(spoke)
+
+		hub := &v1beta1.PodSet{}
+		if err := spoke.DeepCopy().ConvertTo(hub); err != nil {
+			t.Fatalf("ConvertTo() error = %v", err)
+		}
+		got := &PodSet{}
+		if err := got.ConvertFrom(hub); err != nil {
+			t.Fatalf("ConvertFrom() error = %v", err)
+		}
+		if !apiequality.Semantic.DeepEqual(spoke, got) {
+			t.Fatalf("v1alpha1 changed by the round trip:\n%s", diff.ObjectReflectDiff(spoke, got))
+		}
+	}
+}
+
+func TestPodSetHubSpokeHub(t *testing.T) {
+	f := newConversionFuzzer(t)
+	for i := 0; i < fuzzIterations; i++ {
+		hub := &v1beta1.PodSet{}
+		f.Fuzz(hub)
+
+		spoke := &PodSet{}
+		if err := spoke.ConvertFrom(hub.DeepCopy()); err != nil {
+			t.Fatalf("ConvertFrom() error = %v", err)
+		}
+		got := &v1beta1.PodSet{}
+		if err := spoke.ConvertTo(got); err != nil {
+			t.Fatalf("ConvertTo() error = %v", err)
+		}
+		if !apiequality.Semantic.DeepEqual(hub, got) {
+			t.Fatalf("v1beta1 changed by the round trip:\n%s", diff.ObjectReflectDiff(hub, got))
+		}
+	}
+}
@@ -17,29 +17,97 @@ limitations under the License.
 package v1alpha1
 
 import (
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
-// EDIT THIS FILE!  THIS IS SCAFFOLDING FOR YOU TO OWN!
-// NOTE: json tags are required.  Any new fields you add must have json tags for the fields to be serialized.
+// PodSetLabel is set by the defaulting webhook on the pod template to the
+// name of the PodSet.
+const PodSetLabel = "app.mriyam.com/podset"
 
 // PodSetSpec defines the desired state of PodSet
 type PodSetSpec struct {
-	// INSERT ADDITIONAL SPEC FIELDS - desired state of cluster
-	// Important: Run "make" to regenerate code after modifying this file
+	// Replicas is the number of desired pods. Defaults to 1.
+	//+optional
+	//+kubebuilder:validation:Minimum=0
+	//+kubebuilder:validation:Maximum=1000
+	Replicas *int32 `json:"replicas,omitempty"`
 
-	// Foo is an example field of PodSet. Edit podset_types.go to remove/update
-	Foo string `json:"foo,omitempty"`
+	// Selector is a label query over the pods of the PodSet. It must match
+	// the template labels. Defaults to the template labels.
+	//+optional
+	Selector *metav1.LabelSelector `json:"selector,omitempty"`
+
+	// Template describes the pods that will be created.
+	Template corev1.PodTemplateSpec `json:"template"`
 }
 
 // PodSetStatus defines the observed state of PodSet
 type PodSetStatus struct {
-	// INSERT ADDITIONAL STATUS FIELD - define observed state of cluster
-	// Important: Run "make" to regenerate code after modifying this file
+	// Replicas is the number of active pods owned by the PodSet.
+	//+optional
+	Replicas int32 `json:"replicas,omitempty"`
+
+	// AvailableReplicas is the number of active pods that are ready.
+	//+optional
+	AvailableReplicas int32 `json:"availableReplicas,omitempty"`
+
+	// PodNames are the names of the active pods, sorted.
+	//+optional
+	PodNames []string `json:"podNames,omitempty"`
+
+	// Phases counts the pods owned by the PodSet by phase.
+	//+optional
+	Phases PodPhaseCounts `json:"phases,omitempty"`
+
+	// ObservedGeneration is the most recent generation observed by the
+	// controller.
+	//+optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// Selector is the label selector of the pods, in string form. It backs
+	// the scale subresource.
+	//+optional
+	Selector string `json:"selector,omitempty"`
+
+	// Conditions represent the latest available observations of the PodSet.
+	//+optional
+	//+listType=map
+	//+listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+// PodPhaseCounts counts pods by phase.
+type PodPhaseCounts struct {
+	//+optional
+	Pending int32 `json:"pending,omitempty"`
+	//+optional
+	Running int32 `json:"running,omitempty"`
+	//+optional
+	Succeeded int32 `json:"succeeded,omitempty"`
+	//+optional
+	Failed int32 `json:"failed,omitempty"`
 }
 
+// Condition types of a PodSet.
+const (
+	// ConditionReady is true when all the desired pods are ready.
+	ConditionReady = "Ready"
+	// ConditionDegraded is true when pods failed, or could not be created or
+	// deleted.
+	ConditionDegraded = "Degraded"
+	// ConditionImagePolicyViolation is true when one of its images is not
+	// allowed by the image policy.
+	ConditionImagePolicyViolation = "ImagePolicyViolation"
+)
+
 //+kubebuilder:object:root=true
 //+kubebuilder:subresource:status
+//+kubebuilder:subresource:scale:specpath=.spec.replicas,statuspath=.status.replicas,selectorpath=.status.selector
+//+kubebuilder:printcolumn:name="Desired",type=integer,JSONPath=`.spec.replicas`
+//+kubebuilder:printcolumn:name="Current",type=integer,JSONPath=`.status.replicas`
+//+kubebuilder:printcolumn:name="Ready",type=integer,JSONPath=`.status.availableReplicas`
+//+kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
 
 // PodSet is the Schema for the podsets API
 type PodSet struct {
@@ -0,0 +1,130 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// PodSetSpec defines the desired state of PodSet
+type PodSetSpec struct {
+	// Replicas is the number of desired pods. Defaults to 1.
+	//+optional
+	//+kubebuilder:default=1
+	//+kubebuilder:validation:Minimum=0
+	//+kubebuilder:validation:Maximum=1000
+	Replicas *int32 `json:"replicas,omitempty"`
+
+	// Selector is a label query over the pods of the PodSet. It must match
+	// the template labels. Defaults to the template labels.
+	//+optional
+	Selector *metav1.LabelSelector `json:"selector,omitempty"`
+
+	// Template describes the pods that will be created.
+	Template corev1.PodTemplateSpec `json:"template"`
+}
+
+// PodSetStatus defines the observed state of PodSet
+type PodSetStatus struct {
+	// Replicas is the number of active pods owned by the PodSet.
+	//+optional
+	Replicas int32 `json:"replicas,omitempty"`
+
+	// ReadyReplicas is the number of active pods that are ready.
+	//+optional
+	ReadyReplicas int32 `json:"readyReplicas,omitempty"`
+
+	// PodNames are the names of the active pods, sorted.
+	//+optional
+	PodNames []string `json:"podNames,omitempty"`
+
+	// Phases counts the pods owned by the PodSet by phase.
+	//+optional
+	Phases PodPhaseCounts `json:"phases,omitempty"`
+
+	// ObservedGeneration is the most recent generation observed by the
+	// controller.
+	//+optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// Selector is the label selector of the pods, in string form. It backs
+	// the scale subresource.
+	//+optional
+	Selector string `json:"selector,omitempty"`
+
+	// Conditions represent the latest available observations of the PodSet.
+	//+optional
+	//+listType=map
+	//+listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+// PodPhaseCounts counts pods by phase.
+type PodPhaseCounts struct {
+	//+optional
+	Pending int32 `json:"pending,omitempty"`
+	//+optional
+	Running int32 `json:"running,omitempty"`
+	//+optional
+	Succeeded int32 `json:"succeeded,omitempty"`
+	//+optional
+	Failed int32 `json:"failed,omitempty"`
+}
+
+// Condition types of a PodSet.
+const (
+	// ConditionReady is true when all the desired pods are ready.
+	ConditionReady = "Ready"
+	// ConditionDegraded is true when pods failed, or could not be created or
+	// deleted.
+	ConditionDegraded = "Degraded"
+	// ConditionImagePolicyViolation is true when one of its images is not
+	// allowed by the image policy.
+	ConditionImagePolicyViolation = "ImagePolicyViolation"
+)
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:subresource:scale:specpath=.spec.replicas,statuspath=.status.replicas,selectorpath=.status.selector
+//+kubebuilder:storageversion
+//+kubebuilder:printcolumn:name="Desired",type=integer,JSONPath=`.spec.replicas`
+//+kubebuilder:printcolumn:name="Current",type=integer,JSONPath=`.status.replicas`
+//+kubebuilder:printcolumn:name="Ready",type=integer,JSONPath=`.status.readyReplicas`
+//+kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// PodSet is the Schema for the podsets API
+type PodSet struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   PodSetSpec   `json:"spec,omitempty"`
+	Status PodSetStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// PodSetList contains a list of PodSet
+type PodSetList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []PodSet `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&PodSet{}, &PodSetList{})
+}
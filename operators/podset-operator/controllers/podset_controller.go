@@ -18,45 +18,315 @@ package controllers
 
 import (
 	"context"
+	"fmt"
+	"sort"
+	"strings"
 
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/log"
+	ctrlmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 
+	"github.com/mbtamuli/k8s/common/imagepolicy"
+	"github.com/mbtamuli/k8s/common/logging"
+	"github.com/mbtamuli/k8s/common/metrics"
+	"github.com/mbtamuli/k8s/common/tracing"
 	appv1alpha1 "github.com/mbtamuli/k8s/podset-operator/api/v1alpha1"
+	appv1beta1 "github.com/mbtamuli/k8s/podset-operator/api/v1beta1"
 )
 
+// Reasons used for the PodSet events. They stay the same from one reconcile
+// to the next so that the event recorder aggregates repeated events, the
+// details are in the message.
+const (
+	reasonSuccessfulCreate = "SuccessfulCreate"
+	reasonSuccessfulDelete = "SuccessfulDelete"
+	reasonFailedCreate     = "FailedCreate"
+	reasonFailedDelete     = "FailedDelete"
+	reasonScaled           = "Scaled"
+	reasonInvalidSelector  = "InvalidSelector"
+)
+
+// podSetMetrics are the PodSet metrics, served on the manager metrics
+// endpoint along with the controller-runtime ones.
+var podSetMetrics = metrics.NewWorkload("podset", "PodSet")
+
+func init() {
+	ctrlmetrics.Registry.MustRegister(podSetMetrics.Collectors()...)
+}
+
 // PodSetReconciler reconciles a PodSet object
 type PodSetReconciler struct {
 	client.Client
 	Scheme *runtime.Scheme
+	// Recorder emits the events of the PodSet, shown by kubectl describe
+	Recorder record.EventRecorder
+	// ImagePolicy is the image policy violations are reported against,
+	// none are reported when nil
+	ImagePolicy imagepolicy.Source
+	// MaxConcurrentReconciles is the number of PodSets reconciled at the
+	// same time, 1 when not set
+	MaxConcurrentReconciles int
 }
 
 //+kubebuilder:rbac:groups=app.mriyam.com,resources=podsets,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=app.mriyam.com,resources=podsets/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups=app.mriyam.com,resources=podsets/finalizers,verbs=update
+//+kubebuilder:rbac:groups=core,resources=pods,verbs=get;list;watch;create;delete
+//+kubebuilder:rbac:groups=core,resources=events,verbs=create;patch
+//+kubebuilder:rbac:groups=app.mriyam.com,resources=imagepolicies,verbs=get;list;watch
 
-// Reconcile is part of the main kubernetes reconciliation loop which aims to
-// move the current state of the cluster closer to the desired state.
-// TODO(user): Modify the Reconcile function to compare the state specified by
-// the PodSet object against the actual cluster state, and then
-// perform operations to make the cluster state reflect the state specified by
-// the user.
+// Reconcile creates or deletes the pods owned by a PodSet until there are
+// exactly Spec.Replicas of them, and reports what it observed in the status.
 //
 // For more details, check Reconcile and its Result here:
 // - https://pkg.go.dev/sigs.k8s.io/controller-runtime@v0.11.0/pkg/reconcile
 func (r *PodSetReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
-	_ = log.FromContext(ctx)
+	logger := log.FromContext(ctx)
+
+	podSet := &appv1beta1.PodSet{}
+	if err := r.Get(ctx, req.NamespacedName, podSet); err != nil {
+		if apierrors.IsNotFound(err) {
+			// The owned pods are garbage collected along with the PodSet
+			logger.V(logging.Debug).Info("PodSet not found, it must have been deleted")
+			podSetMetrics.Forget(req.Namespace, req.Name)
+		}
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	if !podSet.DeletionTimestamp.IsZero() {
+		logger.V(logging.Debug).Info("PodSet is being deleted, leaving its pods to the garbage collector")
+		return ctrl.Result{}, nil
+	}
+
+	selector, err := selectorForPodSet(podSet)
+	if err != nil {
+		logger.Error(err, "Invalid selector")
+		r.Recorder.Eventf(podSet, corev1.EventTypeWarning, reasonInvalidSelector, "Invalid selector: %v", err)
+		return ctrl.Result{}, err
+	}
+
+	podList := &corev1.PodList{}
+	if err := r.List(ctx, podList, client.InNamespace(podSet.Namespace), client.MatchingLabelsSelector{Selector: selector}); err != nil {
+		logger.Error(err, "Failed to list pods")
+		return ctrl.Result{}, err
+	}
+	pods := activePodsOwnedBy(podList.Items, podSet)
+	logger.V(logging.Trace).Info("Listed pods", "pods", podNames(pods), "desired", replicasForPodSet(podSet))
+	manageErr := r.deleteTerminalPods(ctx, podSet, podList.Items)
+	if manageErr == nil {
+		manageErr = r.manageReplicas(ctx, podSet, selector, pods)
+	}
 
-	// TODO(user): your logic here
+	// Report what we observed, including any failure to create or delete pods
+	status := calculateStatus(podSet, selector, podList.Items, manageErr)
+	r.checkImagePolicy(ctx, podSet, &status)
+	podSetMetrics.SetReplicas(podSet.Namespace, podSet.Name, replicasForPodSet(podSet), status.ReadyReplicas)
+	if !equality.Semantic.DeepEqual(status, podSet.Status) {
+		podSet.Status = status
+		if err := r.Status().Update(ctx, podSet); err != nil {
+			logger.Error(err, "Failed to update PodSet status")
+			return ctrl.Result{}, err
+		}
+	}
+
+	return ctrl.Result{}, manageErr
+}
+
+// manageReplicas creates or deletes pods until there are exactly
+// Spec.Replicas active pods. A single event is recorded for all the pods
+// created or deleted in one pass, and they are counted in the metrics.
+func (r *PodSetReconciler) manageReplicas(ctx context.Context, podSet *appv1beta1.PodSet, selector labels.Selector, pods []*corev1.Pod) error {
+	logger := log.FromContext(ctx)
+
+	desired := replicasForPodSet(podSet)
+	diff := len(pods) - int(desired)
+	switch {
+	case diff < 0:
+		r.Recorder.Eventf(podSet, corev1.EventTypeNormal, reasonScaled, "Scaling up from %d to %d replicas", len(pods), desired)
+		var created []string
+		defer func() {
+			r.recordPods(podSet, reasonSuccessfulCreate, "Created", created)
+			podSetMetrics.PodsCreated(podSet.Namespace, podSet.Name, metrics.ReasonScaleUp, len(created))
+		}()
+		for i := 0; i < -diff; i++ {
+			pod, err := r.podForPodSet(podSet, selector)
+			if err != nil {
+				logger.Error(err, "Failed to render pod", "action", "create")
+				r.Recorder.Eventf(podSet, corev1.EventTypeWarning, reasonFailedCreate, "Error creating pod: %v", err)
+				return err
+			}
+			if err := r.Create(ctx, pod); err != nil {
+				logger.Error(err, "Failed to create pod", "action", "create", "generateName", pod.GenerateName)
+				r.Recorder.Eventf(podSet, corev1.EventTypeWarning, reasonFailedCreate, "Error creating pod: %v", err)
+				return err
+			}
+			logger.Info("Created pod", "action", "create", "pod", pod.Name)
+			created = append(created, pod.Name)
+		}
+	case diff > 0:
+		r.Recorder.Eventf(podSet, corev1.EventTypeNormal, reasonScaled, "Scaling down from %d to %d replicas", len(pods), desired)
+		var deleted []string
+		defer func() {
+			r.recordPods(podSet, reasonSuccessfulDelete, "Deleted", deleted)
+			podSetMetrics.PodsDeleted(podSet.Namespace, podSet.Name, metrics.ReasonScaleDown, len(deleted))
+		}()
+		for _, pod := range podsToDelete(pods, diff) {
+			if err := r.Delete(ctx, pod); client.IgnoreNotFound(err) != nil {
+				logger.Error(err, "Failed to delete pod", "action", "delete", "pod", pod.Name)
+				r.Recorder.Eventf(podSet, corev1.EventTypeWarning, reasonFailedDelete, "Error deleting pod %s: %v", pod.Name, err)
+				return err
+			}
+			logger.Info("Deleted pod", "action", "delete", "pod", pod.Name)
+			deleted = append(deleted, pod.Name)
+		}
+	}
+	return nil
+}
+
+// deleteTerminalPods deletes the pods controlled by the PodSet that
+// succeeded or failed, as the ReplicaSet controller does. They no longer
+// count towards the replicas and manageReplicas replaces them, so they would
+// otherwise pile up.
+func (r *PodSetReconciler) deleteTerminalPods(ctx context.Context, podSet *appv1beta1.PodSet, pods []corev1.Pod) error {
+	logger := log.FromContext(ctx)
+
+	var deleted []string
+	defer func() {
+		r.recordPods(podSet, reasonSuccessfulDelete, "Deleted", deleted)
+		podSetMetrics.PodsDeleted(podSet.Namespace, podSet.Name, metrics.ReasonPodFailed, len(deleted))
+	}()
+	for i := range pods {
+		pod := &pods[i]
+		if !metav1.IsControlledBy(pod, podSet) || pod.DeletionTimestamp != nil || !isPodTerminal(pod) {
+			continue
+		}
+		if err := r.Delete(ctx, pod); client.IgnoreNotFound(err) != nil {
+			logger.Error(err, "Failed to delete terminated pod", "action", "delete", "pod", pod.Name)
+			r.Recorder.Eventf(podSet, corev1.EventTypeWarning, reasonFailedDelete, "Error deleting pod %s: %v", pod.Name, err)
+			return err
+		}
+		logger.Info("Deleted terminated pod", "action", "delete", "pod", pod.Name, "phase", pod.Status.Phase)
+		deleted = append(deleted, pod.Name)
+	}
+	return nil
+}
 
-	return ctrl.Result{}, nil
+// recordPods records a single event naming the pods created or deleted, if
+// any.
+func (r *PodSetReconciler) recordPods(podSet *appv1beta1.PodSet, reason, verb string, names []string) {
+	if len(names) == 0 {
+		return
+	}
+	noun := "pods"
+	if len(names) == 1 {
+		noun = "pod"
+	}
+	r.Recorder.Eventf(podSet, corev1.EventTypeNormal, reason, "%s %d %s: %s", verb, len(names), noun, strings.Join(names, ", "))
 }
 
 // SetupWithManager sets up the controller with the Manager.
 func (r *PodSetReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	return ctrl.NewControllerManagedBy(mgr).
-		For(&appv1alpha1.PodSet{}).
-		Complete(r)
+		For(&appv1beta1.PodSet{}).
+		Owns(&corev1.Pod{}).
+		Watches(&source.Kind{Type: &appv1alpha1.ImagePolicy{}}, handler.EnqueueRequestsFromMapFunc(r.enqueueForImagePolicy)).
+		WithOptions(controller.Options{MaxConcurrentReconciles: r.MaxConcurrentReconciles}).
+		Complete(tracing.Reconciler{Reconciler: logging.Reconciler{Reconciler: r}, Kind: "PodSet"})
+}
+
+// podForPodSet renders a new pod from the PodSet template.
+func (r *PodSetReconciler) podForPodSet(podSet *appv1beta1.PodSet, selector labels.Selector) (*corev1.Pod, error) {
+	template := podSet.Spec.Template.DeepCopy()
+	if !selector.Matches(labels.Set(template.Labels)) {
+		// Pods we cannot select would never count towards the replicas
+		return nil, fmt.Errorf("selector %q does not match template labels", selector)
+	}
+
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: podSet.Name + "-",
+			Namespace:    podSet.Namespace,
+			Labels:       template.Labels,
+			Annotations:  template.Annotations,
+		},
+		Spec: template.Spec,
+	}
+	if err := ctrl.SetControllerReference(podSet, pod, r.Scheme); err != nil {
+		return nil, err
+	}
+	return pod, nil
+}
+
+// selectorForPodSet returns the selector of the PodSet, defaulting to its
+// template labels.
+func selectorForPodSet(podSet *appv1beta1.PodSet) (labels.Selector, error) {
+	if podSet.Spec.Selector == nil {
+		return labels.SelectorFromSet(podSet.Spec.Template.Labels), nil
+	}
+	return metav1.LabelSelectorAsSelector(podSet.Spec.Selector)
+}
+
+// replicasForPodSet returns the desired number of pods, defaulting to 1.
+func replicasForPodSet(podSet *appv1beta1.PodSet) int32 {
+	if podSet.Spec.Replicas == nil {
+		return 1
+	}
+	return *podSet.Spec.Replicas
+}
+
+// activePodsOwnedBy returns the pods controlled by the owner that are neither
+// terminating nor terminated.
+func activePodsOwnedBy(pods []corev1.Pod, owner metav1.Object) []*corev1.Pod {
+	var active []*corev1.Pod
+	for i := range pods {
+		pod := &pods[i]
+		if !metav1.IsControlledBy(pod, owner) || pod.DeletionTimestamp != nil || isPodTerminal(pod) {
+			continue
+		}
+		active = append(active, pod)
+	}
+	return active
+}
+
+// isPodTerminal reports whether the pod succeeded or failed, its containers
+// are not restarted then.
+func isPodTerminal(pod *corev1.Pod) bool {
+	return pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed
+}
+
+// podsToDelete picks n pods to delete, preferring the ones that are not
+// ready, then the newest ones. The pod name breaks the ties so that the
+// order is stable.
+func podsToDelete(pods []*corev1.Pod, n int) []*corev1.Pod {
+	sorted := append([]*corev1.Pod(nil), pods...)
+	sort.Slice(sorted, func(i, j int) bool {
+		pi, pj := sorted[i], sorted[j]
+		if ri, rj := isPodReady(pi), isPodReady(pj); ri != rj {
+			return !ri
+		}
+		if ti, tj := pi.CreationTimestamp, pj.CreationTimestamp; !ti.Equal(&tj) {
+			return tj.Before(&ti)
+		}
+		return pi.Name < pj.Name
+	})
+	return sorted[:n]
+}
+
+// podNames returns the names of the pods.
+func podNames(pods []*corev1.Pod) []string {
+	names := make([]string, 0, len(pods))
+	for _, pod := range pods {
+		names = append(names, pod.Name)
+	}
+	return names
 }
@@ -0,0 +1,213 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"sort"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/mbtamuli/k8s/common/imagepolicy"
+	appv1alpha1 "github.com/mbtamuli/k8s/podset-operator/api/v1alpha1"
+	appv1beta1 "github.com/mbtamuli/k8s/podset-operator/api/v1beta1"
+)
+
+var _ = Describe("PodSet controller", func() {
+	const (
+		namespace = "default"
+		timeout   = 10 * time.Second
+		interval  = 250 * time.Millisecond
+	)
+
+	newPodSet := func(name string, replicas int32) *appv1beta1.PodSet {
+		labels := map[string]string{"app": name}
+		return &appv1beta1.PodSet{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+			Spec: appv1beta1.PodSetSpec{
+				Replicas: &replicas,
+				Selector: &metav1.LabelSelector{MatchLabels: labels},
+				Template: corev1.PodTemplateSpec{
+					ObjectMeta: metav1.ObjectMeta{Labels: labels},
+					Spec: corev1.PodSpec{
+						Containers: []corev1.Container{{Name: "app", Image: "nginx:1.21"}},
+					},
+				},
+			},
+		}
+	}
+
+	// ownedPods returns the active pods controlled by the PodSet
+	ownedPods := func(podSet *appv1beta1.PodSet) func() int {
+		return func() int {
+			podList := &corev1.PodList{}
+			err := k8sClient.List(ctx, podList, client.InNamespace(namespace), client.MatchingLabels(podSet.Spec.Selector.MatchLabels))
+			Expect(err).NotTo(HaveOccurred())
+			return len(activePodsOwnedBy(podList.Items, podSet))
+		}
+	}
+
+	It("creates pods when scaling up", func() {
+		podSet := newPodSet("scale-up", 1)
+		Expect(k8sClient.Create(ctx, podSet)).To(Succeed())
+		Eventually(ownedPods(podSet), timeout, interval).Should(Equal(1))
+
+		key := types.NamespacedName{Name: podSet.Name, Namespace: namespace}
+		Expect(k8sClient.Get(ctx, key, podSet)).To(Succeed())
+		replicas := int32(3)
+		podSet.Spec.Replicas = &replicas
+		Expect(k8sClient.Update(ctx, podSet)).To(Succeed())
+		Eventually(ownedPods(podSet), timeout, interval).Should(Equal(3))
+		Consistently(ownedPods(podSet), time.Second, interval).Should(Equal(3))
+	})
+
+	It("deletes pods when scaling down", func() {
+		podSet := newPodSet("scale-down", 3)
+		Expect(k8sClient.Create(ctx, podSet)).To(Succeed())
+		Eventually(ownedPods(podSet), timeout, interval).Should(Equal(3))
+
+		key := types.NamespacedName{Name: podSet.Name, Namespace: namespace}
+		Expect(k8sClient.Get(ctx, key, podSet)).To(Succeed())
+		replicas := int32(1)
+		podSet.Spec.Replicas = &replicas
+		Expect(k8sClient.Update(ctx, podSet)).To(Succeed())
+		Eventually(ownedPods(podSet), timeout, interval).Should(Equal(1))
+		Consistently(ownedPods(podSet), time.Second, interval).Should(Equal(1))
+	})
+
+	It("follows the replicas set through the scale subresource", func() {
+		podSet := newPodSet("scale-subresource", 1)
+		Expect(k8sClient.Create(ctx, podSet)).To(Succeed())
+		Eventually(ownedPods(podSet), timeout, interval).Should(Equal(1))
+
+		scales := dynamic.NewForConfigOrDie(testEnv.Config).
+			Resource(appv1beta1.GroupVersion.WithResource("podsets")).
+			Namespace(namespace)
+		scaleTo := func(replicas int64) {
+			scale, err := scales.Get(ctx, podSet.Name, metav1.GetOptions{}, "scale")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(unstructured.SetNestedField(scale.Object, replicas, "spec", "replicas")).To(Succeed())
+			_, err = scales.Update(ctx, scale, metav1.UpdateOptions{}, "scale")
+			Expect(err).NotTo(HaveOccurred())
+		}
+		scaleStatus := func() map[string]interface{} {
+			scale, err := scales.Get(ctx, podSet.Name, metav1.GetOptions{}, "scale")
+			Expect(err).NotTo(HaveOccurred())
+			status, _, err := unstructured.NestedMap(scale.Object, "status")
+			Expect(err).NotTo(HaveOccurred())
+			return status
+		}
+
+		scaleTo(3)
+		Eventually(ownedPods(podSet), timeout, interval).Should(Equal(3))
+		Eventually(scaleStatus, timeout, interval).Should(And(
+			HaveKeyWithValue("replicas", BeNumerically("==", 3)),
+			HaveKeyWithValue("selector", "app=scale-subresource"),
+		))
+
+		scaleTo(0)
+		Eventually(ownedPods(podSet), timeout, interval).Should(Equal(0))
+		Consistently(ownedPods(podSet), time.Second, interval).Should(Equal(0))
+	})
+
+	It("rejects scaling over the maximum through the scale subresource", func() {
+		podSet := newPodSet("scale-maximum", 1)
+		Expect(k8sClient.Create(ctx, podSet)).To(Succeed())
+
+		scales := dynamic.NewForConfigOrDie(testEnv.Config).
+			Resource(appv1beta1.GroupVersion.WithResource("podsets")).
+			Namespace(namespace)
+		scale, err := scales.Get(ctx, podSet.Name, metav1.GetOptions{}, "scale")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(unstructured.SetNestedField(scale.Object, int64(1001), "spec", "replicas")).To(Succeed())
+		_, err = scales.Update(ctx, scale, metav1.UpdateOptions{}, "scale")
+		Expect(apierrors.IsInvalid(err)).To(BeTrue(), "expected an invalid error, got %v", err)
+	})
+
+	It("replaces pods deleted behind its back", func() {
+		podSet := newPodSet("replace", 2)
+		Expect(k8sClient.Create(ctx, podSet)).To(Succeed())
+		Eventually(ownedPods(podSet), timeout, interval).Should(Equal(2))
+
+		Expect(k8sClient.DeleteAllOf(ctx, &corev1.Pod{}, client.InNamespace(namespace), client.MatchingLabels(podSet.Spec.Selector.MatchLabels))).To(Succeed())
+		Eventually(ownedPods(podSet), timeout, interval).Should(Equal(2))
+	})
+
+	It("reports the pods in the status", func() {
+		podSet := newPodSet("status", 2)
+		Expect(k8sClient.Create(ctx, podSet)).To(Succeed())
+
+		key := types.NamespacedName{Name: podSet.Name, Namespace: namespace}
+		getStatus := func() appv1beta1.PodSetStatus {
+			Expect(k8sClient.Get(ctx, key, podSet)).To(Succeed())
+			return podSet.Status
+		}
+		Eventually(func() int32 { return getStatus().Phases.Pending }, timeout, interval).Should(Equal(int32(2)))
+		status := getStatus()
+		Expect(status.Replicas).To(Equal(int32(2)))
+		Expect(status.ReadyReplicas).To(BeZero())
+		Expect(status.PodNames).To(HaveLen(2))
+		Expect(sort.StringsAreSorted(status.PodNames)).To(BeTrue())
+		Expect(status.ObservedGeneration).To(Equal(podSet.Generation))
+		Expect(meta.IsStatusConditionFalse(status.Conditions, appv1beta1.ConditionReady)).To(BeTrue())
+		Expect(meta.IsStatusConditionFalse(status.Conditions, appv1beta1.ConditionDegraded)).To(BeTrue())
+
+		By("playing the part of the kubelet")
+		for _, name := range status.PodNames {
+			pod := &corev1.Pod{}
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: name, Namespace: namespace}, pod)).To(Succeed())
+			pod.Status.Phase = corev1.PodRunning
+			pod.Status.Conditions = []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionTrue}}
+			Expect(k8sClient.Status().Update(ctx, pod)).To(Succeed())
+		}
+		Eventually(func() int32 { return getStatus().ReadyReplicas }, timeout, interval).Should(Equal(int32(2)))
+		status = getStatus()
+		Expect(status.Phases).To(Equal(appv1beta1.PodPhaseCounts{Running: 2}))
+		Expect(meta.IsStatusConditionTrue(status.Conditions, appv1beta1.ConditionReady)).To(BeTrue())
+	})
+
+	It("reports images violating the image policy", func() {
+		policy := &appv1alpha1.ImagePolicy{
+			ObjectMeta: metav1.ObjectMeta{Name: appv1alpha1.ClusterImagePolicyName},
+			Spec:       imagepolicy.Policy{AllowedRegistries: []string{"ghcr.io"}},
+		}
+		Expect(k8sClient.Create(ctx, policy)).To(Succeed())
+		podSet := newPodSet("image-policy", 1)
+		Expect(k8sClient.Create(ctx, podSet)).To(Succeed())
+
+		key := types.NamespacedName{Name: podSet.Name, Namespace: namespace}
+		violation := func() *metav1.Condition {
+			Expect(k8sClient.Get(ctx, key, podSet)).To(Succeed())
+			return meta.FindStatusCondition(podSet.Status.Conditions, appv1beta1.ConditionImagePolicyViolation)
+		}
+		Eventually(violation, timeout, interval).ShouldNot(BeNil())
+		Expect(violation().Message).To(ContainSubstring("nginx:1.21"))
+
+		By("relaxing the policy")
+		Expect(k8sClient.Delete(ctx, policy)).To(Succeed())
+		Eventually(violation, timeout, interval).Should(BeNil())
+	})
+})
@@ -0,0 +1,103 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	appv1beta1 "github.com/mbtamuli/k8s/podset-operator/api/v1beta1"
+)
+
+func TestPodsToDelete(t *testing.T) {
+	now := time.Now()
+	newPod := func(name string, age time.Duration, ready bool) *corev1.Pod {
+		pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: name, CreationTimestamp: metav1.NewTime(now.Add(-age))}}
+		if ready {
+			pod.Status.Phase = corev1.PodRunning
+			pod.Status.Conditions = []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionTrue}}
+		}
+		return pod
+	}
+	oldReady := newPod("old-ready", time.Hour, true)
+	newReady := newPod("new-ready", time.Minute, true)
+	oldNotReady := newPod("old-not-ready", time.Hour, false)
+	newNotReady := newPod("new-not-ready", time.Minute, false)
+	// Running but failing its readiness probe
+	running := newPod("running", 2*time.Hour, false)
+	running.Status.Phase = corev1.PodRunning
+	tiedA := newPod("tied-a", time.Minute, true)
+	tiedB := newPod("tied-b", time.Minute, true)
+
+	pods := []*corev1.Pod{oldReady, tiedB, newReady, running, oldNotReady, tiedA, newNotReady}
+	got := podNames(podsToDelete(pods, len(pods)))
+	want := []string{"new-not-ready", "old-not-ready", "running", "new-ready", "tied-a", "tied-b", "old-ready"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("podsToDelete() = %v, want %v", got, want)
+	}
+	if got := podNames(podsToDelete(pods, 2)); !reflect.DeepEqual(got, want[:2]) {
+		t.Errorf("podsToDelete(2) = %v, want %v", got, want[:2])
+	}
+	if pods[0] != oldReady {
+		t.Error("podsToDelete() reordered the pods it was given")
+	}
+}
+
+func TestReconcileDeletesTerminalPods(t *testing.T) {
+	ctx := context.Background()
+	podSet := newTestPodSet(2, "nginx:1.21")
+	r := newTestReconciler(podSet)
+	expectEvents(t, reconcileEvents(t, r, podSet),
+		"Normal Scaled Scaling up from 0 to 2 replicas",
+		"Normal SuccessfulCreate Created 2 pods: web-")
+
+	podList := &corev1.PodList{}
+	if err := r.List(ctx, podList); err != nil {
+		t.Fatal(err)
+	}
+	failed := &podList.Items[0]
+	failed.Status.Phase = corev1.PodFailed
+	if err := r.Status().Update(ctx, failed); err != nil {
+		t.Fatal(err)
+	}
+	// Terminal pods of other owners are left alone
+	other := newTestPodSet(1, "nginx:1.21")
+	other.Name, other.UID = "other", "other-uid"
+	foreign := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "foreign", Namespace: "default", Labels: map[string]string{"app": "web"}}}
+	foreign.OwnerReferences = []metav1.OwnerReference{*metav1.NewControllerRef(other, appv1beta1.GroupVersion.WithKind("PodSet"))}
+	foreign.Status.Phase = corev1.PodSucceeded
+	if err := r.Create(ctx, foreign); err != nil {
+		t.Fatal(err)
+	}
+
+	expectEvents(t, reconcileEvents(t, r, podSet),
+		"Normal SuccessfulDelete Deleted 1 pod: "+failed.Name,
+		"Normal Scaled Scaling up from 1 to 2 replicas",
+		"Normal SuccessfulCreate Created 1 pod: web-")
+	if err := r.Get(ctx, client.ObjectKeyFromObject(failed), &corev1.Pod{}); err == nil {
+		t.Errorf("pod %s that failed was not deleted", failed.Name)
+	}
+	if err := r.Get(ctx, client.ObjectKeyFromObject(foreign), &corev1.Pod{}); err != nil {
+		t.Errorf("pod controlled by another PodSet was deleted: %v", err)
+	}
+}
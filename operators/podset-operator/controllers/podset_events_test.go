@@ -0,0 +1,147 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/mbtamuli/k8s/common/imagepolicy"
+	appv1beta1 "github.com/mbtamuli/k8s/podset-operator/api/v1beta1"
+)
+
+func newTestPodSet(replicas int32, image string) *appv1beta1.PodSet {
+	labels := map[string]string{"app": "web"}
+	return &appv1beta1.PodSet{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default", UID: "web-uid"},
+		Spec: appv1beta1.PodSetSpec{
+			Replicas: &replicas,
+			Selector: &metav1.LabelSelector{MatchLabels: labels},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: labels},
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{{Name: "app", Image: image}},
+				},
+			},
+		},
+	}
+}
+
+func newTestReconciler(objs ...client.Object) *PodSetReconciler {
+	s := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(s)
+	_ = appv1beta1.AddToScheme(s)
+	return &PodSetReconciler{
+		Client:   fake.NewClientBuilder().WithScheme(s).WithObjects(objs...).Build(),
+		Scheme:   s,
+		Recorder: record.NewFakeRecorder(100),
+	}
+}
+
+// reconcileEvents reconciles the PodSet once and returns the events recorded
+// meanwhile.
+func reconcileEvents(t *testing.T, r *PodSetReconciler, podSet *appv1beta1.PodSet) []string {
+	t.Helper()
+	req := ctrl.Request{NamespacedName: client.ObjectKeyFromObject(podSet)}
+	// Errors are reported through the events under test
+	_, _ = r.Reconcile(context.Background(), req)
+
+	recorder := r.Recorder.(*record.FakeRecorder)
+	var events []string
+	for {
+		select {
+		case event := <-recorder.Events:
+			events = append(events, event)
+		default:
+			return events
+		}
+	}
+}
+
+func expectEvents(t *testing.T, got []string, want ...string) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("got events %q, want %q", got, want)
+	}
+	for i := range want {
+		if !strings.HasPrefix(got[i], want[i]) {
+			t.Errorf("event %d = %q, want it to start with %q", i, got[i], want[i])
+		}
+	}
+}
+
+func TestReconcileRecordsScaleEvents(t *testing.T) {
+	podSet := newTestPodSet(3, "nginx:1.21")
+	r := newTestReconciler(podSet)
+
+	events := reconcileEvents(t, r, podSet)
+	expectEvents(t, events,
+		"Normal Scaled Scaling up from 0 to 3 replicas",
+		"Normal SuccessfulCreate Created 3 pods: web-")
+
+	// Nothing to do, nothing to report
+	expectEvents(t, reconcileEvents(t, r, podSet))
+
+	if err := r.Get(context.Background(), client.ObjectKeyFromObject(podSet), podSet); err != nil {
+		t.Fatal(err)
+	}
+	replicas := int32(1)
+	podSet.Spec.Replicas = &replicas
+	if err := r.Update(context.Background(), podSet); err != nil {
+		t.Fatal(err)
+	}
+	expectEvents(t, reconcileEvents(t, r, podSet),
+		"Normal Scaled Scaling down from 3 to 1 replicas",
+		"Normal SuccessfulDelete Deleted 2 pods: web-")
+}
+
+func TestReconcileRecordsValidationFailures(t *testing.T) {
+	t.Run("invalid selector", func(t *testing.T) {
+		podSet := newTestPodSet(1, "nginx:1.21")
+		podSet.Spec.Selector.MatchExpressions = []metav1.LabelSelectorRequirement{{Key: "app", Operator: "Bogus"}}
+		expectEvents(t, reconcileEvents(t, newTestReconciler(podSet), podSet),
+			"Warning InvalidSelector Invalid selector: ")
+	})
+
+	t.Run("selector not matching the template", func(t *testing.T) {
+		podSet := newTestPodSet(2, "nginx:1.21")
+		podSet.Spec.Selector.MatchLabels = map[string]string{"app": "other"}
+		expectEvents(t, reconcileEvents(t, newTestReconciler(podSet), podSet),
+			"Normal Scaled Scaling up from 0 to 2 replicas",
+			`Warning FailedCreate Error creating pod: selector "app=other" does not match template labels`)
+	})
+
+	t.Run("image policy violation", func(t *testing.T) {
+		podSet := newTestPodSet(0, "docker.io/library/nginx:1.21")
+		r := newTestReconciler(podSet)
+		r.ImagePolicy = imagepolicy.StaticSource{Policy: &imagepolicy.Policy{AllowedRegistries: []string{"registry.example.com"}}}
+		expectEvents(t, reconcileEvents(t, r, podSet), "Warning ImageForbidden ")
+
+		// The violation is only reported once
+		expectEvents(t, reconcileEvents(t, r, podSet))
+	})
+}
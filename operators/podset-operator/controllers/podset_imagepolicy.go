@@ -0,0 +1,88 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	appv1alpha1 "github.com/mbtamuli/k8s/podset-operator/api/v1alpha1"
+	appv1beta1 "github.com/mbtamuli/k8s/podset-operator/api/v1beta1"
+)
+
+// reasonImageForbidden is the reason of the ImagePolicyViolation condition.
+const reasonImageForbidden = "ImageForbidden"
+
+// checkImagePolicy reports the images of the PodSet violating the image
+// policy in the ImagePolicyViolation condition. The webhook keeps new
+// violations out, this catches the objects admitted before the policy was
+// tightened. A warning event is recorded when the violations change. The
+// condition is left alone when the policy cannot be read.
+func (r *PodSetReconciler) checkImagePolicy(ctx context.Context, podSet *appv1beta1.PodSet, status *appv1beta1.PodSetStatus) {
+	if r.ImagePolicy == nil {
+		return
+	}
+	policy, err := r.ImagePolicy.ImagePolicy(ctx)
+	if err != nil {
+		log.FromContext(ctx).Error(err, "Failed to read the image policy")
+		return
+	}
+
+	var violations []string
+	podSpec := &podSet.Spec.Template.Spec
+	for _, containers := range [][]corev1.Container{podSpec.InitContainers, podSpec.Containers} {
+		for _, c := range containers {
+			if err := policy.Check(c.Image); err != nil {
+				violations = append(violations, err.Error())
+			}
+		}
+	}
+	if len(violations) == 0 {
+		meta.RemoveStatusCondition(&status.Conditions, appv1beta1.ConditionImagePolicyViolation)
+		return
+	}
+	message := strings.Join(violations, "; ")
+	if c := meta.FindStatusCondition(podSet.Status.Conditions, appv1beta1.ConditionImagePolicyViolation); c == nil || c.Message != message {
+		r.Recorder.Event(podSet, corev1.EventTypeWarning, reasonImageForbidden, message)
+	}
+	setCondition(status, appv1beta1.ConditionImagePolicyViolation, metav1.ConditionTrue, reasonImageForbidden, message)
+}
+
+// enqueueForImagePolicy enqueues every PodSet when the cluster ImagePolicy
+// changes, so that their condition is brought up to date.
+func (r *PodSetReconciler) enqueueForImagePolicy(obj client.Object) []reconcile.Request {
+	if obj.GetName() != appv1alpha1.ClusterImagePolicyName {
+		return nil
+	}
+	list := &appv1beta1.PodSetList{}
+	if err := r.List(context.Background(), list); err != nil {
+		log.Log.Error(err, "Failed to list PodSets for the image policy")
+		return nil
+	}
+	requests := make([]reconcile.Request, 0, len(list.Items))
+	for _, podSet := range list.Items {
+		requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&podSet)})
+	}
+	return requests
+}
@@ -0,0 +1,124 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"fmt"
+	"sort"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+
+	appv1beta1 "github.com/mbtamuli/k8s/podset-operator/api/v1beta1"
+)
+
+// Reasons used for the PodSet conditions.
+const (
+	reasonAllReplicasReady = "AllReplicasReady"
+	reasonReplicasNotReady = "ReplicasNotReady"
+	reasonReplicasHealthy  = "ReplicasHealthy"
+	reasonPodsFailed       = "PodsFailed"
+	reasonReconcileFailed  = "ReconcileFailed"
+)
+
+// calculateStatus computes the status of the PodSet from the pods listed at
+// the start of the reconcile with its selector, and the error, if any,
+// returned while creating or deleting pods.
+func calculateStatus(podSet *appv1beta1.PodSet, selector labels.Selector, pods []corev1.Pod, manageErr error) appv1beta1.PodSetStatus {
+	status := *podSet.Status.DeepCopy()
+
+	active := activePodsOwnedBy(pods, podSet)
+	names := make([]string, 0, len(active))
+	var ready int32
+	for _, pod := range active {
+		names = append(names, pod.Name)
+		if isPodReady(pod) {
+			ready++
+		}
+	}
+	sort.Strings(names)
+
+	var phases appv1beta1.PodPhaseCounts
+	for i := range pods {
+		pod := &pods[i]
+		if !metav1.IsControlledBy(pod, podSet) || pod.DeletionTimestamp != nil {
+			continue
+		}
+		switch pod.Status.Phase {
+		case corev1.PodPending:
+			phases.Pending++
+		case corev1.PodRunning:
+			phases.Running++
+		case corev1.PodSucceeded:
+			phases.Succeeded++
+		case corev1.PodFailed:
+			phases.Failed++
+		}
+	}
+
+	desired := replicasForPodSet(podSet)
+	status.Replicas = int32(len(active))
+	status.ReadyReplicas = ready
+	status.PodNames = names
+	status.Phases = phases
+	status.ObservedGeneration = podSet.Generation
+	status.Selector = selector.String()
+
+	if ready == desired && status.Replicas == desired {
+		setCondition(&status, appv1beta1.ConditionReady, metav1.ConditionTrue, reasonAllReplicasReady,
+			"All replicas are ready.")
+	} else {
+		setCondition(&status, appv1beta1.ConditionReady, metav1.ConditionFalse, reasonReplicasNotReady,
+			fmt.Sprintf("%d of %d replicas are ready.", ready, desired))
+	}
+
+	switch {
+	case manageErr != nil:
+		setCondition(&status, appv1beta1.ConditionDegraded, metav1.ConditionTrue, reasonReconcileFailed, manageErr.Error())
+	case phases.Failed > 0:
+		setCondition(&status, appv1beta1.ConditionDegraded, metav1.ConditionTrue, reasonPodsFailed,
+			fmt.Sprintf("%d pods failed.", phases.Failed))
+	default:
+		setCondition(&status, appv1beta1.ConditionDegraded, metav1.ConditionFalse, reasonReplicasHealthy,
+			"No pod failed.")
+	}
+
+	return status
+}
+
+// setCondition adds or updates the condition of the given type. The last
+// transition time is only bumped when the status changes.
+func setCondition(status *appv1beta1.PodSetStatus, conditionType string, conditionStatus metav1.ConditionStatus, reason, message string) {
+	meta.SetStatusCondition(&status.Conditions, metav1.Condition{
+		Type:    conditionType,
+		Status:  conditionStatus,
+		Reason:  reason,
+		Message: message,
+	})
+}
+
+// isPodReady reports whether the pod has the Ready condition.
+func isPodReady(pod *corev1.Pod) bool {
+	for _, c := range pod.Status.Conditions {
+		if c.Type == corev1.PodReady {
+			return c.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
@@ -17,22 +17,32 @@ limitations under the License.
 package main
 
 import (
+	"context"
 	"flag"
+	"fmt"
 	"os"
+	"time"
 
 	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
 	// to ensure that exec-entrypoint and run can make use of them.
 	_ "k8s.io/client-go/plugin/pkg/client/auth"
 
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
-	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 
+	"github.com/mbtamuli/k8s/common/config"
+	"github.com/mbtamuli/k8s/common/imagepolicy"
+	"github.com/mbtamuli/k8s/common/logging"
+	"github.com/mbtamuli/k8s/common/tracing"
 	appv1alpha1 "github.com/mbtamuli/k8s/podset-operator/api/v1alpha1"
+	appv1beta1 "github.com/mbtamuli/k8s/podset-operator/api/v1beta1"
 	"github.com/mbtamuli/k8s/podset-operator/controllers"
+	"github.com/mbtamuli/k8s/podset-operator/pkg/migration"
+	"github.com/mbtamuli/k8s/podset-operator/webhooks"
 	//+kubebuilder:scaffold:imports
 )
 
@@ -41,56 +51,99 @@ var (
 	setupLog = ctrl.Log.WithName("setup")
 )
 
+// leaderElectionID names the lease of the leader election, unless the config
+// file names another one.
+const leaderElectionID = "1af2e677.mriyam.com"
+
 func init() {
 	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
+	utilruntime.Must(apiextensionsv1.AddToScheme(scheme))
 
 	utilruntime.Must(appv1alpha1.AddToScheme(scheme))
+	utilruntime.Must(appv1beta1.AddToScheme(scheme))
 	//+kubebuilder:scaffold:scheme
 }
 
 func main() {
-	var metricsAddr string
-	var enableLeaderElection bool
-	var probeAddr string
-	flag.StringVar(&metricsAddr, "metrics-bind-address", ":8080", "The address the metric endpoint binds to.")
-	flag.StringVar(&probeAddr, "health-probe-bind-address", ":8081", "The address the probe endpoint binds to.")
-	flag.BoolVar(&enableLeaderElection, "leader-elect", false,
-		"Enable leader election for controller manager. "+
-			"Enabling this will ensure there is only one active controller manager.")
-	opts := zap.Options{
-		Development: true,
-	}
-	opts.BindFlags(flag.CommandLine)
+	var configFile string
+	flag.StringVar(&configFile, "config", "",
+		"The controller manager config file to load. "+
+			"Flags given on the command line override its settings.")
+	config.BindFlags(flag.CommandLine)
+	var logOpts logging.Options
+	logOpts.BindFlags(flag.CommandLine)
 	flag.Parse()
 
-	ctrl.SetLogger(zap.New(zap.UseFlagOptions(&opts)))
+	if err := logOpts.Validate(); err != nil {
+		// Not logged, as the logger depends on it
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	ctrl.SetLogger(logOpts.Logger())
+
+	cfg, err := config.Load(configFile, leaderElectionID, flag.CommandLine)
+	if err != nil {
+		setupLog.Error(err, "unable to load the config file")
+		os.Exit(1)
+	}
+	options, err := cfg.ManagerOptions(scheme)
+	if err != nil {
+		setupLog.Error(err, "unable to apply the config file")
+		os.Exit(1)
+	}
+
+	ctx := ctrl.SetupSignalHandler()
+	shutdownTracing, err := tracing.Setup(ctx, "podset-operator", cfg.Tracing)
+	if err != nil {
+		setupLog.Error(err, "unable to set up tracing")
+		os.Exit(1)
+	}
+	// Flush the spans still batched, os.Exit skips the deferred calls
+	flushTracing := func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := shutdownTracing(ctx); err != nil {
+			setupLog.Error(err, "unable to flush the traces")
+		}
+	}
+	defer flushTracing()
 
-	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
-		Scheme:                 scheme,
-		MetricsBindAddress:     metricsAddr,
-		Port:                   9443,
-		HealthProbeBindAddress: probeAddr,
-		LeaderElection:         enableLeaderElection,
-		LeaderElectionID:       "1af2e677.mriyam.com",
-	})
+	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), options)
 	if err != nil {
 		setupLog.Error(err, "unable to start manager")
 		os.Exit(1)
 	}
 
 	if err = (&controllers.PodSetReconciler{
-		Client: mgr.GetClient(),
-		Scheme: mgr.GetScheme(),
+		Client:                  tracing.NewClient(mgr.GetClient()),
+		Scheme:                  mgr.GetScheme(),
+		Recorder:                mgr.GetEventRecorderFor("podset-controller"),
+		ImagePolicy:             appv1alpha1.ClusterImagePolicySource{Reader: mgr.GetClient(), Default: cfg.ImagePolicy},
+		MaxConcurrentReconciles: cfg.MaxConcurrentReconciles,
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "PodSet")
 		os.Exit(1)
 	}
-	if err = (&appv1alpha1.PodSet{}).SetupWebhookWithManager(mgr); err != nil {
+	if err = (&webhooks.PodSetWebhook{
+		DefaultImage: cfg.DefaultImage,
+		ImagePolicy:  appv1alpha1.ClusterImagePolicySource{Reader: mgr.GetAPIReader(), Default: cfg.ImagePolicy},
+		Resolver:     &imagepolicy.RegistryResolver{},
+	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create webhook", "webhook", "PodSet")
 		os.Exit(1)
 	}
 	//+kubebuilder:scaffold:builder
 
+	// Rewrite the PodSets stored as v1alpha1 so v1alpha1 can be dropped
+	if err := mgr.Add(&migration.StorageVersionMigrator{
+		Client:  mgr.GetClient(),
+		Reader:  mgr.GetAPIReader(),
+		CRDName: "podsets." + appv1beta1.GroupVersion.Group,
+	}); err != nil {
+		setupLog.Error(err, "unable to set up storage version migration")
+		os.Exit(1)
+	}
+
 	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
 		setupLog.Error(err, "unable to set up health check")
 		os.Exit(1)
@@ -101,8 +154,9 @@ func main() {
 	}
 
 	setupLog.Info("starting manager")
-	if err := mgr.Start(ctrl.SetupSignalHandler()); err != nil {
+	if err := mgr.Start(ctx); err != nil {
 		setupLog.Error(err, "problem running manager")
+		flushTracing()
 		os.Exit(1)
 	}
 }
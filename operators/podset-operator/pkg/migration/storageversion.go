@@ -0,0 +1,201 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package migration moves the stored objects of a custom resource to its
+// storage version.
+package migration
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+)
+
+const (
+	// defaultRetryPeriod is how long to wait before retrying a failed
+	// migration.
+	defaultRetryPeriod = 30 * time.Second
+
+	// listPageSize bounds the number of objects listed at once.
+	listPageSize = 100
+)
+
+//+kubebuilder:rbac:groups=apiextensions.k8s.io,resources=customresourcedefinitions,verbs=get;list;watch
+//+kubebuilder:rbac:groups=apiextensions.k8s.io,resources=customresourcedefinitions/status,verbs=update;patch
+
+// StorageVersionMigrator rewrites every object of a custom resource so the
+// API server stores it in the storage version of the CRD, then drops the
+// other versions from the status.storedVersions of the CRD. Those versions
+// can be removed from the CRD afterwards.
+//
+// It runs once on the leader, retrying until the migration succeeds. Objects
+// are rewritten unchanged, going through the conversion and admission
+// webhooks like any other update.
+type StorageVersionMigrator struct {
+	// Client writes the objects and the CRD status.
+	Client client.Client
+	// Reader reads the CRD and lists the objects, it should not be cached
+	// to avoid an informer over every object.
+	Reader client.Reader
+	// CRDName is the name of the CustomResourceDefinition to migrate, e.g.
+	// "podsets.app.mriyam.com".
+	CRDName string
+	// RetryPeriod is how long to wait before retrying a failed migration.
+	// Defaults to 30s.
+	RetryPeriod time.Duration
+}
+
+// RejectedError is returned by Migrate when the API server rejected the
+// rewrite of some objects, e.g. because the validating webhook denied it.
+// The other objects are migrated, but the migration cannot complete until
+// the rejected ones are fixed or deleted.
+type RejectedError struct {
+	// Resource is the singular name of the custom resource, e.g. "podset".
+	Resource string
+	// Objects are the objects whose rewrite was rejected.
+	Objects []client.ObjectKey
+	// Err is the rejection of the first object.
+	Err error
+}
+
+func (e *RejectedError) Error() string {
+	names := make([]string, len(e.Objects))
+	for i, key := range e.Objects {
+		names[i] = key.String()
+	}
+	return fmt.Sprintf("rewriting %s %s was rejected, fix or delete them to complete the migration: %v",
+		e.Resource, strings.Join(names, ", "), e.Err)
+}
+
+func (e *RejectedError) Unwrap() error {
+	return e.Err
+}
+
+var (
+	_ manager.Runnable               = &StorageVersionMigrator{}
+	_ manager.LeaderElectionRunnable = &StorageVersionMigrator{}
+)
+
+// NeedLeaderElection implements manager.LeaderElectionRunnable, a single
+// replica is enough to migrate the objects.
+func (m *StorageVersionMigrator) NeedLeaderElection() bool {
+	return true
+}
+
+// Start implements manager.Runnable. It returns once the migration
+// succeeded, or when the context is cancelled.
+func (m *StorageVersionMigrator) Start(ctx context.Context) error {
+	logger := log.FromContext(ctx).WithName("storage-version-migrator").WithValues("crd", m.CRDName)
+	ctx = log.IntoContext(ctx, logger)
+
+	period := m.RetryPeriod
+	if period == 0 {
+		period = defaultRetryPeriod
+	}
+	err := wait.PollImmediateUntilWithContext(ctx, period, func(ctx context.Context) (bool, error) {
+		if err := m.Migrate(ctx); err != nil {
+			logger.Error(err, "Storage version migration failed, retrying", "retryPeriod", period)
+			return false, nil
+		}
+		return true, nil
+	})
+	if err != nil && ctx.Err() == nil {
+		return err
+	}
+	return nil
+}
+
+// Migrate makes a single attempt at migrating the objects.
+func (m *StorageVersionMigrator) Migrate(ctx context.Context) error {
+	logger := log.FromContext(ctx)
+
+	crd := &apiextensionsv1.CustomResourceDefinition{}
+	if err := m.Reader.Get(ctx, client.ObjectKey{Name: m.CRDName}, crd); err != nil {
+		return fmt.Errorf("getting CRD: %w", err)
+	}
+	storageVersion := ""
+	for _, version := range crd.Spec.Versions {
+		if version.Storage {
+			storageVersion = version.Name
+		}
+	}
+	if storageVersion == "" {
+		return fmt.Errorf("CRD %s has no storage version", m.CRDName)
+	}
+	if stored := crd.Status.StoredVersions; len(stored) == 1 && stored[0] == storageVersion {
+		logger.Info("Objects already stored in the storage version", "storageVersion", storageVersion)
+		return nil
+	}
+
+	gvk := schema.GroupVersionKind{Group: crd.Spec.Group, Version: storageVersion, Kind: crd.Spec.Names.ListKind}
+	migrated := 0
+	var rejected *RejectedError
+	list := &unstructured.UnstructuredList{}
+	list.SetGroupVersionKind(gvk)
+	for {
+		if err := m.Reader.List(ctx, list, client.Limit(listPageSize), client.Continue(list.GetContinue())); err != nil {
+			return fmt.Errorf("listing %s: %w", crd.Spec.Names.Plural, err)
+		}
+		for i := range list.Items {
+			obj := &list.Items[i]
+			// A conflict or a deletion means someone else wrote the object
+			// since, which stored it in the storage version already
+			err := m.Client.Update(ctx, obj)
+			switch {
+			case err == nil || apierrors.IsConflict(err) || apierrors.IsNotFound(err):
+				migrated++
+			case isRejected(err):
+				// Carry on with the other objects, this one needs a fix
+				if rejected == nil {
+					rejected = &RejectedError{Resource: crd.Spec.Names.Singular, Err: err}
+				}
+				rejected.Objects = append(rejected.Objects, client.ObjectKeyFromObject(obj))
+			default:
+				return fmt.Errorf("rewriting %s %s: %w", crd.Spec.Names.Singular, client.ObjectKeyFromObject(obj), err)
+			}
+		}
+		if list.GetContinue() == "" {
+			break
+		}
+	}
+
+	if rejected != nil {
+		return rejected
+	}
+
+	crd.Status.StoredVersions = []string{storageVersion}
+	if err := m.Client.Status().Update(ctx, crd); err != nil {
+		return fmt.Errorf("updating stored versions: %w", err)
+	}
+	logger.Info("Migrated objects to the storage version", "storageVersion", storageVersion, "objects", migrated)
+	return nil
+}
+
+// isRejected returns whether the API server refused the object itself, as
+// opposed to failing to handle the request.
+func isRejected(err error) bool {
+	return apierrors.IsInvalid(err) || apierrors.IsForbidden(err) || apierrors.IsBadRequest(err)
+}
@@ -0,0 +1,199 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package migration
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	appv1beta1 "github.com/mbtamuli/k8s/podset-operator/api/v1beta1"
+)
+
+const podSetCRDName = "podsets.app.mriyam.com"
+
+func newPodSetCRD(storedVersions ...string) *apiextensionsv1.CustomResourceDefinition {
+	return &apiextensionsv1.CustomResourceDefinition{
+		ObjectMeta: metav1.ObjectMeta{Name: podSetCRDName},
+		Spec: apiextensionsv1.CustomResourceDefinitionSpec{
+			Group: appv1beta1.GroupVersion.Group,
+			Names: apiextensionsv1.CustomResourceDefinitionNames{
+				Plural:   "podsets",
+				Singular: "podset",
+				Kind:     "PodSet",
+				ListKind: "PodSetList",
+			},
+			Scope: apiextensionsv1.NamespaceScoped,
+			Versions: []apiextensionsv1.CustomResourceDefinitionVersion{
+				{Name: "v1alpha1", Served: true},
+				{Name: "v1beta1", Served: true, Storage: true},
+			},
+		},
+		Status: apiextensionsv1.CustomResourceDefinitionStatus{StoredVersions: storedVersions},
+	}
+}
+
+func newMigrator(t *testing.T, objs ...client.Object) *StorageVersionMigrator {
+	t.Helper()
+	scheme := runtime.NewScheme()
+	if err := apiextensionsv1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := appv1beta1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(objs...).Build()
+	return &StorageVersionMigrator{Client: c, Reader: c, CRDName: podSetCRDName}
+}
+
+func TestMigrate(t *testing.T) {
+	podSets := []*appv1beta1.PodSet{
+		{ObjectMeta: metav1.ObjectMeta{Name: "a", Namespace: "default"}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "b", Namespace: "other"}},
+	}
+	objs := []client.Object{newPodSetCRD("v1alpha1", "v1beta1")}
+	for _, podSet := range podSets {
+		objs = append(objs, podSet)
+	}
+	m := newMigrator(t, objs...)
+	ctx := context.Background()
+
+	before := map[string]string{}
+	for _, podSet := range podSets {
+		got := &appv1beta1.PodSet{}
+		if err := m.Reader.Get(ctx, client.ObjectKeyFromObject(podSet), got); err != nil {
+			t.Fatal(err)
+		}
+		before[podSet.Name] = got.ResourceVersion
+	}
+
+	if err := m.Migrate(ctx); err != nil {
+		t.Fatalf("Migrate() error = %v", err)
+	}
+
+	crd := &apiextensionsv1.CustomResourceDefinition{}
+	if err := m.Reader.Get(ctx, client.ObjectKey{Name: podSetCRDName}, crd); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"v1beta1"}; !reflect.DeepEqual(crd.Status.StoredVersions, want) {
+		t.Errorf("StoredVersions = %v, want %v", crd.Status.StoredVersions, want)
+	}
+	for _, podSet := range podSets {
+		got := &appv1beta1.PodSet{}
+		if err := m.Reader.Get(ctx, client.ObjectKeyFromObject(podSet), got); err != nil {
+			t.Fatal(err)
+		}
+		if got.ResourceVersion == before[podSet.Name] {
+			t.Errorf("expected PodSet %s to be rewritten", podSet.Name)
+		}
+	}
+}
+
+// rejectingClient plays the part of a validating webhook denying the
+// updates of one object.
+type rejectingClient struct {
+	client.Client
+	rejected client.ObjectKey
+}
+
+func (c rejectingClient) Update(ctx context.Context, obj client.Object, opts ...client.UpdateOption) error {
+	if client.ObjectKeyFromObject(obj) == c.rejected {
+		return apierrors.NewInvalid(schema.GroupKind{Group: appv1beta1.GroupVersion.Group, Kind: "PodSet"}, obj.GetName(),
+			field.ErrorList{field.Forbidden(field.NewPath("spec", "selector"), "field is immutable")})
+	}
+	return c.Client.Update(ctx, obj, opts...)
+}
+
+func TestMigrateRejected(t *testing.T) {
+	accepted := &appv1beta1.PodSet{ObjectMeta: metav1.ObjectMeta{Name: "a", Namespace: "default"}}
+	rejected := &appv1beta1.PodSet{ObjectMeta: metav1.ObjectMeta{Name: "b", Namespace: "default"}}
+	m := newMigrator(t, newPodSetCRD("v1alpha1", "v1beta1"), accepted, rejected)
+	m.Client = rejectingClient{Client: m.Client, rejected: client.ObjectKeyFromObject(rejected)}
+	ctx := context.Background()
+
+	before := &appv1beta1.PodSet{}
+	if err := m.Reader.Get(ctx, client.ObjectKeyFromObject(accepted), before); err != nil {
+		t.Fatal(err)
+	}
+
+	err := m.Migrate(ctx)
+	var rejectedErr *RejectedError
+	if !errors.As(err, &rejectedErr) {
+		t.Fatalf("Migrate() error = %v, want a RejectedError", err)
+	}
+	if want := []client.ObjectKey{client.ObjectKeyFromObject(rejected)}; !reflect.DeepEqual(rejectedErr.Objects, want) {
+		t.Errorf("rejected objects = %v, want %v", rejectedErr.Objects, want)
+	}
+	if !strings.Contains(err.Error(), "podset default/b") || !apierrors.IsInvalid(err) {
+		t.Errorf("Migrate() error = %v, want the Invalid error of podset default/b", err)
+	}
+
+	// The other objects are migrated, the stored versions are left alone
+	got := &appv1beta1.PodSet{}
+	if err := m.Reader.Get(ctx, client.ObjectKeyFromObject(accepted), got); err != nil {
+		t.Fatal(err)
+	}
+	if got.ResourceVersion == before.ResourceVersion {
+		t.Errorf("expected PodSet %s to be rewritten", accepted.Name)
+	}
+	crd := &apiextensionsv1.CustomResourceDefinition{}
+	if err := m.Reader.Get(ctx, client.ObjectKey{Name: podSetCRDName}, crd); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"v1alpha1", "v1beta1"}; !reflect.DeepEqual(crd.Status.StoredVersions, want) {
+		t.Errorf("StoredVersions = %v, want %v", crd.Status.StoredVersions, want)
+	}
+}
+
+func TestMigrateAlreadyMigrated(t *testing.T) {
+	podSet := &appv1beta1.PodSet{ObjectMeta: metav1.ObjectMeta{Name: "a", Namespace: "default"}}
+	m := newMigrator(t, newPodSetCRD("v1beta1"), podSet)
+	ctx := context.Background()
+
+	before := &appv1beta1.PodSet{}
+	if err := m.Reader.Get(ctx, client.ObjectKeyFromObject(podSet), before); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Migrate(ctx); err != nil {
+		t.Fatalf("Migrate() error = %v", err)
+	}
+	got := &appv1beta1.PodSet{}
+	if err := m.Reader.Get(ctx, client.ObjectKeyFromObject(podSet), got); err != nil {
+		t.Fatal(err)
+	}
+	if got.ResourceVersion != before.ResourceVersion {
+		t.Errorf("expected the PodSet to be left alone once migrated")
+	}
+}
+
+func TestMigrateMissingCRD(t *testing.T) {
+	m := newMigrator(t)
+	if err := m.Migrate(context.Background()); err == nil {
+		t.Error("Migrate() succeeded without the CRD, want an error")
+	}
+}
@@ -0,0 +1,439 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package webhooks holds the admission webhooks of PodSet. They live
+// outside of the API packages so that the types carry no settings.
+package webhooks
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	apiequality "k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	metav1validation "k8s.io/apimachinery/pkg/apis/meta/v1/validation"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	ctrl "sigs.k8s.io/controller-runtime"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	ctrlmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	"github.com/mbtamuli/k8s/common/imagepolicy"
+	"github.com/mbtamuli/k8s/common/logging"
+	"github.com/mbtamuli/k8s/common/metrics"
+	"github.com/mbtamuli/k8s/common/tracing"
+	appv1alpha1 "github.com/mbtamuli/k8s/podset-operator/api/v1alpha1"
+)
+
+// log is for logging in this package.
+var podsetlog = logf.Log.WithName("podset-resource")
+
+// imagePolicyTimeout bounds the time spent reading the image policy and
+// resolving digests, well within the 10s admission timeout.
+const imagePolicyTimeout = 5 * time.Second
+
+// Paths the webhooks are served on, as generated for the markers below.
+const (
+	mutatePodSetPath   = "/mutate-app-mriyam-com-v1alpha1-podset"
+	validatePodSetPath = "/validate-app-mriyam-com-v1alpha1-podset"
+)
+
+// PodSetWebhook defaults and validates PodSets, enforcing the image policy.
+// The API server converts v1beta1 requests to v1alpha1 for it through the
+// conversion webhook.
+type PodSetWebhook struct {
+	// DefaultImage is the image of the containers that do not name one
+	DefaultImage string
+	// ImagePolicy is the image policy the images are checked against
+	ImagePolicy imagepolicy.Source
+	// Resolver pins image tags to digests when the image policy asks for it
+	Resolver imagepolicy.Resolver
+
+	decoder    *admission.Decoder
+	admissions *metrics.Admissions
+}
+
+// SetupWithManager registers the defaulting and validating webhooks of
+// PodSet with the manager, along with the conversion webhook.
+func (w *PodSetWebhook) SetupWithManager(mgr ctrl.Manager) error {
+	decoder, err := admission.NewDecoder(mgr.GetScheme())
+	if err != nil {
+		return err
+	}
+	w.decoder = decoder
+	// The requests are counted where they are served, registering the
+	// collectors along with the handlers
+	w.admissions = metrics.NewAdmissions("podset", "PodSet")
+	for _, c := range w.admissions.Collectors() {
+		if err := ctrlmetrics.Registry.Register(c); err != nil {
+			return err
+		}
+	}
+
+	server := mgr.GetWebhookServer()
+	server.Register(mutatePodSetPath, &webhook.Admission{Handler: admission.HandlerFunc(w.handleDefault)})
+	server.Register(validatePodSetPath, &webhook.Admission{Handler: admission.HandlerFunc(w.handleValidate)})
+	// PodSet is neither a Defaulter nor a Validator, only the conversion
+	// webhook is registered
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(&appv1alpha1.PodSet{}).
+		Complete()
+}
+
+//+kubebuilder:webhook:path=/mutate-app-mriyam-com-v1alpha1-podset,mutating=true,failurePolicy=fail,sideEffects=None,groups=app.mriyam.com,resources=podsets,verbs=create;update,versions=v1alpha1,name=mpodset.kb.io,admissionReviewVersions=v1
+
+// handleDefault responds to the requests of the mutating webhook with a
+// patch applying the defaults.
+func (w *PodSetWebhook) handleDefault(ctx context.Context, req admission.Request) admission.Response {
+	podSet := &appv1alpha1.PodSet{}
+	if err := w.decoder.Decode(req, podSet); err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+	var old *appv1alpha1.PodSet
+	if req.Operation == admissionv1.Update {
+		old = &appv1alpha1.PodSet{}
+		if err := w.decoder.DecodeRaw(req.OldObject, old); err != nil {
+			return admission.Errored(http.StatusBadRequest, err)
+		}
+	}
+	if err := w.Default(ctx, old, podSet); err != nil {
+		return admission.Denied(err.Error())
+	}
+	marshalled, err := json.Marshal(podSet)
+	if err != nil {
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+	return admission.PatchResponseFromRaw(req.Object.Raw, marshalled)
+}
+
+// Default applies the defaults to the PodSet, old is the stored PodSet on
+// UPDATE and nil on CREATE. Only unset fields are defaulted, so applying the
+// defaults again, e.g. on UPDATE, changes nothing. An error is returned when
+// an image the image policy asks to pin cannot be resolved.
+func (w *PodSetWebhook) Default(ctx context.Context, old, podSet *appv1alpha1.PodSet) error {
+	podsetlog.V(logging.Debug).Info("default", "namespace", podSet.Namespace, "name", podSet.Name)
+	ctx, span := tracing.Tracer().Start(ctx, "PodSet.Default", trace.WithAttributes(
+		tracing.KindKey.String("PodSet"),
+		tracing.NamespaceKey.String(podSet.Namespace),
+		tracing.NameKey.String(podSet.Name),
+	))
+	defer span.End()
+
+	if podSet.Spec.Replicas == nil {
+		replicas := int32(1)
+		podSet.Spec.Replicas = &replicas
+	}
+
+	// PodSets created through generateName are not named yet
+	if podSet.Name != "" {
+		if podSet.Spec.Template.Labels == nil {
+			podSet.Spec.Template.Labels = map[string]string{}
+		}
+		if _, ok := podSet.Spec.Template.Labels[appv1alpha1.PodSetLabel]; !ok {
+			podSet.Spec.Template.Labels[appv1alpha1.PodSetLabel] = podSet.Name
+		}
+	}
+
+	if podSet.Spec.Selector == nil && old != nil {
+		// PodSets stored without a selector, before the webhooks were
+		// installed, keep selecting their pods by the stored template labels
+		podSet.Spec.Selector = selectorOf(old)
+	}
+	if podSet.Spec.Selector == nil {
+		podSet.Spec.Selector = selectorOf(podSet)
+	}
+
+	podSpec := &podSet.Spec.Template.Spec
+	if podSpec.RestartPolicy == "" {
+		podSpec.RestartPolicy = corev1.RestartPolicyAlways
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, imagePolicyTimeout)
+	defer cancel()
+	policy, err := w.ImagePolicy.ImagePolicy(ctx)
+	if err != nil {
+		// The validating webhook rejects the object then
+		podsetlog.Error(err, "unable to read the image policy", "namespace", podSet.Namespace, "name", podSet.Name)
+		tracing.RecordError(span, err)
+	}
+	var storedImages map[string]bool
+	if old != nil {
+		storedImages = podImages(&old.Spec.Template.Spec)
+	}
+	for i := range podSpec.InitContainers {
+		if err := w.defaultContainer(ctx, policy, storedImages, &podSpec.InitContainers[i]); err != nil {
+			tracing.RecordError(span, err)
+			return err
+		}
+	}
+	for i := range podSpec.Containers {
+		if err := w.defaultContainer(ctx, policy, storedImages, &podSpec.Containers[i]); err != nil {
+			tracing.RecordError(span, err)
+			return err
+		}
+	}
+	return nil
+}
+
+// defaultContainer sets the default image on containers without one and
+// pins the image to a digest if the policy asks for it and storedImages does
+// not have it, so that an update does not roll out newer digests of
+// unchanged tags. It then sets the pull policy the way the API server does
+// for pods: Always for the latest tag, IfNotPresent otherwise. Setting it in
+// the PodSet makes it visible before any pod exists.
+func (w *PodSetWebhook) defaultContainer(ctx context.Context, policy *imagepolicy.Policy, storedImages map[string]bool, container *corev1.Container) error {
+	if container.Image == "" {
+		container.Image = w.DefaultImage
+	}
+	pinned, err := policy.PinChanged(ctx, w.Resolver, container.Image, storedImages)
+	if err != nil {
+		return fmt.Errorf("the image policy requires pinning images to a digest: %w", err)
+	}
+	container.Image = pinned
+	ref, err := imagepolicy.ParseReference(container.Image)
+	if err != nil {
+		// Reported by the validating webhook
+		return nil
+	}
+
+	if container.ImagePullPolicy != "" {
+		return nil
+	}
+	if ref.IsLatest() {
+		container.ImagePullPolicy = corev1.PullAlways
+	} else {
+		container.ImagePullPolicy = corev1.PullIfNotPresent
+	}
+	return nil
+}
+
+// maxReplicas is the largest number of pods a single PodSet may ask for.
+// The CRD schema enforces it too, as the scale subresource bypasses this
+// webhook.
+const maxReplicas = 1000
+
+//+kubebuilder:webhook:path=/validate-app-mriyam-com-v1alpha1-podset,mutating=false,failurePolicy=fail,sideEffects=None,groups=app.mriyam.com,resources=podsets,verbs=create;update;delete,versions=v1alpha1,name=vpodset.kb.io,admissionReviewVersions=v1
+
+// handleValidate responds to the requests of the validating webhook.
+func (w *PodSetWebhook) handleValidate(ctx context.Context, req admission.Request) admission.Response {
+	if req.Operation == admissionv1.Delete {
+		// DELETE requests carry the stored object only
+		old := &appv1alpha1.PodSet{}
+		if err := w.decoder.DecodeRaw(req.OldObject, old); err != nil {
+			return admission.Errored(http.StatusBadRequest, err)
+		}
+		err := w.ValidateDelete(ctx, old)
+		w.admissions.Reviewed("delete", err)
+		return validationResponse(err)
+	}
+
+	podSet := &appv1alpha1.PodSet{}
+	if err := w.decoder.Decode(req, podSet); err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+	var err error
+	switch req.Operation {
+	case admissionv1.Create:
+		err = w.ValidateCreate(ctx, podSet)
+		w.admissions.Reviewed("create", err)
+	case admissionv1.Update:
+		old := &appv1alpha1.PodSet{}
+		if err := w.decoder.DecodeRaw(req.OldObject, old); err != nil {
+			return admission.Errored(http.StatusBadRequest, err)
+		}
+		err = w.ValidateUpdate(ctx, old, podSet)
+		w.admissions.Reviewed("update", err)
+	}
+	return validationResponse(err)
+}
+
+// ValidateCreate returns an Invalid error listing every problem with a new
+// PodSet, or nil.
+func (w *PodSetWebhook) ValidateCreate(ctx context.Context, podSet *appv1alpha1.PodSet) error {
+	podsetlog.V(logging.Debug).Info("validate create", "namespace", podSet.Namespace, "name", podSet.Name)
+
+	allErrs, err := w.validatePodSet(ctx, podSet, nil)
+	if err != nil {
+		return err
+	}
+	return toInvalid(podSet, allErrs)
+}
+
+// ValidateUpdate returns an Invalid error listing every problem with an
+// updated PodSet, or nil.
+func (w *PodSetWebhook) ValidateUpdate(ctx context.Context, old, podSet *appv1alpha1.PodSet) error {
+	podsetlog.V(logging.Debug).Info("validate update", "namespace", podSet.Namespace, "name", podSet.Name)
+
+	allErrs, err := w.validatePodSet(ctx, podSet, podImages(&old.Spec.Template.Spec))
+	if err != nil {
+		return err
+	}
+	// Changing the selector would orphan the pods selected so far. A PodSet
+	// stored with neither a selector nor template labels has none to keep.
+	if oldSelector := selectorOf(old); oldSelector != nil && !apiequality.Semantic.DeepEqual(selectorOf(podSet), oldSelector) {
+		allErrs = append(allErrs, field.Forbidden(field.NewPath("spec", "selector"), "field is immutable"))
+	}
+	return toInvalid(podSet, allErrs)
+}
+
+// ValidateDelete allows deleting any PodSet, its pods are garbage collected
+// along with it.
+func (w *PodSetWebhook) ValidateDelete(ctx context.Context, podSet *appv1alpha1.PodSet) error {
+	podsetlog.V(logging.Debug).Info("validate delete", "namespace", podSet.Namespace, "name", podSet.Name)
+
+	return nil
+}
+
+// validatePodSet returns the errors common to creations and updates. Images
+// in oldImages were admitted before, they are not checked against the image
+// policy again so that a stricter policy does not block unrelated changes.
+// The controller reports them instead.
+func (w *PodSetWebhook) validatePodSet(ctx context.Context, podSet *appv1alpha1.PodSet, oldImages map[string]bool) (field.ErrorList, error) {
+	ctx, cancel := context.WithTimeout(ctx, imagePolicyTimeout)
+	defer cancel()
+	policy, err := w.ImagePolicy.ImagePolicy(ctx)
+	if err != nil {
+		return nil, apierrors.NewInternalError(fmt.Errorf("reading the image policy: %w", err))
+	}
+	validateImage := func(image string, fldPath *field.Path) field.ErrorList {
+		if strings.TrimSpace(image) == "" {
+			return field.ErrorList{field.Required(fldPath, "")}
+		}
+		if _, err := imagepolicy.ParseReference(image); err != nil {
+			return field.ErrorList{field.Invalid(fldPath, image, err.Error())}
+		}
+		if err := policy.Check(image); err != nil && !oldImages[image] {
+			return field.ErrorList{field.Forbidden(fldPath, err.Error())}
+		}
+		return nil
+	}
+
+	var allErrs field.ErrorList
+	specPath := field.NewPath("spec")
+
+	// The name is the value of the PodSet label of the pods, which is
+	// shorter than a name may be. PodSets created through generateName are
+	// not named yet.
+	if podSet.Name != "" {
+		for _, msg := range validation.IsValidLabelValue(podSet.Name) {
+			allErrs = append(allErrs, field.Invalid(field.NewPath("metadata", "name"), podSet.Name, msg))
+		}
+	}
+
+	if podSet.Spec.Replicas != nil && (*podSet.Spec.Replicas < 0 || *podSet.Spec.Replicas > maxReplicas) {
+		allErrs = append(allErrs, field.Invalid(specPath.Child("replicas"), *podSet.Spec.Replicas,
+			fmt.Sprintf("must be between 0 and %d", maxReplicas)))
+	}
+
+	templatePath := specPath.Child("template")
+	templateLabels := podSet.Spec.Template.Labels
+	allErrs = append(allErrs, metav1validation.ValidateLabels(templateLabels, templatePath.Child("metadata", "labels"))...)
+
+	selectorPath := specPath.Child("selector")
+	if podSet.Spec.Selector == nil {
+		if len(templateLabels) == 0 {
+			allErrs = append(allErrs, field.Required(selectorPath, "must be set when the template has no labels"))
+		}
+	} else {
+		allErrs = append(allErrs, metav1validation.ValidateLabelSelector(podSet.Spec.Selector, selectorPath)...)
+		selector, err := metav1.LabelSelectorAsSelector(podSet.Spec.Selector)
+		switch {
+		case err != nil:
+			// Reported by ValidateLabelSelector
+		case selector.Empty():
+			allErrs = append(allErrs, field.Invalid(selectorPath, podSet.Spec.Selector, "empty selector is invalid for PodSet"))
+		case !selector.Matches(labels.Set(templateLabels)):
+			allErrs = append(allErrs, field.Invalid(templatePath.Child("metadata", "labels"), templateLabels,
+				"`selector` does not match template `labels`"))
+		}
+	}
+
+	containersPath := templatePath.Child("spec", "containers")
+	if len(podSet.Spec.Template.Spec.Containers) == 0 {
+		allErrs = append(allErrs, field.Required(containersPath, ""))
+	}
+	for i, c := range podSet.Spec.Template.Spec.Containers {
+		allErrs = append(allErrs, validateImage(c.Image, containersPath.Index(i).Child("image"))...)
+	}
+	initContainersPath := templatePath.Child("spec", "initContainers")
+	for i, c := range podSet.Spec.Template.Spec.InitContainers {
+		allErrs = append(allErrs, validateImage(c.Image, initContainersPath.Index(i).Child("image"))...)
+	}
+
+	return allErrs, nil
+}
+
+// selectorOf returns the selector the controller selects the pods of the
+// PodSet with: its selector, or one matching its template labels when it has
+// none. It is nil when there is neither.
+func selectorOf(podSet *appv1alpha1.PodSet) *metav1.LabelSelector {
+	if podSet.Spec.Selector != nil || len(podSet.Spec.Template.Labels) == 0 {
+		return podSet.Spec.Selector
+	}
+	matchLabels := make(map[string]string, len(podSet.Spec.Template.Labels))
+	for k, v := range podSet.Spec.Template.Labels {
+		matchLabels[k] = v
+	}
+	return &metav1.LabelSelector{MatchLabels: matchLabels}
+}
+
+// podImages returns the images of the containers of the pod spec.
+func podImages(spec *corev1.PodSpec) map[string]bool {
+	images := map[string]bool{}
+	for _, c := range spec.InitContainers {
+		images[c.Image] = true
+	}
+	for _, c := range spec.Containers {
+		images[c.Image] = true
+	}
+	return images
+}
+
+// toInvalid wraps the errors in an Invalid status error, or returns nil when
+// there are none.
+func toInvalid(podSet *appv1alpha1.PodSet, allErrs field.ErrorList) error {
+	if len(allErrs) == 0 {
+		return nil
+	}
+	return apierrors.NewInvalid(appv1alpha1.GroupVersion.WithKind("PodSet").GroupKind(), podSet.Name, allErrs)
+}
+
+// validationResponse allows the request when err is nil, and denies it with
+// the status of err otherwise, the way controller-runtime does for the
+// webhook.Validator types.
+func validationResponse(err error) admission.Response {
+	if err == nil {
+		return admission.Allowed("")
+	}
+	var apiStatus apierrors.APIStatus
+	if errors.As(err, &apiStatus) {
+		status := apiStatus.Status()
+		return admission.Response{AdmissionResponse: admissionv1.AdmissionResponse{Allowed: false, Result: &status}}
+	}
+	return admission.Denied(err.Error())
+}
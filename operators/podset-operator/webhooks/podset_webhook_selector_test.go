@@ -0,0 +1,63 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhooks
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/mbtamuli/k8s/common/imagepolicy"
+	appv1alpha1 "github.com/mbtamuli/k8s/podset-operator/api/v1alpha1"
+)
+
+func TestUpdateWithoutStoredSelector(t *testing.T) {
+	ctx := context.Background()
+	w := &PodSetWebhook{ImagePolicy: imagepolicy.StaticSource{}}
+	old := &appv1alpha1.PodSet{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"},
+		Spec: appv1alpha1.PodSetSpec{
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{"app": "web"}},
+				Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "app", Image: "nginx:1.21"}}},
+			},
+		},
+	}
+	podSet := old.DeepCopy()
+	replicas := int32(2)
+	podSet.Spec.Replicas = &replicas
+
+	if err := w.Default(ctx, old, podSet); err != nil {
+		t.Fatalf("Default() error = %v", err)
+	}
+	// The pods selected by the template labels so far stay selected
+	want := &metav1.LabelSelector{MatchLabels: map[string]string{"app": "web"}}
+	if !reflect.DeepEqual(podSet.Spec.Selector, want) {
+		t.Errorf("Default() set the selector to %v, want %v", podSet.Spec.Selector, want)
+	}
+	if err := w.ValidateUpdate(ctx, old, podSet); err != nil {
+		t.Errorf("ValidateUpdate() error = %v", err)
+	}
+
+	podSet.Spec.Selector = &metav1.LabelSelector{MatchLabels: podSet.Spec.Template.Labels}
+	if err := w.ValidateUpdate(ctx, old, podSet); err == nil {
+		t.Error("ValidateUpdate() allowed a selector other than the template labels of the stored PodSet")
+	}
+}
@@ -0,0 +1,395 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhooks
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/mbtamuli/k8s/common/imagepolicy"
+	appv1alpha1 "github.com/mbtamuli/k8s/podset-operator/api/v1alpha1"
+	appv1beta1 "github.com/mbtamuli/k8s/podset-operator/api/v1beta1"
+)
+
+var _ = Describe("PodSet defaulting webhook", func() {
+	const namespace = "default"
+
+	newPodSet := func(name string, mutate func(*appv1alpha1.PodSet)) *appv1alpha1.PodSet {
+		podSet := &appv1alpha1.PodSet{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+			Spec: appv1alpha1.PodSetSpec{
+				Template: corev1.PodTemplateSpec{
+					Spec: corev1.PodSpec{
+						Containers: []corev1.Container{{Name: "app", Image: "nginx:1.21"}},
+					},
+				},
+			},
+		}
+		if mutate != nil {
+			mutate(podSet)
+		}
+		return podSet
+	}
+
+	int32Ptr := func(i int32) *int32 { return &i }
+
+	DescribeTable("defaults the admitted object on CREATE",
+		func(podSet *appv1alpha1.PodSet, check func(*appv1alpha1.PodSet)) {
+			Expect(k8sClient.Create(ctx, podSet)).To(Succeed())
+			admitted := &appv1alpha1.PodSet{}
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: podSet.Name, Namespace: namespace}, admitted)).To(Succeed())
+			check(admitted)
+		},
+		Entry("replicas", newPodSet("default-replicas", nil), func(p *appv1alpha1.PodSet) {
+			Expect(p.Spec.Replicas).To(Equal(int32Ptr(1)))
+		}),
+		Entry("explicit replicas", newPodSet("explicit-replicas", func(p *appv1alpha1.PodSet) {
+			p.Spec.Replicas = int32Ptr(0)
+		}), func(p *appv1alpha1.PodSet) {
+			Expect(p.Spec.Replicas).To(Equal(int32Ptr(0)))
+		}),
+		Entry("podset label and selector", newPodSet("default-selector", func(p *appv1alpha1.PodSet) {
+			p.Spec.Template.Labels = map[string]string{"app": "web"}
+		}), func(p *appv1alpha1.PodSet) {
+			want := map[string]string{"app": "web", appv1alpha1.PodSetLabel: "default-selector"}
+			Expect(p.Spec.Template.Labels).To(Equal(want))
+			Expect(p.Spec.Selector).To(Equal(&metav1.LabelSelector{MatchLabels: want}))
+		}),
+		Entry("explicit selector", newPodSet("explicit-selector", func(p *appv1alpha1.PodSet) {
+			p.Spec.Template.Labels = map[string]string{"app": "web"}
+			p.Spec.Selector = &metav1.LabelSelector{MatchLabels: map[string]string{"app": "web"}}
+		}), func(p *appv1alpha1.PodSet) {
+			Expect(p.Spec.Selector).To(Equal(&metav1.LabelSelector{MatchLabels: map[string]string{"app": "web"}}))
+		}),
+		Entry("restart policy", newPodSet("default-restart-policy", nil), func(p *appv1alpha1.PodSet) {
+			Expect(p.Spec.Template.Spec.RestartPolicy).To(Equal(corev1.RestartPolicyAlways))
+		}),
+		Entry("explicit restart policy", newPodSet("explicit-restart-policy", func(p *appv1alpha1.PodSet) {
+			p.Spec.Template.Spec.RestartPolicy = corev1.RestartPolicyOnFailure
+		}), func(p *appv1alpha1.PodSet) {
+			Expect(p.Spec.Template.Spec.RestartPolicy).To(Equal(corev1.RestartPolicyOnFailure))
+		}),
+		Entry("image pull policies", newPodSet("default-pull-policy", func(p *appv1alpha1.PodSet) {
+			p.Spec.Template.Spec.InitContainers = []corev1.Container{{Name: "init", Image: "busybox"}}
+			p.Spec.Template.Spec.Containers = []corev1.Container{
+				{Name: "tagged", Image: "nginx:1.21"},
+				{Name: "latest", Image: "registry.example.com:5000/nginx:latest"},
+				{Name: "digest", Image: "nginx@sha256:0d17b565c37bcbd895e9d92315a05c1c3c9a29f762b011a10c54a66cd53c9b31"},
+				{Name: "explicit", Image: "nginx", ImagePullPolicy: corev1.PullNever},
+			}
+		}), func(p *appv1alpha1.PodSet) {
+			Expect(p.Spec.Template.Spec.InitContainers[0].ImagePullPolicy).To(Equal(corev1.PullAlways))
+			var policies []corev1.PullPolicy
+			for _, c := range p.Spec.Template.Spec.Containers {
+				policies = append(policies, c.ImagePullPolicy)
+			}
+			Expect(policies).To(Equal([]corev1.PullPolicy{corev1.PullIfNotPresent, corev1.PullAlways, corev1.PullIfNotPresent, corev1.PullNever}))
+		}),
+	)
+
+	It("applies the same defaults on UPDATE", func() {
+		podSet := newPodSet("idempotent", nil)
+		Expect(k8sClient.Create(ctx, podSet)).To(Succeed())
+		key := types.NamespacedName{Name: podSet.Name, Namespace: namespace}
+		created := &appv1alpha1.PodSet{}
+		Expect(k8sClient.Get(ctx, key, created)).To(Succeed())
+
+		By("updating without changes")
+		updated := created.DeepCopy()
+		Expect(k8sClient.Update(ctx, updated)).To(Succeed())
+		Expect(updated.Spec).To(Equal(created.Spec))
+		Expect(updated.Generation).To(Equal(created.Generation))
+
+		By("clearing defaulted fields")
+		updated.Spec.Replicas = nil
+		updated.Spec.Template.Spec.RestartPolicy = ""
+		updated.Spec.Template.Spec.Containers[0].ImagePullPolicy = ""
+		Expect(k8sClient.Update(ctx, updated)).To(Succeed())
+		Expect(updated.Spec).To(Equal(created.Spec))
+	})
+})
+
+var _ = Describe("PodSet validating webhook", func() {
+	const namespace = "default"
+
+	newPodSet := func(name string, mutate func(*appv1alpha1.PodSet)) *appv1alpha1.PodSet {
+		podSet := &appv1alpha1.PodSet{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+			Spec: appv1alpha1.PodSetSpec{
+				Template: corev1.PodTemplateSpec{
+					ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{"app": "web"}},
+					Spec: corev1.PodSpec{
+						Containers: []corev1.Container{{Name: "app", Image: "nginx:1.21"}},
+					},
+				},
+			},
+		}
+		if mutate != nil {
+			mutate(podSet)
+		}
+		return podSet
+	}
+
+	DescribeTable("rejects invalid objects on CREATE",
+		func(podSet *appv1alpha1.PodSet, path string) {
+			err := k8sClient.Create(ctx, podSet)
+			Expect(apierrors.IsInvalid(err)).To(BeTrue(), "expected an Invalid error, got %v", err)
+			Expect(err.Error()).To(ContainSubstring(path))
+		},
+		Entry("name too long for a label value", newPodSet(strings.Repeat("a", 64), nil), "metadata.name"),
+		Entry("too many replicas", newPodSet("too-many-replicas", func(p *appv1alpha1.PodSet) {
+			replicas := int32(maxReplicas + 1)
+			p.Spec.Replicas = &replicas
+		}), "spec.replicas"),
+		Entry("selector not matching the template", newPodSet("mismatched-selector", func(p *appv1alpha1.PodSet) {
+			p.Spec.Selector = &metav1.LabelSelector{MatchLabels: map[string]string{"app": "db"}}
+		}), "spec.template.metadata.labels"),
+		Entry("invalid template label", newPodSet("invalid-label", func(p *appv1alpha1.PodSet) {
+			p.Spec.Template.Labels["app"] = "not a label value"
+		}), "spec.template.metadata.labels"),
+		Entry("no containers", newPodSet("no-containers", func(p *appv1alpha1.PodSet) {
+			p.Spec.Template.Spec.Containers = nil
+		}), "spec.template.spec.containers"),
+		Entry("empty image", newPodSet("empty-image", func(p *appv1alpha1.PodSet) {
+			p.Spec.Template.Spec.Containers[0].Image = ""
+		}), "spec.template.spec.containers[0].image"),
+		Entry("empty init container image", newPodSet("empty-init-image", func(p *appv1alpha1.PodSet) {
+			p.Spec.Template.Spec.InitContainers = []corev1.Container{{Name: "init", Image: " "}}
+		}), "spec.template.spec.initContainers[0].image"),
+	)
+
+	It("accepts a valid object", func() {
+		Expect(k8sClient.Create(ctx, newPodSet("valid", nil))).To(Succeed())
+	})
+
+	It("validates the template against the pod schema", func() {
+		podSet := newPodSet("template-schema", nil)
+		patch := []byte(`{"apiVersion":"app.mriyam.com/v1alpha1","kind":"PodSet",` +
+			`"metadata":{"name":"template-schema","namespace":"default"},"spec":{"template":{"spec":{"containers":[` +
+			`{"name":"app","image":"nginx:1.21","ports":[{"containerPort":"http"}]}]}}}}`)
+		err := k8sClient.Patch(ctx, podSet, client.RawPatch(types.ApplyPatchType, patch), client.FieldOwner("test"))
+		Expect(apierrors.IsInvalid(err)).To(BeTrue(), "expected an Invalid error, got %v", err)
+		Expect(err.Error()).To(ContainSubstring("spec.template.spec.containers[0].ports[0].containerPort"))
+	})
+
+	It("updates a PodSet stored without a selector", func() {
+		// PodSets created before the webhooks were installed have no
+		// selector, the controller selects their pods by the template labels
+		mutating := &admissionregistrationv1.MutatingWebhookConfiguration{}
+		Expect(k8sClient.Get(ctx, types.NamespacedName{Name: "mutating-webhook-configuration"}, mutating)).To(Succeed())
+		Expect(k8sClient.Delete(ctx, mutating)).To(Succeed())
+		restored := false
+		restore := func() {
+			if restored {
+				return
+			}
+			restored = true
+			mutating.ResourceVersion = ""
+			Expect(k8sClient.Create(ctx, mutating)).To(Succeed())
+		}
+		defer restore()
+
+		podSet := newPodSet("stored-without-selector", nil)
+		// The API server calls the webhook until it sees the deletion
+		Eventually(func() (*metav1.LabelSelector, error) {
+			stored := podSet.DeepCopy()
+			if err := k8sClient.Create(ctx, stored); err != nil {
+				return nil, err
+			}
+			if stored.Spec.Selector != nil {
+				return stored.Spec.Selector, k8sClient.Delete(ctx, stored)
+			}
+			return nil, nil
+		}).Should(BeNil())
+		restore()
+
+		key := types.NamespacedName{Name: podSet.Name, Namespace: namespace}
+		Eventually(func() (*metav1.LabelSelector, error) {
+			updated := &appv1alpha1.PodSet{}
+			if err := k8sClient.Get(ctx, key, updated); err != nil {
+				return nil, err
+			}
+			replicas := int32(2)
+			updated.Spec.Replicas = &replicas
+			if err := k8sClient.Update(ctx, updated); err != nil {
+				return nil, err
+			}
+			return updated.Spec.Selector, nil
+		}).Should(Equal(&metav1.LabelSelector{MatchLabels: map[string]string{"app": "web"}}))
+	})
+
+	It("rejects selector changes on UPDATE", func() {
+		podSet := newPodSet("immutable-selector", nil)
+		Expect(k8sClient.Create(ctx, podSet)).To(Succeed())
+
+		podSet.Spec.Template.Labels["tier"] = "frontend"
+		podSet.Spec.Selector = &metav1.LabelSelector{MatchLabels: podSet.Spec.Template.Labels}
+		err := k8sClient.Update(ctx, podSet)
+		Expect(apierrors.IsInvalid(err)).To(BeTrue(), "expected an Invalid error, got %v", err)
+		Expect(err.Error()).To(ContainSubstring("spec.selector"))
+	})
+
+	It("allows deleting a PodSet", func() {
+		podSet := newPodSet("deleted", nil)
+		Expect(k8sClient.Create(ctx, podSet)).To(Succeed())
+		Expect(k8sClient.Delete(ctx, podSet)).To(Succeed())
+	})
+})
+
+var _ = Describe("PodSet image policy", func() {
+	const (
+		namespace = "default"
+		digest    = "sha256:0d17b565c37bcbd895e9d92315a05c1c3c9a29f762b011a10c54a66cd53c9b31"
+	)
+	var policy *appv1alpha1.ImagePolicy
+	var resolver imagepolicy.Resolver
+
+	newPodSet := func(name string, images ...string) *appv1alpha1.PodSet {
+		podSet := &appv1alpha1.PodSet{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace}}
+		for i, image := range images {
+			podSet.Spec.Template.Spec.Containers = append(podSet.Spec.Template.Spec.Containers,
+				corev1.Container{Name: fmt.Sprintf("app-%d", i), Image: image})
+		}
+		return podSet
+	}
+
+	BeforeEach(func() {
+		policy = &appv1alpha1.ImagePolicy{
+			ObjectMeta: metav1.ObjectMeta{Name: appv1alpha1.ClusterImagePolicyName},
+			Spec: imagepolicy.Policy{
+				AllowedRegistries: []string{"docker.io/library", "ghcr.io"},
+				ForbidLatest:      true,
+				ResolveDigests:    true,
+			},
+		}
+		Expect(k8sClient.Create(ctx, policy)).To(Succeed())
+
+		// Stand in for the registry, ghcr.io does not know the image
+		resolver = podSetWebhook.Resolver
+		podSetWebhook.Resolver = imagepolicy.ResolverFunc(func(_ context.Context, ref imagepolicy.Reference) (string, error) {
+			if ref.Domain != "docker.io" {
+				return "", errors.New("manifest unknown")
+			}
+			return digest, nil
+		})
+	})
+
+	AfterEach(func() {
+		podSetWebhook.Resolver = resolver
+		Expect(k8sClient.Delete(ctx, policy)).To(Succeed())
+	})
+
+	It("pins admitted images to their digest", func() {
+		podSet := newPodSet("policy-pin", "nginx:1.21")
+		Expect(k8sClient.Create(ctx, podSet)).To(Succeed())
+		containers := podSet.Spec.Template.Spec.Containers
+		Expect(containers[0].Image).To(Equal("nginx:1.21@" + digest))
+		Expect(containers[0].ImagePullPolicy).To(Equal(corev1.PullIfNotPresent))
+	})
+
+	It("rejects images it cannot pin", func() {
+		err := k8sClient.Create(ctx, newPodSet("policy-unresolved", "ghcr.io/example/app:1.0"))
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("ghcr.io/example/app:1.0"))
+	})
+
+	It("keeps the digests of the unchanged images on UPDATE", func() {
+		podSet := newPodSet("policy-repin", "nginx:1.21")
+		Expect(k8sClient.Create(ctx, podSet)).To(Succeed())
+		Expect(podSet.Spec.Template.Spec.Containers[0].Image).To(Equal("nginx:1.21@" + digest))
+
+		// The tag moved on, and the manifest is applied again
+		podSetWebhook.Resolver = imagepolicy.ResolverFunc(func(context.Context, imagepolicy.Reference) (string, error) {
+			return "sha256:1111111111111111111111111111111111111111111111111111111111111111", nil
+		})
+		podSet.Spec.Template.Spec.Containers[0].Image = "nginx:1.21"
+		replicas := int32(2)
+		podSet.Spec.Replicas = &replicas
+		Expect(k8sClient.Update(ctx, podSet)).To(Succeed())
+		Expect(podSet.Spec.Template.Spec.Containers[0].Image).To(Equal("nginx:1.21@" + digest))
+	})
+
+	DescribeTable("rejects images forbidden by the policy",
+		func(image string) {
+			err := k8sClient.Create(ctx, newPodSet("policy-forbidden", image))
+			Expect(apierrors.IsInvalid(err)).To(BeTrue(), "expected an Invalid error, got %v", err)
+			Expect(err.Error()).To(ContainSubstring("spec.template.spec.containers[0].image"))
+		},
+		Entry("latest tag", "ghcr.io/example/app"),
+		Entry("registry not allowed", "quay.io/example/app:1.0"),
+		Entry("repository not allowed", "example/app:1.0"),
+	)
+
+	It("keeps admitting images that were admitted before the policy", func() {
+		podSet := newPodSet("policy-update", "quay.io/example/app:1.0")
+		Expect(k8sClient.Delete(ctx, policy)).To(Succeed())
+		Expect(k8sClient.Create(ctx, podSet)).To(Succeed())
+		policy.ResourceVersion = ""
+		Expect(k8sClient.Create(ctx, policy)).To(Succeed())
+
+		replicas := int32(2)
+		podSet.Spec.Replicas = &replicas
+		Expect(k8sClient.Update(ctx, podSet)).To(Succeed())
+		podSet.Spec.Template.Spec.Containers[0].Image = "quay.io/example/app:2.0"
+		Expect(apierrors.IsInvalid(k8sClient.Update(ctx, podSet))).To(BeTrue())
+	})
+})
+
+var _ = Describe("PodSet conversion webhook", func() {
+	const namespace = "default"
+
+	It("serves PodSets as v1beta1 and admits them through the v1alpha1 webhooks", func() {
+		podSet := &appv1beta1.PodSet{
+			ObjectMeta: metav1.ObjectMeta{Name: "beta", Namespace: namespace},
+			Spec: appv1beta1.PodSetSpec{
+				Template: corev1.PodTemplateSpec{
+					Spec: corev1.PodSpec{
+						Containers: []corev1.Container{{Name: "app", Image: "nginx:1.21"}},
+					},
+				},
+			},
+		}
+		Expect(k8sClient.Create(ctx, podSet)).To(Succeed())
+		key := types.NamespacedName{Name: podSet.Name, Namespace: namespace}
+
+		By("reading it back as v1alpha1")
+		alpha := &appv1alpha1.PodSet{}
+		Expect(k8sClient.Get(ctx, key, alpha)).To(Succeed())
+		Expect(alpha.Spec.Template.Labels).To(HaveKeyWithValue(appv1alpha1.PodSetLabel, podSet.Name))
+
+		By("reporting the ready replicas under both names")
+		alpha.Status.Replicas = 2
+		alpha.Status.AvailableReplicas = 1
+		Expect(k8sClient.Status().Update(ctx, alpha)).To(Succeed())
+		beta := &appv1beta1.PodSet{}
+		Expect(k8sClient.Get(ctx, key, beta)).To(Succeed())
+		Expect(beta.Status.ReadyReplicas).To(Equal(int32(1)))
+	})
+})
@@ -0,0 +1,67 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhooks
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/mbtamuli/k8s/common/imagepolicy"
+	appv1alpha1 "github.com/mbtamuli/k8s/podset-operator/api/v1alpha1"
+)
+
+func TestValidateName(t *testing.T) {
+	ctx := context.Background()
+	w := &PodSetWebhook{ImagePolicy: imagepolicy.StaticSource{}}
+	newPodSet := func(name string) *appv1alpha1.PodSet {
+		return &appv1alpha1.PodSet{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+			Spec: appv1alpha1.PodSetSpec{
+				Template: corev1.PodTemplateSpec{
+					ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{"app": "web"}},
+					Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "app", Image: "nginx:1.21"}}},
+				},
+			},
+		}
+	}
+
+	if err := w.ValidateCreate(ctx, newPodSet(strings.Repeat("a", 63))); err != nil {
+		t.Errorf("ValidateCreate() of a 63 character name error = %v", err)
+	}
+	// The PodSet label of the pods could not hold the name
+	err := w.ValidateCreate(ctx, newPodSet(strings.Repeat("a", 64)))
+	if !apierrors.IsInvalid(err) || !strings.Contains(err.Error(), "metadata.name") {
+		t.Errorf("ValidateCreate() of a 64 character name error = %v, want an Invalid metadata.name", err)
+	}
+	// Not named yet, the name the API server generates is short enough
+	if err := w.ValidateCreate(ctx, newPodSet("")); err != nil {
+		t.Errorf("ValidateCreate() of a PodSet without a name error = %v", err)
+	}
+}
+
+func TestValidateDelete(t *testing.T) {
+	w := &PodSetWebhook{ImagePolicy: imagepolicy.StaticSource{}}
+	podSet := &appv1alpha1.PodSet{ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"}}
+	if err := w.ValidateDelete(context.Background(), podSet); err != nil {
+		t.Errorf("ValidateDelete() error = %v", err)
+	}
+}